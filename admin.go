@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/VictoriaMetrics/fastcache"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//adminCachePath - path of the cache admin api
+const adminCachePath = "/admin/cache"
+
+//allLangs - lang parameter of the admin api selecting every language variant of a query
+const allLangs = "all"
+
+/*
+CacheEntry - a cache record as returned by the admin api
+Key - cache key, the query + lang
+Lang - lang of the record, empty for IP-API's default
+ExpirationTime - time the record goes stale
+CachedAt - time the record was cached
+Source - how the record entered the cache
+Provider - provider which looked the record up, empty if unknown
+Location - cached location, with every field
+ */
+type CacheEntry struct {
+	Key            string          `json:"key"`
+	Lang           string          `json:"lang,omitempty"`
+	ExpirationTime time.Time       `json:"expirationTime"`
+	CachedAt       time.Time       `json:"cachedAt"`
+	Source         string          `json:"source,omitempty"`
+	Provider       string          `json:"provider,omitempty"`
+	Location       ip_api.Location `json:"location"`
+}
+
+/*
+CacheStats - cache statistics of the admin api
+Records - records which haven't expired
+FailedRecords - records of failed queries
+Langs - records by lang, default for IP-API's default
+Entries - entries in fastcache, including expired records which haven't been removed yet
+Bytes - size of the cache
+GetCalls - cache reads since the start
+Misses - cache reads which found no entry
+Collisions - entries evicted by hash collisions
+Persist - whether the cache is written to disk
+ */
+type CacheStats struct {
+	Records       int            `json:"records"`
+	FailedRecords int            `json:"failedRecords"`
+	Langs         map[string]int `json:"langs"`
+	Entries       uint64         `json:"entries"`
+	Bytes         uint64         `json:"bytes"`
+	GetCalls      uint64         `json:"getCalls"`
+	Misses        uint64         `json:"misses"`
+	Collisions    uint64         `json:"collisions"`
+	Persist       bool           `json:"persist"`
+}
+
+/*
+cacheAdminHandler - handles cache admin requests, only admin clients are allowed
+GET /admin/cache/stats - cache statistics
+POST /admin/cache/write - writes the cache to disk now
+POST /admin/cache/purge?status=fail - removes every record of a status
+GET /admin/cache/records/{query}?lang= - records of a query
+DELETE /admin/cache/records/{query}?lang= - removes the records of a query
+POST /admin/cache/records/{query}/refresh?lang= - looks a query up again with IP-API
+lang selects a language variant, all for every variant, IP-API's default if empty
+ */
+func cacheAdminHandler(w http.ResponseWriter, r *http.Request) {
+	promMetrics.IncrementRequestsProcessed()
+
+	client, _ := authenticateClient(r)
+	if client == nil || !client.Admin {
+		w.Header().Set("WWW-Authenticate", `Basic realm="ip-api-proxy"`)
+		writeJSONError(w, http.StatusUnauthorized, "invalid or missing admin credentials")
+		return
+	}
+
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, adminCachePath), "/"), "/")
+	switch {
+	case len(pathParts) == 1 && pathParts[0] == "stats" && r.Method == "GET":
+		writeAdminJSON(w, http.StatusOK, cacheStats())
+	case len(pathParts) == 1 && pathParts[0] == "write" && r.Method == "POST":
+		writeCacheNow(w, client.ID)
+	case len(pathParts) == 1 && pathParts[0] == "purge" && r.Method == "POST":
+		purgeCache(w, r, client.ID)
+	case len(pathParts) == 2 && pathParts[0] == "records" && r.Method == "GET":
+		getCacheRecords(w, r, pathParts[1], client.ID)
+	case len(pathParts) == 2 && pathParts[0] == "records" && r.Method == "DELETE":
+		deleteCacheRecords(w, r, pathParts[1], client.ID)
+	case len(pathParts) == 3 && pathParts[0] == "records" && pathParts[2] == "refresh" && r.Method == "POST":
+		refreshCacheRecords(w, r, pathParts[1], client.ID)
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown admin path or method")
+	}
+}
+
+//getCacheRecords - writes the records of a query
+func getCacheRecords(w http.ResponseWriter, r *http.Request, query string, clientID string) {
+	langs, err := adminLangs(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries := cacheEntries(query, langs)
+	log.Println("Admin " + clientID + ": read " + strconv.Itoa(len(entries)) + " cache records of " + query)
+	if len(entries) == 0 {
+		writeJSONError(w, http.StatusNotFound, query+" is not cached")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, entries)
+}
+
+//deleteCacheRecords - removes the records of a query, and writes the number removed
+func deleteCacheRecords(w http.ResponseWriter, r *http.Request, query string, clientID string) {
+	langs, err := adminLangs(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deleted := 0
+	for _, lang := range langs {
+		if cache.DeleteLocation(query + lang) {
+			deleted++
+		}
+	}
+
+	log.Println("Admin " + clientID + ": deleted " + strconv.Itoa(deleted) + " cache records of " + query)
+	if deleted == 0 {
+		writeJSONError(w, http.StatusNotFound, query+" is not cached")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+/*
+refreshCacheRecords - looks a query up again with IP-API, replacing its cached records, and writes the new records
+with lang=all only the language variants which are cached are refreshed
+ */
+func refreshCacheRecords(w http.ResponseWriter, r *http.Request, query string, clientID string) {
+	langs, err := adminLangs(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if r.URL.Query().Get("lang") == allLangs {
+		var cachedLangs []string
+		for _, entry := range cacheEntries(query, langs) {
+			cachedLangs = append(cachedLangs, entry.Lang)
+		}
+		if len(cachedLangs) == 0 {
+			writeJSONError(w, http.StatusNotFound, query+" is not cached")
+			return
+		}
+		langs = cachedLangs
+	}
+
+	for _, lang := range langs {
+		//the cached record is only replaced once IP-API answers, so a failed refresh keeps serving it
+		promMetrics.IncrementQueriesProcessed()
+		if _, _, err := lookupControlledLocation(r.Context(), query, lang, cache.AllFields, LoadedConfig.APIKey, cacheControl{refresh: true}); err != nil {
+			log.Println("Admin " + clientID + ": failed refreshing " + query + ": " + err.Error())
+			writeJSONError(w, http.StatusBadGateway, "error refreshing "+query+": "+err.Error())
+			return
+		}
+	}
+
+	log.Println("Admin " + clientID + ": refreshed " + strconv.Itoa(len(langs)) + " cache records of " + query)
+	writeAdminJSON(w, http.StatusOK, cacheEntries(query, langs))
+}
+
+//purgeCache - removes every record of a status (fail by default), and writes the number removed
+func purgeCache(w http.ResponseWriter, r *http.Request, clientID string) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "fail"
+	} else if status != "fail" && status != "success" {
+		writeJSONError(w, http.StatusBadRequest, "invalid status provided, expected fail or success")
+		return
+	}
+
+	deleted := 0
+	cache.Records(func(key string, record cache.Record) {
+		if record.Location.Status == status && cache.DeleteLocation(key) {
+			deleted++
+		}
+	})
+
+	log.Println("Admin " + clientID + ": purged " + strconv.Itoa(deleted) + " " + status + " cache records")
+	writeAdminJSON(w, http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+//writeCacheNow - writes the cache to disk, without waiting for the write interval
+func writeCacheNow(w http.ResponseWriter, clientID string) {
+	if !LoadedConfig.Cache.Persist {
+		writeJSONError(w, http.StatusConflict, "cache persist is disabled")
+		return
+	}
+
+	log.Println("Admin " + clientID + ": writing cache")
+	if err := cache.WriteCache(&LoadedConfig.Cache.WriteLocation); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "error writing cache: "+err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, cacheStats())
+}
+
+//cacheStats - gets the cache statistics
+func cacheStats() CacheStats {
+	stats := CacheStats{Langs: map[string]int{}, Persist: LoadedConfig.Cache.Persist}
+	cache.Records(func(key string, record cache.Record) {
+		stats.Records++
+		if record.Location.Status == "fail" {
+			stats.FailedRecords++
+		}
+		lang := record.Lang
+		if lang == "" {
+			lang = "default"
+		}
+		stats.Langs[lang]++
+	})
+
+	var fastcacheStats fastcache.Stats
+	cache.FastCacheCache.UpdateStats(&fastcacheStats)
+	stats.Entries = fastcacheStats.EntriesCount
+	stats.Bytes = fastcacheStats.BytesSize
+	stats.GetCalls = fastcacheStats.GetCalls
+	stats.Misses = fastcacheStats.Misses
+	stats.Collisions = fastcacheStats.Collisions
+	return stats
+}
+
+/*
+cacheEntries - gets the cached records of a query
+query - IP/DNS entry
+langs - language variants, "" for IP-API's default
+ */
+func cacheEntries(query string, langs []string) []CacheEntry {
+	entries := []CacheEntry{}
+	for _, lang := range langs {
+		record, found, err := cache.GetRecord(query+lang, cache.AllFields)
+		if err != nil || !found {
+			continue
+		}
+		entries = append(entries, CacheEntry{
+			Key:            query + lang,
+			Lang:           lang,
+			ExpirationTime: record.ExpirationTime,
+			CachedAt:       recordInsertionTime(record),
+			Source:         record.Source,
+			Provider:       record.Provider,
+			Location:       record.Location,
+		})
+	}
+	return entries
+}
+
+/*
+adminLangs - gets the language variants selected by the lang parameter
+all selects IP-API's default and every language, empty only IP-API's default
+ */
+func adminLangs(query url.Values) ([]string, error) {
+	lang := query.Get("lang")
+	switch lang {
+	case "":
+		return []string{""}, nil
+	case allLangs:
+		return append([]string{""}, ip_api.AllowedLangs...), nil
+	}
+
+	lang, err := ip_api.ValidateLang(lang)
+	if err != nil {
+		return nil, err
+	}
+	return []string{lang}, nil
+}
+
+//writeAdminJSON - writes an admin api response as json
+func writeAdminJSON(w http.ResponseWriter, statusCode int, response interface{}) {
+	promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+	body, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+fakeIPAPI - sends IP-API requests to a test server for the rest of a test, so tests never call the real IP-API
+handler - handles the requests IP-API would get
+
+returns
+lookups made, one per request with its lang
+ */
+func fakeIPAPI(t *testing.T, handler http.HandlerFunc) func() []string {
+	var lookups []string
+	var lookupsMutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookupsMutex.Lock()
+		lookups = append(lookups, r.URL.Query().Get("lang"))
+		lookupsMutex.Unlock()
+		handler(w, r)
+	}))
+	serverURL, _ := url.Parse(server.URL)
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = &http.Transport{Proxy: http.ProxyURL(serverURL)}
+	successAge, failedAge := time.Hour, time.Minute
+	LoadedConfig.Cache.SuccessAgeDuration = &successAge
+	LoadedConfig.Cache.FailedAgeDuration = &failedAge
+	t.Cleanup(func() {
+		http.DefaultTransport = defaultTransport
+		server.Close()
+		LoadedConfig.Cache.SuccessAgeDuration = nil
+		LoadedConfig.Cache.FailedAgeDuration = nil
+	})
+
+	return func() []string {
+		lookupsMutex.Lock()
+		defer lookupsMutex.Unlock()
+		return append([]string{}, lookups...)
+	}
+}
+
+//setupAdmin - configures an admin and a regular client, and caches 8.8.8.8 in IP-API's default lang, de and fr
+func setupAdmin(t *testing.T) {
+	LoadedConfig.Clients = []config.Client{{ID: "admin", Key: "admin-key", Admin: true}, {ID: "user", Key: "user-key"}}
+	for _, lang := range []string{"", "de", "fr"} {
+		if _, err := cache.AddProvidedLocation("8.8.8.8", lang, ip_api.Location{Status: "success", City: "Mountain View " + lang, Query: "8.8.8.8"}, time.Hour, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		LoadedConfig.Clients = nil
+		for _, lang := range []string{"", "de", "fr"} {
+			cache.DeleteLocation("8.8.8.8" + lang)
+		}
+	})
+}
+
+//adminRequest - sends a request to cacheAdminHandler as a client
+func adminRequest(method string, path string, clientID string, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if clientID != "" {
+		r.SetBasicAuth(clientID, key)
+	}
+	w := httptest.NewRecorder()
+	cacheAdminHandler(w, r)
+	return w
+}
+
+func TestCacheAdminHandler(t *testing.T) {
+	setupAdmin(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		clientID   string
+		key        string
+		statusCode int
+	}{
+		{"no credentials", "GET", "/admin/cache/stats", "", "", http.StatusUnauthorized},
+		{"wrong key", "GET", "/admin/cache/stats", "admin", "user-key", http.StatusUnauthorized},
+		{"not an admin", "GET", "/admin/cache/stats", "user", "user-key", http.StatusUnauthorized},
+		{"not an admin deleting", "DELETE", "/admin/cache/records/8.8.8.8", "user", "user-key", http.StatusUnauthorized},
+		{"stats", "GET", "/admin/cache/stats", "admin", "admin-key", http.StatusOK},
+		{"stats wrong method", "POST", "/admin/cache/stats", "admin", "admin-key", http.StatusNotFound},
+		{"unknown path", "GET", "/admin/cache/unknown", "admin", "admin-key", http.StatusNotFound},
+		{"root", "GET", "/admin/cache", "admin", "admin-key", http.StatusNotFound},
+		{"records", "GET", "/admin/cache/records/8.8.8.8", "admin", "admin-key", http.StatusOK},
+		{"records of a lang", "GET", "/admin/cache/records/8.8.8.8?lang=de", "admin", "admin-key", http.StatusOK},
+		{"records not cached", "GET", "/admin/cache/records/8.8.4.4", "admin", "admin-key", http.StatusNotFound},
+		{"records of a lang not cached", "GET", "/admin/cache/records/8.8.8.8?lang=ja", "admin", "admin-key", http.StatusNotFound},
+		{"records invalid lang", "GET", "/admin/cache/records/8.8.8.8?lang=xx", "admin", "admin-key", http.StatusBadRequest},
+		{"records too deep", "GET", "/admin/cache/records/8.8.8.8/other", "admin", "admin-key", http.StatusNotFound},
+		{"refresh wrong method", "GET", "/admin/cache/records/8.8.8.8/refresh", "admin", "admin-key", http.StatusNotFound},
+		{"refresh all not cached", "POST", "/admin/cache/records/8.8.4.4/refresh?lang=all", "admin", "admin-key", http.StatusNotFound},
+		{"write without persist", "POST", "/admin/cache/write", "admin", "admin-key", http.StatusConflict},
+		{"purge invalid status", "POST", "/admin/cache/purge?status=other", "admin", "admin-key", http.StatusBadRequest},
+		{"delete not cached", "DELETE", "/admin/cache/records/8.8.4.4", "admin", "admin-key", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := adminRequest(test.method, test.path, test.clientID, test.key)
+			if w.Code != test.statusCode {
+				t.Errorf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+			if test.statusCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("got no WWW-Authenticate header, expected a basic auth challenge")
+			}
+		})
+	}
+
+	//rejected requests don't change the cache
+	if _, found, _ := cache.GetLocation("8.8.8.8", cache.AllFields); !found {
+		t.Errorf("got 8.8.8.8 deleted by a client which isn't an admin, expected it cached")
+	}
+}
+
+func TestGetCacheRecords(t *testing.T) {
+	setupAdmin(t)
+
+	tests := []struct {
+		name  string
+		query string
+		langs []string
+	}{
+		{"default lang", "", []string{""}},
+		{"one lang", "?lang=fr", []string{"fr"}},
+		{"all langs", "?lang=all", []string{"", "de", "fr"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := adminRequest("GET", "/admin/cache/records/8.8.8.8"+test.query, "admin", "admin-key")
+			var entries []CacheEntry
+			if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("got %s: %v", w.Body.String(), err)
+			}
+			if len(entries) != len(test.langs) {
+				t.Fatalf("got %d entries, expected %d", len(entries), len(test.langs))
+			}
+			for i, entry := range entries {
+				if entry.Lang != test.langs[i] || entry.Key != "8.8.8.8"+test.langs[i] || entry.Location.City != "Mountain View "+test.langs[i] {
+					t.Errorf("got entry %+v, expected lang %q", entry, test.langs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteCacheRecords(t *testing.T) {
+	setupAdmin(t)
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		deleted    int
+		cached     []string
+	}{
+		{"one lang", "?lang=de", http.StatusOK, 1, []string{"", "fr"}},
+		{"deleted lang", "?lang=de", http.StatusNotFound, 0, []string{"", "fr"}},
+		{"all langs", "?lang=all", http.StatusOK, 2, nil},
+		{"nothing left", "?lang=all", http.StatusNotFound, 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := adminRequest("DELETE", "/admin/cache/records/8.8.8.8"+test.query, "admin", "admin-key")
+			if w.Code != test.statusCode {
+				t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+			if test.statusCode == http.StatusOK {
+				var response map[string]int
+				if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response["deleted"] != test.deleted {
+					t.Errorf("got %s, expected %d deleted", w.Body.String(), test.deleted)
+				}
+			}
+
+			var cached []string
+			for _, entry := range cacheEntries("8.8.8.8", []string{"", "de", "fr"}) {
+				cached = append(cached, entry.Lang)
+			}
+			if len(cached) != len(test.cached) {
+				t.Errorf("got %q cached, expected %q", cached, test.cached)
+			}
+		})
+	}
+}
+
+func TestRefreshCacheRecords(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		upstream   int
+		statusCode int
+		lookups    []string
+		key        string
+		city       string
+	}{
+		{"default lang", "", http.StatusOK, http.StatusOK, []string{""}, "8.8.8.8", "Refreshed"},
+		{"one lang", "?lang=de", http.StatusOK, http.StatusOK, []string{"de"}, "8.8.8.8de", "Refreshed"},
+		{"all only refreshes cached langs", "?lang=all", http.StatusOK, http.StatusOK, []string{"", "de", "fr"}, "8.8.8.8fr", "Refreshed"},
+		{"upstream error keeps the cached record", "", http.StatusInternalServerError, http.StatusBadGateway, []string{""}, "8.8.8.8", "Mountain View "},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupAdmin(t)
+			lookups := fakeIPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				if test.upstream != http.StatusOK {
+					w.WriteHeader(test.upstream)
+					return
+				}
+				_, _ = w.Write([]byte(`{"status":"success","city":"Refreshed","query":"8.8.8.8"}`))
+			})
+
+			w := adminRequest("POST", "/admin/cache/records/8.8.8.8/refresh"+test.query, "admin", "admin-key")
+			if w.Code != test.statusCode {
+				t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+
+			madeLookups := lookups()
+			if len(madeLookups) != len(test.lookups) {
+				t.Fatalf("got lookups in langs %q, expected %q", madeLookups, test.lookups)
+			}
+			for i := range madeLookups {
+				if madeLookups[i] != test.lookups[i] {
+					t.Errorf("got lookups in langs %q, expected %q", madeLookups, test.lookups)
+				}
+			}
+
+			if location, found, _ := cache.GetLocation(test.key, cache.AllFields); !found || location.City != test.city {
+				t.Errorf("got cached %+v, expected city %q", location, test.city)
+			}
+		})
+	}
+}
+
+func TestPurgeCache(t *testing.T) {
+	setupAdmin(t)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		if _, err := cache.AddLocation(ip, ip_api.Location{Status: "fail", Message: "private range", Query: ip}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		cache.DeleteLocation("10.0.0.1")
+		cache.DeleteLocation("10.0.0.2")
+	}()
+
+	tests := []struct {
+		name     string
+		query    string
+		deleted  int
+		failed   bool
+		succeeded bool
+	}{
+		{"fail by default", "", 2, false, true},
+		{"nothing left to purge", "?status=fail", 0, false, true},
+		{"success", "?status=success", 3, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := adminRequest("POST", "/admin/cache/purge"+test.query, "admin", "admin-key")
+			var response map[string]int
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response["deleted"] != test.deleted {
+				t.Errorf("got %s, expected %d deleted", w.Body.String(), test.deleted)
+			}
+			if _, found, _ := cache.GetLocation("10.0.0.1", cache.AllFields); found != test.failed {
+				t.Errorf("got failed record cached %t, expected %t", found, test.failed)
+			}
+			if _, found, _ := cache.GetLocation("8.8.8.8de", cache.AllFields); found != test.succeeded {
+				t.Errorf("got successful record cached %t, expected %t", found, test.succeeded)
+			}
+		})
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	before := cacheStats()
+	setupAdmin(t)
+	if _, err := cache.AddProvidedLocation("10.0.0.1", "de", ip_api.Location{Status: "fail", Message: "private range", Query: "10.0.0.1"}, time.Hour, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("10.0.0.1de")
+
+	w := adminRequest("GET", "/admin/cache/stats", "admin", "admin-key")
+	var stats CacheStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Records != before.Records+4 {
+		t.Errorf("got %d records, expected %d", stats.Records, before.Records+4)
+	}
+	if stats.FailedRecords != before.FailedRecords+1 {
+		t.Errorf("got %d failed records, expected %d", stats.FailedRecords, before.FailedRecords+1)
+	}
+	expectedLangs := map[string]int{"default": 1, "de": 2, "fr": 1}
+	for lang, count := range expectedLangs {
+		if stats.Langs[lang] != before.Langs[lang]+count {
+			t.Errorf("got %d %s records, expected %d", stats.Langs[lang], lang, before.Langs[lang]+count)
+		}
+	}
+	if stats.Entries < before.Entries+4 || stats.Bytes == 0 {
+		t.Errorf("got %d entries of %d bytes, expected at least %d entries", stats.Entries, stats.Bytes, before.Entries+4)
+	}
+	if stats.Persist {
+		t.Errorf("got persist true, expected false")
+	}
+}
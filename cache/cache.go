@@ -6,27 +6,74 @@ import (
 	"github.com/BenB196/ip-api-proxy/promMetrics"
 	"github.com/VictoriaMetrics/fastcache"
 	"log"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 )
 
+//Sources of a location, how it was answered
+const (
+	SourceCache    = "cache"
+	SourceUpstream = "upstream"
+	SourceStale    = "stale"
+)
+
+/*
+Record - a cached location
+ExpirationTime - time the location goes stale
+InsertionTime - time the location was cached, zero for records cached before it was stored
+Source - how the location entered the cache, SourceUpstream for IP-API lookups
+Provider - provider which looked the location up (ex: ip-api, ip-api-pro), empty if unknown
+Location - ip_api location
+ */
 type Record struct {
 	ExpirationTime 	time.Time		`json:"expirationTime"`
+	InsertionTime	time.Time		`json:"insertionTime"`
+	Source			string			`json:"source,omitempty"`
+	Provider		string			`json:"provider,omitempty"`
+	Lang			string			`json:"lang,omitempty"`
 	Location		ip_api.Location	`json:"location"`
 }
 
-var FastCacheCache = fastcache.New(32000000)
+//maxBytes - size of the cache
+const maxBytes = 32000000
+
+var FastCacheCache = fastcache.New(maxBytes)
+
+//writeMutex - makes cache writes run one at a time
+var writeMutex sync.Mutex
+
+//saveMutex - held for reading while entries are set or deleted, and for writing while the cache is saved, so a snapshot's entry count matches its data
+var saveMutex sync.RWMutex
 
 /*
 GetLocation - function for getting the location of a query from cache
 query - IP/DNS entry
-fields - string of comma separated values
+fields - Fields to return, DefaultFields if 0
 
 returns
 ip_api Location
 error
  */
-func GetLocation(query string, fields string) (*ip_api.Location, bool, error) {
+func GetLocation(query string, fields Fields) (*ip_api.Location, bool, error) {
+	record, found, err := GetRecord(query, fields)
+	if !found || err != nil {
+		return nil, found, err
+	}
+	return &record.Location, true, nil
+}
+
+/*
+GetRecord - function for getting the cache record of a query, for callers which need its expiration time
+query - IP/DNS entry
+fields - Fields of the record's location to return, DefaultFields if 0
+
+returns
+Record, with the location projected to fields
+true if the query was found and hasn't expired
+error
+ */
+func GetRecord(query string, fields Fields) (*Record, bool, error) {
 	//Check if cache has anything in it, skip if not
 	if FastCacheCache == nil {
 		//record not found in cache return false
@@ -49,81 +96,149 @@ func GetLocation(query string, fields string) (*ip_api.Location, bool, error) {
 		if time.Now().In(loc).Sub(record.ExpirationTime) > 0 {
 			//Remove record if expired and return false
 			promMetrics.DecreaseQueriesCachedCurrent()
+			saveMutex.RLock()
 			FastCacheCache.Del(queryBytes)
+			saveMutex.RUnlock()
+			removeKey(query)
 			return nil, false, nil
 		}
 
-		location := ip_api.Location{}
-
-		//Set default fields if fields string is empty
-		if fields == "" {
-			fields = "query,status,country,countryCode,region,regionName,city,zip,lat,lon,timezone,isp,org,as"
-		}
+		record.Location = ProjectLocation(&record.Location, fields)
 
-		//check if all fields are passed, if so just return location
-		if len(fields) == len(ip_api.AllowedAPIFields) {
-			return &record.Location, true, nil
-		} else {
-			fieldSlice := strings.Split(fields,",")
-			//Loop through fields and set selected fields
-			for _, field := range fieldSlice {
-				switch field {
-				case "status":
-					location.Status = record.Location.Status
-				case "message":
-					location.Message = record.Location.Message
-				case "continent":
-					location.Continent = record.Location.Continent
-				case "continentCode":
-					location.ContinentCode = record.Location.ContinentCode
-				case "country":
-					location.Country = record.Location.Country
-				case "countryCode":
-					location.CountryCode = record.Location.CountryCode
-				case "region":
-					location.Region = record.Location.Region
-				case "regionName":
-					location.RegionName = record.Location.RegionName
-				case "city":
-					location.City = record.Location.City
-				case "district":
-					location.District = record.Location.District
-				case "zip":
-					location.ZIP = record.Location.ZIP
-				case "lat":
-					location.Lat = record.Location.Lat
-				case "lon":
-					location.Lon = record.Location.Lon
-				case "timezone":
-					location.Timezone = record.Location.Timezone
-				case "isp":
-					location.ISP = record.Location.ISP
-				case "org":
-					location.Org = record.Location.Org
-				case "as":
-					location.AS = record.Location.AS
-				case "asname":
-					location.ASName = record.Location.ASName
-				case "reverse":
-					location.Reverse = record.Location.Reverse
-				case "mobile":
-					location.Mobile = record.Location.Mobile
-				case "proxy":
-					location.Proxy = record.Location.Proxy
-				case "hosting":
-					location.Hosting = record.Location.Hosting
-				case "query":
-					location.Query = record.Location.Query
-				}
-			}
-		}
-		//Return location
-		return &location, true, nil
+		//Return record
+		return &record, true, nil
 	}
 	//record not found in cache return false
 	return nil, false, nil
 }
 
+/*
+ProjectLocation - projects a location to the fields of a request, like locations read from cache
+location - ip_api location
+fields - Fields to return, DefaultFields if 0
+ */
+func ProjectLocation(location *ip_api.Location, fields Fields) ip_api.Location {
+	//Set default fields if no fields are passed
+	if fields == 0 {
+		fields = DefaultFields
+	}
+
+	//Like IP-API, failed queries always return their status, message and query
+	if location.Status == "fail" {
+		fields |= FieldStatus | FieldMessage | FieldQuery
+	}
+
+	return projectLocation(location, fields)
+}
+
+/*
+projectLocation - copies only the selected fields of a location, the copy shares no pointers with location
+location - ip_api location
+fields - Fields to copy
+ */
+func projectLocation(location *ip_api.Location, fields Fields) ip_api.Location {
+	//all fields requested, copy everything including fields without an IP-API field value (currency)
+	if fields&AllFields == AllFields {
+		projected := *location
+		projected.Lat = copyFloat(location.Lat)
+		projected.Lon = copyFloat(location.Lon)
+		projected.Mobile = copyBool(location.Mobile)
+		projected.Proxy = copyBool(location.Proxy)
+		projected.Hosting = copyBool(location.Hosting)
+		return projected
+	}
+
+	projected := ip_api.Location{}
+	if fields&FieldStatus != 0 {
+		projected.Status = location.Status
+	}
+	if fields&FieldMessage != 0 {
+		projected.Message = location.Message
+	}
+	if fields&FieldContinent != 0 {
+		projected.Continent = location.Continent
+	}
+	if fields&FieldContinentCode != 0 {
+		projected.ContinentCode = location.ContinentCode
+	}
+	if fields&FieldCountry != 0 {
+		projected.Country = location.Country
+	}
+	if fields&FieldCountryCode != 0 {
+		projected.CountryCode = location.CountryCode
+	}
+	if fields&FieldRegion != 0 {
+		projected.Region = location.Region
+	}
+	if fields&FieldRegionName != 0 {
+		projected.RegionName = location.RegionName
+	}
+	if fields&FieldCity != 0 {
+		projected.City = location.City
+	}
+	if fields&FieldDistrict != 0 {
+		projected.District = location.District
+	}
+	if fields&FieldZIP != 0 {
+		projected.ZIP = location.ZIP
+	}
+	if fields&FieldLat != 0 {
+		projected.Lat = copyFloat(location.Lat)
+	}
+	if fields&FieldLon != 0 {
+		projected.Lon = copyFloat(location.Lon)
+	}
+	if fields&FieldTimezone != 0 {
+		projected.Timezone = location.Timezone
+	}
+	if fields&FieldISP != 0 {
+		projected.ISP = location.ISP
+	}
+	if fields&FieldOrg != 0 {
+		projected.Org = location.Org
+	}
+	if fields&FieldAS != 0 {
+		projected.AS = location.AS
+	}
+	if fields&FieldASName != 0 {
+		projected.ASName = location.ASName
+	}
+	if fields&FieldReverse != 0 {
+		projected.Reverse = location.Reverse
+	}
+	if fields&FieldMobile != 0 {
+		projected.Mobile = copyBool(location.Mobile)
+	}
+	if fields&FieldProxy != 0 {
+		projected.Proxy = copyBool(location.Proxy)
+	}
+	if fields&FieldHosting != 0 {
+		projected.Hosting = copyBool(location.Hosting)
+	}
+	if fields&FieldQuery != 0 {
+		projected.Query = location.Query
+	}
+	return projected
+}
+
+//copyFloat - copies a float pointer so that the copy can't be changed through the original
+func copyFloat(value *float32) *float32 {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+//copyBool - copies a bool pointer so that the copy can't be changed through the original
+func copyBool(value *bool) *bool {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
 /*
 AddLocation - adds a query + location to cache map along with an expiration time
 query - IP/DNS value
@@ -131,17 +246,39 @@ location - ip_api location
 expirationDuration - duration in which the query will expire (go stale)
  */
 func AddLocation(query string, location ip_api.Location, expirationDuration time.Duration) (bool, error) {
+	return AddProvidedLocation(query, "", location, expirationDuration, "")
+}
+
+/*
+AddProvidedLocation - AddLocation, recording the lang and the provider which looked the location up
+the location is cached under query + lang
+lang - lang the location was looked up in, "" for IP-API's default
+provider - provider name (ex: ip-api, ip-api-pro), empty if unknown
+ */
+func AddProvidedLocation(query string, lang string, location ip_api.Location, expirationDuration time.Duration, provider string) (bool, error) {
 	//Set timezone to UTC
 	loc, _ := time.LoadLocation("UTC")
 
-	//Get expiration time
-	expirationTime := time.Now().In(loc).Add(expirationDuration)
+	//Get insertion and expiration time
+	insertionTime := time.Now().In(loc)
 
-	//marshal record
-	record := Record{
-		ExpirationTime: expirationTime,
+	return AddRecord(query + lang, Record{
+		ExpirationTime: insertionTime.Add(expirationDuration),
+		InsertionTime:  insertionTime,
+		Source:         SourceUpstream,
+		Provider:       provider,
+		Lang:           lang,
 		Location:       location,
-	}
+	})
+}
+
+/*
+AddRecord - adds a query + record to cache as is, like a record read back from cache
+query - IP/DNS value
+record - Record, with every field of its location
+ */
+func AddRecord(query string, record Record) (bool, error) {
+	//marshal record
 	locationBytes, err := json.Marshal(record)
 
 	if err != nil {
@@ -149,7 +286,10 @@ func AddLocation(query string, location ip_api.Location, expirationDuration time
 	}
 
 	//Create and Add record to cache
+	saveMutex.RLock()
 	FastCacheCache.Set([]byte(query), locationBytes)
+	saveMutex.RUnlock()
+	addKey(query)
 
 	promMetrics.IncrementQueriesCachedTotal()
 	promMetrics.IncrementQueriesCachedCurrent()
@@ -158,30 +298,68 @@ func AddLocation(query string, location ip_api.Location, expirationDuration time
 }
 
 /*
-WriteCache - writes the cache to a file on disk to be read on app restarts
+DeleteLocation - removes a query from cache
+query - IP/DNS entry + lang, like it was cached
+
+returns
+true if the query was in cache
+ */
+func DeleteLocation(query string) bool {
+	queryBytes := []byte(query)
+	if !FastCacheCache.Has(queryBytes) {
+		return false
+	}
+
+	saveMutex.RLock()
+	FastCacheCache.Del(queryBytes)
+	saveMutex.RUnlock()
+	removeKey(query)
+	promMetrics.DecreaseQueriesCachedCurrent()
+
+	return true
+}
+
+/*
+WriteCache - writes the cache to disk as a new snapshot, to be read on app restarts
 writeLocation - string containing the write path
+
+returns
+error, the previous snapshots are kept if writing fails
  */
-func WriteCache(writeLocation *string) {
-	log.Println("Starting Cache Write")
-	//create file name
-	fileName := *writeLocation + "cache.gob"
+func WriteCache(writeLocation *string) error {
+	//writes can be triggered by the write interval, the admin api and shutdown at the same time
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
-	err := FastCacheCache.SaveToFile(fileName)
+	log.Println("Starting Cache Write")
+	header, err := writeSnapshot(*writeLocation)
 
 	if err != nil {
-		panic(err)
+		log.Println("error: CACHE WRITE FAILED, keeping the previous snapshots: " + err.Error())
+		return err
 	}
 
-	log.Println("Finished Cache Write")
+	log.Println("Finished Cache Write of snapshot " + strconv.Itoa(header.Generation) + " with " + strconv.FormatUint(header.Entries, 10) + " entries")
+	return nil
 }
 
 /*
-ReadCache - reads the cache file from disk and loads it into the Cache map
+ReadCache - reads the newest snapshot which verifies from disk and loads it into the Cache map
+corrupt snapshots are quarantined, and the cache starts empty if none can be read
 writeLocation - string containing the file path.
  */
 func ReadCache(writeLocation *string) {
-	//create filename
-	fileName := *writeLocation + "cache.gob"
+	cache, keys, header := readSnapshots(*writeLocation)
+
+	if cache == nil {
+		FastCacheCache = fastcache.New(maxBytes)
+		setKeys(nil)
+		return
+	}
 
-	FastCacheCache = fastcache.LoadFromFileOrNew(fileName, 32000000)
-}
\ No newline at end of file
+	FastCacheCache = cache
+	setKeys(keys)
+	if header != nil {
+		log.Println("Read cache snapshot " + strconv.Itoa(header.Generation) + " from " + header.Created.Format(time.RFC3339) + " with " + strconv.FormatUint(header.Entries, 10) + " entries")
+	}
+}
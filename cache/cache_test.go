@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getFullLocation() ip_api.Location {
+	var lat float32 = 40.7357
+	var lon float32 = -74.1724
+	var mobile = false
+	var proxy = true
+	var hosting = true
+
+	return ip_api.Location{
+		Status:        "success",
+		Message:       "message",
+		Continent:     "North America",
+		ContinentCode: "NA",
+		Country:       "United States",
+		CountryCode:   "US",
+		Region:        "NJ",
+		RegionName:    "New Jersey",
+		City:          "Newark",
+		District:      "Downtown",
+		ZIP:           "07175",
+		Lat:           &lat,
+		Lon:           &lon,
+		Timezone:      "America/New_York",
+		ISP:           "Google LLC",
+		Org:           "Level 3",
+		AS:            "AS15169 Google LLC",
+		ASName:        "GOOGLE",
+		Reverse:       "dns.google",
+		Mobile:        &mobile,
+		Proxy:         &proxy,
+		Hosting:       &hosting,
+		Query:         "8.8.8.8",
+	}
+}
+
+//setField - sets a single field of expected from full, used to build the expected projection
+func setField(expected *ip_api.Location, full ip_api.Location, name string) {
+	switch name {
+	case "status":
+		expected.Status = full.Status
+	case "message":
+		expected.Message = full.Message
+	case "continent":
+		expected.Continent = full.Continent
+	case "continentCode":
+		expected.ContinentCode = full.ContinentCode
+	case "country":
+		expected.Country = full.Country
+	case "countryCode":
+		expected.CountryCode = full.CountryCode
+	case "region":
+		expected.Region = full.Region
+	case "regionName":
+		expected.RegionName = full.RegionName
+	case "city":
+		expected.City = full.City
+	case "district":
+		expected.District = full.District
+	case "zip":
+		expected.ZIP = full.ZIP
+	case "lat":
+		expected.Lat = full.Lat
+	case "lon":
+		expected.Lon = full.Lon
+	case "timezone":
+		expected.Timezone = full.Timezone
+	case "isp":
+		expected.ISP = full.ISP
+	case "org":
+		expected.Org = full.Org
+	case "as":
+		expected.AS = full.AS
+	case "asname":
+		expected.ASName = full.ASName
+	case "reverse":
+		expected.Reverse = full.Reverse
+	case "mobile":
+		expected.Mobile = full.Mobile
+	case "proxy":
+		expected.Proxy = full.Proxy
+	case "hosting":
+		expected.Hosting = full.Hosting
+	case "query":
+		expected.Query = full.Query
+	}
+}
+
+func TestGetLocationSingleField(t *testing.T) {
+	full := getFullLocation()
+	_, err := AddLocation("test-single-field", full, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, apiField := range APIFields {
+		t.Run(apiField.Name, func(t *testing.T) {
+			expected := ip_api.Location{}
+			setField(&expected, full, apiField.Name)
+
+			location, found, err := GetLocation("test-single-field", apiField.Field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found {
+				t.Fatal("expected location to be found in cache")
+			}
+			if !reflect.DeepEqual(*location, expected) {
+				t.Errorf("got %+v, expected %+v", *location, expected)
+			}
+		})
+	}
+}
+
+func TestGetLocationFields(t *testing.T) {
+	full := getFullLocation()
+	_, err := AddLocation("test-fields", full, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		fields   string
+		expected []string
+	}{
+		{"default", "", IPAPIDefaultFields.Names()},
+		{"all names", "status,message,continent,continentCode,country,countryCode,region,regionName,city,district,zip,lat,lon,timezone,isp,org,as,asname,reverse,mobile,proxy,hosting,query", AllFields.Names()},
+		{"all numeric", "66846719", AllFields.Names()},
+		{"numeric", "16385", []string{"status", "country"}},
+		{"names out of order", "query,country,status", []string{"status", "country", "query"}},
+		//same string length as the number of allowed fields, must still be projected
+		{"short names", "city,lat,lon,zip,org,as", []string{"city", "zip", "lat", "lon", "org", "as"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := ParseFields(test.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := ip_api.Location{}
+			for _, name := range test.expected {
+				setField(&expected, full, name)
+			}
+
+			location, found, err := GetLocation("test-fields", fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found {
+				t.Fatal("expected location to be found in cache")
+			}
+			if !reflect.DeepEqual(*location, expected) {
+				t.Errorf("got %+v, expected %+v", *location, expected)
+			}
+		})
+	}
+}
+
+func TestGetLocationNoSharedPointers(t *testing.T) {
+	_, err := AddLocation("test-pointers", getFullLocation(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range []Fields{AllFields, FieldLat | FieldLon | FieldMobile | FieldProxy | FieldHosting} {
+		first, _, _ := GetLocation("test-pointers", fields)
+		*first.Lat = 0
+		*first.Lon = 0
+		*first.Mobile = true
+		*first.Proxy = false
+		*first.Hosting = false
+
+		second, _, _ := GetLocation("test-pointers", fields)
+		full := getFullLocation()
+		if *second.Lat != *full.Lat || *second.Lon != *full.Lon || *second.Mobile != *full.Mobile || *second.Proxy != *full.Proxy || *second.Hosting != *full.Hosting {
+			t.Errorf("fields %s: location changed through a previously returned location: %+v", fields, *second)
+		}
+	}
+}
+
+func TestDeleteLocation(t *testing.T) {
+	_, err := AddLocation("test-delete", getFullLocation(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !DeleteLocation("test-delete") {
+		t.Fatal("expected cached location to be deleted")
+	}
+	if _, found, _ := GetLocation("test-delete", AllFields); found {
+		t.Error("expected deleted location not to be found in cache")
+	}
+	Records(func(key string, record Record) {
+		if key == "test-delete" {
+			t.Error("expected deleted location to be removed from the key index")
+		}
+	})
+	if DeleteLocation("test-delete") {
+		t.Error("expected deleting a location which isn't cached to return false")
+	}
+}
+
+func TestAddProvidedLocation(t *testing.T) {
+	before := time.Now()
+	_, err := AddProvidedLocation("test-provided", "de", getFullLocation(), time.Hour, "ip-api-pro")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, found, err := GetRecord("test-providedde", FieldStatus)
+	if err != nil || !found {
+		t.Fatalf("expected record to be found, got found %v err %v", found, err)
+	}
+	if record.InsertionTime.Before(before) || record.InsertionTime.After(time.Now()) {
+		t.Errorf("unexpected insertion time %s", record.InsertionTime)
+	}
+	if !record.ExpirationTime.Equal(record.InsertionTime.Add(time.Hour)) {
+		t.Errorf("expected expiration an hour after insertion, got %s and %s", record.InsertionTime, record.ExpirationTime)
+	}
+	if record.Source != SourceUpstream || record.Provider != "ip-api-pro" || record.Lang != "de" {
+		t.Errorf("unexpected source %q, provider %q and lang %q", record.Source, record.Provider, record.Lang)
+	}
+
+	//a record added back as is keeps its insertion time
+	_, err = AddRecord("test-provided-copy", *record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, _, _ := GetRecord("test-provided-copy", FieldStatus)
+	if copied == nil || !copied.InsertionTime.Equal(record.InsertionTime) || copied.Provider != record.Provider || copied.Lang != record.Lang {
+		t.Errorf("expected copied record to keep its metadata, got %+v", copied)
+	}
+}
+
+func TestProjectLocationNoSharedPointers(t *testing.T) {
+	full := getFullLocation()
+	for _, fields := range []Fields{AllFields, FieldLat | FieldLon | FieldMobile | FieldProxy | FieldHosting} {
+		projected := projectLocation(&full, fields)
+		if projected.Lat == full.Lat || projected.Lon == full.Lon || projected.Mobile == full.Mobile || projected.Proxy == full.Proxy || projected.Hosting == full.Hosting {
+			t.Errorf("fields %s: projected location shares pointers with the original", fields)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	tests := []struct {
+		fields   string
+		expected Fields
+		err      bool
+	}{
+		{"", 0, false},
+		{"status", FieldStatus, false},
+		{"status,country,query", FieldStatus | FieldCountry | FieldQuery, false},
+		{"66846719", AllFields, false},
+		{"61439", IPAPIDefaultFields | FieldMessage, false},
+		{"bad", 0, true},
+		{"status,bad", 0, true},
+		{"8388608", 0, true},
+	}
+
+	for _, test := range tests {
+		fields, err := ParseFields(test.fields)
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error value: %v", test.fields, err)
+		}
+		if fields != test.expected {
+			t.Errorf("%q: got %s, expected %s", test.fields, fields, test.expected)
+		}
+	}
+}
+
+func TestSnapshotGenerations(t *testing.T) {
+	writeLocation := t.TempDir()
+	SnapshotGenerations = 2
+	defer func() { SnapshotGenerations = 3 }()
+
+	for _, query := range []string{"test-snapshot-1", "test-snapshot-2", "test-snapshot-3"} {
+		if _, err := AddLocation(query, getFullLocation(), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteCache(&writeLocation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := listSnapshots(writeLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].generation != 3 || snapshots[1].generation != 2 {
+		t.Fatalf("expected generations 3 and 2 to be kept, got %+v", snapshots)
+	}
+
+	FastCacheCache.Reset()
+	setKeys(nil)
+	ReadCache(&writeLocation)
+	if _, found, _ := GetLocation("test-snapshot-3", AllFields); !found {
+		t.Error("expected the newest snapshot to be read")
+	}
+	found := false
+	Records(func(key string, record Record) {
+		found = found || key == "test-snapshot-3"
+	})
+	if !found {
+		t.Error("expected the key index to be read with the snapshot")
+	}
+}
+
+func TestCorruptSnapshotFallsBack(t *testing.T) {
+	writeLocation := t.TempDir()
+
+	if _, err := AddLocation("test-corrupt-old", getFullLocation(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCache(&writeLocation); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddLocation("test-corrupt-new", getFullLocation(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCache(&writeLocation); err != nil {
+		t.Fatal(err)
+	}
+
+	//corrupt the newest snapshot's key index
+	snapshots, _ := listSnapshots(writeLocation)
+	if err := ioutil.WriteFile(filepath.Join(snapshots[0].path, snapshotKeysFile), []byte(`["corrupt"]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	FastCacheCache.Reset()
+	ReadCache(&writeLocation)
+	if _, found, _ := GetLocation("test-corrupt-old", AllFields); !found {
+		t.Error("expected the previous snapshot to be read")
+	}
+	if _, found, _ := GetLocation("test-corrupt-new", AllFields); found {
+		t.Error("expected the corrupt snapshot not to be read")
+	}
+
+	if _, err := os.Stat(snapshots[0].path); !os.IsNotExist(err) {
+		t.Error("expected the corrupt snapshot to be moved")
+	}
+	quarantined, _ := ioutil.ReadDir(filepath.Join(writeLocation, quarantineDir))
+	if len(quarantined) != 1 {
+		t.Errorf("expected the corrupt snapshot to be quarantined, got %d quarantined", len(quarantined))
+	}
+}
+
+func TestKeyIndexPrunesEvictedKeys(t *testing.T) {
+	FastCacheCache.Reset()
+	defer setKeys(nil)
+
+	//keys fastcache has evicted stay in the index until the next prune
+	evicted := make([]string, minKeyIndexPrune-1)
+	for i := range evicted {
+		evicted[i] = "test-evicted-" + strconv.Itoa(i)
+	}
+	setKeys(evicted)
+	keyIndexPruneAt = minKeyIndexPrune
+
+	if _, err := AddLocation("test-prune", getFullLocation(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	keys := indexedKeys()
+	if len(keys) != 1 || keys[0] != "test-prune" {
+		t.Errorf("got %d indexed keys, expected only test-prune", len(keys))
+	}
+	if keyIndexPruneAt != minKeyIndexPrune {
+		t.Errorf("got next prune at %d, expected %d", keyIndexPruneAt, minKeyIndexPrune)
+	}
+}
+
+func TestSnapshotWhileCaching(t *testing.T) {
+	writeLocation := t.TempDir()
+	FastCacheCache.Reset()
+	defer setKeys(nil)
+
+	//entries keep being set and deleted while snapshots are written, every snapshot must still verify
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			query := "test-while-caching-" + strconv.Itoa(i%500)
+			if i%3 == 0 {
+				DeleteLocation(query)
+			} else if _, err := AddLocation(query, getFullLocation(), time.Hour); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		header, err := writeSnapshot(writeLocation)
+		if err != nil {
+			close(stop)
+			t.Fatal(err)
+		}
+		_, _, readHeader, err := readSnapshot(filepath.Join(writeLocation, snapshotPrefix+strconv.Itoa(header.Generation)+snapshotSuffix))
+		if err != nil {
+			t.Errorf("got snapshot %d unreadable: %v", header.Generation, err)
+		} else if readHeader.Entries != header.Entries {
+			t.Errorf("got %d entries read, expected %d", readHeader.Entries, header.Entries)
+		}
+	}
+	close(stop)
+	<-done
+}
@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"strconv"
+	"strings"
+)
+
+//Fields - set of IP-API fields, each bit is the field's IP-API numeric value
+type Fields uint32
+
+//IP-API numeric field values, see http://ip-api.com/docs/api:json
+const (
+	FieldCountry       Fields = 1
+	FieldCountryCode   Fields = 2
+	FieldRegion        Fields = 4
+	FieldRegionName    Fields = 8
+	FieldCity          Fields = 16
+	FieldZIP           Fields = 32
+	FieldLat           Fields = 64
+	FieldLon           Fields = 128
+	FieldTimezone      Fields = 256
+	FieldISP           Fields = 512
+	FieldOrg           Fields = 1024
+	FieldAS            Fields = 2048
+	FieldReverse       Fields = 4096
+	FieldQuery         Fields = 8192
+	FieldStatus        Fields = 16384
+	FieldMessage       Fields = 32768
+	FieldMobile        Fields = 65536
+	FieldProxy         Fields = 131072
+	FieldDistrict      Fields = 524288
+	FieldContinent     Fields = 1048576
+	FieldContinentCode Fields = 2097152
+	FieldASName        Fields = 4194304
+	FieldHosting       Fields = 16777216
+)
+
+/*
+APIField - an IP-API field name and its numeric value
+ */
+type APIField struct {
+	Name  string
+	Field Fields
+}
+
+//APIFields - every field the proxy supports, in the order IP-API outputs them
+var APIFields = []APIField{
+	{"status", FieldStatus},
+	{"message", FieldMessage},
+	{"continent", FieldContinent},
+	{"continentCode", FieldContinentCode},
+	{"country", FieldCountry},
+	{"countryCode", FieldCountryCode},
+	{"region", FieldRegion},
+	{"regionName", FieldRegionName},
+	{"city", FieldCity},
+	{"district", FieldDistrict},
+	{"zip", FieldZIP},
+	{"lat", FieldLat},
+	{"lon", FieldLon},
+	{"timezone", FieldTimezone},
+	{"isp", FieldISP},
+	{"org", FieldOrg},
+	{"as", FieldAS},
+	{"asname", FieldASName},
+	{"reverse", FieldReverse},
+	{"mobile", FieldMobile},
+	{"proxy", FieldProxy},
+	{"hosting", FieldHosting},
+	{"query", FieldQuery},
+}
+
+//AllFields - every field the proxy supports
+const AllFields = FieldStatus | FieldMessage | FieldContinent | FieldContinentCode | FieldCountry | FieldCountryCode |
+	FieldRegion | FieldRegionName | FieldCity | FieldDistrict | FieldZIP | FieldLat | FieldLon | FieldTimezone |
+	FieldISP | FieldOrg | FieldAS | FieldASName | FieldReverse | FieldMobile | FieldProxy | FieldHosting | FieldQuery
+
+//IPAPIDefaultFields - fields IP-API returns when none are requested
+const IPAPIDefaultFields = FieldQuery | FieldStatus | FieldCountry | FieldCountryCode | FieldRegion | FieldRegionName |
+	FieldCity | FieldZIP | FieldLat | FieldLon | FieldTimezone | FieldISP | FieldOrg | FieldAS
+
+//DefaultFields - fields returned when none are requested, set from the config's defaultFields
+var DefaultFields = IPAPIDefaultFields
+
+/*
+ParseFields - parses and validates an IP-API fields value
+fields - string of comma separated values, or IP-API's numeric field value (ex: 66846719)
+
+returns
+Fields, 0 if fields is empty
+error
+ */
+func ParseFields(fields string) (Fields, error) {
+	if fields == "" {
+		return 0, nil
+	}
+
+	//numeric field value, bits for fields the proxy doesn't support (offset, currency) are ignored like IP-API ignores unknown bits
+	if mask, err := strconv.ParseUint(fields, 10, 32); err == nil {
+		parsedFields := Fields(mask) & AllFields
+		if mask != 0 && parsedFields == 0 {
+			return 0, errors.New("error: illegal field value provided: " + fields)
+		}
+		return parsedFields, nil
+	}
+
+	validatedFields, err := ip_api.ValidateFields(fields)
+	if err != nil {
+		return 0, err
+	}
+
+	var parsedFields Fields
+	for _, name := range strings.Split(validatedFields, ",") {
+		parsedFields |= FieldByName(name)
+	}
+	return parsedFields, nil
+}
+
+/*
+FieldByName - gets the Fields value of a single IP-API field name
+name - IP-API field name (ex: countryCode)
+
+returns
+Fields, 0 if the name isn't a supported field
+ */
+func FieldByName(name string) Fields {
+	for _, apiField := range APIFields {
+		if apiField.Name == name {
+			return apiField.Field
+		}
+	}
+	return 0
+}
+
+/*
+Names - gets the field names in IP-API's output order
+ */
+func (f Fields) Names() []string {
+	var names []string
+	for _, apiField := range APIFields {
+		if f&apiField.Field != 0 {
+			names = append(names, apiField.Name)
+		}
+	}
+	return names
+}
+
+/*
+String - gets the fields as a string of comma separated values
+ */
+func (f Fields) String() string {
+	return strings.Join(f.Names(), ",")
+}
+
+/*
+LocationFieldValue - gets the string value of a single location field
+location - ip_api location
+field - IP-API field name
+
+returns
+string value
+PHP serialize type
+ */
+func LocationFieldValue(location *ip_api.Location, field string) (string, string) {
+	switch field {
+	case "status":
+		return location.Status, "s"
+	case "message":
+		return location.Message, "s"
+	case "continent":
+		return location.Continent, "s"
+	case "continentCode":
+		return location.ContinentCode, "s"
+	case "country":
+		return location.Country, "s"
+	case "countryCode":
+		return location.CountryCode, "s"
+	case "region":
+		return location.Region, "s"
+	case "regionName":
+		return location.RegionName, "s"
+	case "city":
+		return location.City, "s"
+	case "district":
+		return location.District, "s"
+	case "zip":
+		return location.ZIP, "s"
+	case "lat":
+		return formatFloat(location.Lat)
+	case "lon":
+		return formatFloat(location.Lon)
+	case "timezone":
+		return location.Timezone, "s"
+	case "currency":
+		return location.Currency, "s"
+	case "isp":
+		return location.ISP, "s"
+	case "org":
+		return location.Org, "s"
+	case "as":
+		return location.AS, "s"
+	case "asname":
+		return location.ASName, "s"
+	case "reverse":
+		return location.Reverse, "s"
+	case "mobile":
+		return formatBool(location.Mobile)
+	case "proxy":
+		return formatBool(location.Proxy)
+	case "hosting":
+		return formatBool(location.Hosting)
+	case "query":
+		return location.Query, "s"
+	}
+	return "", "s"
+}
+
+//formatFloat - formats a float field, missing values are an empty string
+func formatFloat(value *float32) (string, string) {
+	if value == nil {
+		return "", "s"
+	}
+	return strconv.FormatFloat(float64(*value), 'f', -1, 32), "d"
+}
+
+//formatBool - formats a bool field, missing values are an empty string
+func formatBool(value *bool) (string, string) {
+	if value == nil {
+		return "", "s"
+	}
+	return strconv.FormatBool(*value), "b"
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//minKeyIndexPrune - key index size below which evicted keys aren't pruned on insert
+const minKeyIndexPrune = 1024
+
+//fastcache can't be iterated, so the keys in the cache are indexed separately
+var keyIndex = map[string]struct{}{}
+var keyIndexMutex sync.Mutex
+
+//keyIndexPruneAt - key index size at which the next insert prunes keys fastcache has evicted
+var keyIndexPruneAt = minKeyIndexPrune
+
+/*
+addKey - adds a key to the key index
+fastcache evicts records without telling the index, so once the index doubles in size since the last prune, evicted keys are pruned
+key - cache key (query + lang)
+ */
+func addKey(key string) {
+	keyIndexMutex.Lock()
+	defer keyIndexMutex.Unlock()
+	keyIndex[key] = struct{}{}
+	if len(keyIndex) < keyIndexPruneAt {
+		return
+	}
+
+	for indexedKey := range keyIndex {
+		if !FastCacheCache.Has([]byte(indexedKey)) {
+			delete(keyIndex, indexedKey)
+		}
+	}
+	keyIndexPruneAt = nextKeyIndexPrune(len(keyIndex))
+}
+
+//nextKeyIndexPrune - key index size at which to prune next, after pruning to size
+func nextKeyIndexPrune(size int) int {
+	if 2*size < minKeyIndexPrune {
+		return minKeyIndexPrune
+	}
+	return 2 * size
+}
+
+//removeKey - removes a key from the key index
+func removeKey(key string) {
+	keyIndexMutex.Lock()
+	delete(keyIndex, key)
+	keyIndexMutex.Unlock()
+}
+
+//indexedKeys - gets a copy of the keys in the key index
+func indexedKeys() []string {
+	keyIndexMutex.Lock()
+	defer keyIndexMutex.Unlock()
+	keys := make([]string, 0, len(keyIndex))
+	for key := range keyIndex {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+/*
+Records - calls fn for every non-expired record in the cache
+expired records are removed, and keys which fastcache has evicted are removed from the index
+fn - called with the cache key (query + lang) and record
+ */
+func Records(fn func(key string, record Record)) {
+	for _, key := range indexedKeys() {
+		keyBytes := []byte(key)
+		recordBytes, found := FastCacheCache.HasGet(nil, keyBytes)
+		if !found {
+			removeKey(key)
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(recordBytes, &record); err != nil {
+			log.Println("error: reading cache record " + key + ": " + err.Error())
+			continue
+		}
+
+		if time.Now().UTC().Sub(record.ExpirationTime) > 0 {
+			promMetrics.DecreaseQueriesCachedCurrent()
+			saveMutex.RLock()
+			FastCacheCache.Del(keyBytes)
+			saveMutex.RUnlock()
+			removeKey(key)
+			continue
+		}
+
+		fn(key, record)
+	}
+}
+
+/*
+writeKeys - writes the key index to a file next to the cache file
+fileName - key index file path
+ */
+func writeKeys(fileName string) error {
+	keysBytes, err := json.Marshal(indexedKeys())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, keysBytes, 0644)
+}
+
+/*
+readKeys - reads a key index file, caches written before the index existed have no key index file
+fileName - key index file path
+
+returns
+keys, nil if there is no key index file
+error
+ */
+func readKeys(fileName string) ([]string, error) {
+	keysBytes, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		log.Println("No cache key index found, cached records from before this version can't be exported until they are cached again")
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err = json.Unmarshal(keysBytes, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+//setKeys - replaces the key index
+func setKeys(keys []string) {
+	keyIndexMutex.Lock()
+	keyIndex = make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		keyIndex[key] = struct{}{}
+	}
+	keyIndexPruneAt = nextKeyIndexPrune(len(keyIndex))
+	keyIndexMutex.Unlock()
+}
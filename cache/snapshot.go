@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/VictoriaMetrics/fastcache"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//snapshotFormatVersion - version of the snapshot layout, snapshots of other versions are quarantined
+const snapshotFormatVersion = 1
+
+//Snapshot layout, a generation directory holding fastcache's data, the key index and the header written last
+const (
+	snapshotPrefix     = "cache-"
+	snapshotSuffix     = ".gob"
+	snapshotTmpSuffix  = ".tmp"
+	snapshotDataDir    = "data"
+	snapshotKeysFile   = "keys.json"
+	snapshotHeaderFile = "header.json"
+	quarantineDir      = "quarantine"
+)
+
+//Cache files written before snapshots, read if there is no snapshot yet
+const (
+	legacyCacheFile = "cache.gob"
+	legacyKeysFile  = "cache.keys"
+)
+
+//SnapshotGenerations - snapshots kept on disk, older ones are removed after each write
+var SnapshotGenerations = 3
+
+/*
+SnapshotHeader - header of a cache snapshot, used to verify it before it is read
+FormatVersion - snapshotFormatVersion it was written with
+Generation - generation of the snapshot, increasing with every write
+Created - time the snapshot was written
+Entries - entries in the snapshot's fastcache data
+Checksum - hex sha256 of the snapshot's data and key index
+ */
+type SnapshotHeader struct {
+	FormatVersion int       `json:"formatVersion"`
+	Generation    int       `json:"generation"`
+	Created       time.Time `json:"created"`
+	Entries       uint64    `json:"entries"`
+	Checksum      string    `json:"checksum"`
+}
+
+/*
+snapshot - a snapshot generation found on disk
+generation - generation number from its name
+path - snapshot directory
+ */
+type snapshot struct {
+	generation int
+	path       string
+}
+
+/*
+writeSnapshot - writes the cache as the next snapshot generation, and removes the generations past SnapshotGenerations
+the snapshot is written to a temp directory, verified by reading it back, and then renamed into place, so a crash never leaves a partial snapshot
+writeLocation - directory the snapshots are written in
+
+returns
+header of the snapshot
+error
+ */
+func writeSnapshot(writeLocation string) (*SnapshotHeader, error) {
+	snapshots, err := listSnapshots(writeLocation)
+	if err != nil {
+		return nil, err
+	}
+	generation := 1
+	if len(snapshots) > 0 {
+		generation = snapshots[0].generation + 1
+	}
+
+	path := filepath.Join(writeLocation, snapshotPrefix+strconv.Itoa(generation)+snapshotSuffix)
+	tmpPath := path + snapshotTmpSuffix
+	if err = os.RemoveAll(tmpPath); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpPath)
+	if err = os.MkdirAll(tmpPath, 0755); err != nil {
+		return nil, err
+	}
+
+	//entries aren't set or deleted while the cache is saved, so the live cache's count is the count of what was saved
+	var stats fastcache.Stats
+	saveMutex.Lock()
+	err = FastCacheCache.SaveToFile(filepath.Join(tmpPath, snapshotDataDir))
+	FastCacheCache.UpdateStats(&stats)
+	saveMutex.Unlock()
+	if err != nil {
+		return nil, errors.New("error: saving cache data: " + err.Error())
+	}
+	if err = writeKeys(filepath.Join(tmpPath, snapshotKeysFile)); err != nil {
+		return nil, errors.New("error: saving cache key index: " + err.Error())
+	}
+	//the data files are synced before the header, so a crash after the rename can't leave a header over unwritten data
+	if err = syncFiles(tmpPath); err != nil {
+		return nil, errors.New("error: syncing cache data: " + err.Error())
+	}
+
+	checksum, err := snapshotChecksum(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	header := SnapshotHeader{
+		FormatVersion: snapshotFormatVersion,
+		Generation:    generation,
+		Created:       time.Now().UTC(),
+		Entries:       stats.EntriesCount,
+		Checksum:      checksum,
+	}
+	if err = writeSyncedJSON(filepath.Join(tmpPath, snapshotHeaderFile), header); err != nil {
+		return nil, errors.New("error: writing snapshot header: " + err.Error())
+	}
+	syncDir(tmpPath)
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+	syncDir(writeLocation)
+
+	//the snapshot is in place, so older generations and the files from before snapshots can go
+	for i, old := range append([]snapshot{{generation: generation, path: path}}, snapshots...) {
+		if i >= SnapshotGenerations {
+			if err := os.RemoveAll(old.path); err != nil {
+				log.Println("error: removing cache snapshot " + old.path + ": " + err.Error())
+			}
+		}
+	}
+	_ = os.RemoveAll(filepath.Join(writeLocation, legacyCacheFile))
+	_ = os.Remove(filepath.Join(writeLocation, legacyKeysFile))
+
+	return &header, nil
+}
+
+/*
+readSnapshots - reads the newest snapshot which verifies, quarantining every corrupt snapshot newer than it
+without snapshots, the cache files written before snapshots are read
+writeLocation - directory the snapshots are written in
+
+returns
+fastcache cache, nil if no snapshot could be read
+keys of its key index, nil if it has none
+header of the snapshot read, nil for the files from before snapshots
+ */
+func readSnapshots(writeLocation string) (*fastcache.Cache, []string, *SnapshotHeader) {
+	snapshots, err := listSnapshots(writeLocation)
+	if err != nil {
+		log.Println("error: listing cache snapshots: " + err.Error())
+	}
+
+	for _, snapshot := range snapshots {
+		cache, keys, header, err := readSnapshot(snapshot.path)
+		if err == nil {
+			return cache, keys, header
+		}
+		quarantineSnapshot(writeLocation, snapshot.path, err)
+	}
+
+	//caches written before snapshots have no header to verify, but fastcache still fails on a corrupt file
+	legacyPath := filepath.Join(writeLocation, legacyCacheFile)
+	if _, err := os.Stat(legacyPath); err != nil {
+		if len(snapshots) > 0 {
+			log.Println("error: CACHE LOST: no cache snapshot in " + writeLocation + " could be read, starting with an empty cache")
+		}
+		return nil, nil, nil
+	}
+	cache, err := fastcache.LoadFromFile(legacyPath)
+	if err != nil {
+		quarantineSnapshot(writeLocation, legacyPath, err)
+		log.Println("error: CACHE LOST: no cache snapshot in " + writeLocation + " could be read, starting with an empty cache")
+		return nil, nil, nil
+	}
+	keys, err := readKeys(filepath.Join(writeLocation, legacyKeysFile))
+	if err != nil {
+		log.Println("error: reading cache key index: " + err.Error())
+	}
+	return cache, keys, nil
+}
+
+/*
+readSnapshot - reads and verifies a snapshot against its header
+path - snapshot directory
+
+returns
+fastcache cache
+keys of its key index
+header of the snapshot
+error if the snapshot is corrupt
+ */
+func readSnapshot(path string) (*fastcache.Cache, []string, *SnapshotHeader, error) {
+	headerBytes, err := ioutil.ReadFile(filepath.Join(path, snapshotHeaderFile))
+	if err != nil {
+		return nil, nil, nil, errors.New("reading header: " + err.Error())
+	}
+	var header SnapshotHeader
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, nil, nil, errors.New("parsing header: " + err.Error())
+	}
+	if header.FormatVersion != snapshotFormatVersion {
+		return nil, nil, nil, errors.New("unsupported format version " + strconv.Itoa(header.FormatVersion))
+	}
+
+	checksum, err := snapshotChecksum(path)
+	if err != nil {
+		return nil, nil, nil, err
+	} else if checksum != header.Checksum {
+		return nil, nil, nil, errors.New("checksum mismatch, expected " + header.Checksum + " got " + checksum)
+	}
+
+	cache, err := fastcache.LoadFromFile(filepath.Join(path, snapshotDataDir))
+	if err != nil {
+		return nil, nil, nil, errors.New("loading data: " + err.Error())
+	}
+	var stats fastcache.Stats
+	cache.UpdateStats(&stats)
+	if stats.EntriesCount != header.Entries {
+		cache.Reset()
+		return nil, nil, nil, errors.New("expected " + strconv.FormatUint(header.Entries, 10) + " entries, got " + strconv.FormatUint(stats.EntriesCount, 10))
+	}
+
+	keys, err := readKeys(filepath.Join(path, snapshotKeysFile))
+	if err != nil {
+		cache.Reset()
+		return nil, nil, nil, errors.New("reading key index: " + err.Error())
+	}
+	return cache, keys, &header, nil
+}
+
+/*
+quarantineSnapshot - moves a corrupt snapshot out of the way, keeping it for inspection
+writeLocation - directory the snapshots are written in
+path - corrupt snapshot
+reason - why the snapshot is corrupt
+ */
+func quarantineSnapshot(writeLocation string, path string, reason error) {
+	quarantinePath := filepath.Join(writeLocation, quarantineDir, filepath.Base(path)+"."+strconv.FormatInt(time.Now().Unix(), 10))
+	err := os.MkdirAll(filepath.Dir(quarantinePath), 0755)
+	if err == nil {
+		err = os.Rename(path, quarantinePath)
+	}
+	if err != nil {
+		log.Println("error: CORRUPT CACHE SNAPSHOT " + path + ": " + reason.Error() + ", it could not be quarantined: " + err.Error())
+		return
+	}
+	log.Println("error: CORRUPT CACHE SNAPSHOT " + path + ": " + reason.Error() + ", quarantined to " + quarantinePath + ", falling back to the previous snapshot")
+}
+
+/*
+listSnapshots - lists the snapshots in a directory, newest generation first
+temp directories left by an interrupted write are removed
+writeLocation - directory the snapshots are written in
+ */
+func listSnapshots(writeLocation string) ([]snapshot, error) {
+	//an empty write location is the working directory
+	dir := writeLocation
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, snapshotSuffix+snapshotTmpSuffix) {
+			_ = os.RemoveAll(filepath.Join(writeLocation, name))
+			continue
+		}
+		generation, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{generation: generation, path: filepath.Join(writeLocation, name)})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].generation > snapshots[j].generation
+	})
+	return snapshots, nil
+}
+
+/*
+snapshotChecksum - gets the hex sha256 of a snapshot's files other than its header, in name order
+path - snapshot directory
+ */
+func snapshotChecksum(path string) (string, error) {
+	var fileNames []string
+	err := filepath.Walk(path, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() != snapshotHeaderFile {
+			fileNames = append(fileNames, fileName)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(fileNames)
+
+	hash := sha256.New()
+	for _, fileName := range fileNames {
+		relativeName, _ := filepath.Rel(path, fileName)
+		hash.Write([]byte(filepath.ToSlash(relativeName) + "\x00"))
+		file, err := os.Open(fileName)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//writeSyncedJSON - writes a value as json to a file, and syncs it to disk
+func writeSyncedJSON(fileName string, value interface{}) error {
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(valueBytes); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//syncFiles - syncs every file and directory under a directory to disk
+func syncFiles(dir string) error {
+	return filepath.Walk(dir, func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			syncDir(fileName)
+			return nil
+		}
+		//files are opened for writing, as some platforms only sync files opened for writing
+		file, err := os.OpenFile(fileName, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		err = file.Sync()
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
+
+//syncDir - syncs a directory to disk, so a rename in it survives a crash
+func syncDir(dir string) {
+	if file, err := os.Open(dir); err == nil {
+		_ = file.Sync()
+		file.Close()
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+cacheControl - how a query may use the cache
+noCache - skip the cache, the location is looked up with IP-API and isn't cached
+refresh - skip the cache, the location is looked up with IP-API and replaces the cached one
+maxAge - only use a cached location which was cached at most this long ago, 0 for any
+ */
+type cacheControl struct {
+	noCache bool
+	refresh bool
+	maxAge  time.Duration
+}
+
+//bypass - checks if the cache is skipped
+func (c cacheControl) bypass() bool {
+	return c.noCache || c.refresh
+}
+
+/*
+batchCacheControl - cache control of a batch entry, next to IP-API's query, fields and lang
+NoCache - nocache
+Refresh - refresh
+MaxAge - maxAge in seconds
+ */
+type batchCacheControl struct {
+	NoCache bool `json:"nocache,omitempty"`
+	Refresh bool `json:"refresh,omitempty"`
+	MaxAge  *int `json:"maxAge,omitempty"`
+}
+
+/*
+batchRequest - a batch entry
+ */
+type batchRequest struct {
+	ip_api.QueryIP
+	batchCacheControl
+}
+
+/*
+cacheControlAllowed - checks if a request may control the cache
+if cache control is restricted, only clients with cache control enabled may
+r - http request
+ */
+func cacheControlAllowed(r *http.Request) bool {
+	if !LoadedConfig.CacheControl.Enabled {
+		return false
+	} else if !LoadedConfig.CacheControl.Restricted {
+		return true
+	}
+	client, _ := authenticateClient(r)
+	return client != nil && client.CacheControl
+}
+
+/*
+requestCacheControl - gets the cache control of a request, from its nocache, refresh and maxAge parameters and Cache-Control header
+Cache-Control no-store is nocache, no-cache is refresh, and max-age is maxAge
+browsers send the header when reloading a page, so it is only used if enabled with cacheControl header, and only for requests which may control the cache
+r - http request
+
+returns
+cacheControl
+true if the request may control the cache, for the entries of a batch
+error for invalid values, or parameters of a request which may not control the cache
+ */
+func requestCacheControl(r *http.Request) (cacheControl, bool, error) {
+	var control cacheControl
+	allowed := cacheControlAllowed(r)
+
+	query := r.URL.Query()
+	if query.Get("nocache") != "" || query.Get("refresh") != "" || query.Get("maxAge") != "" {
+		if !allowed {
+			return control, false, errors.New("cache control is not allowed")
+		}
+
+		var err error
+		entry := batchCacheControl{}
+		if value := query.Get("nocache"); value != "" {
+			if entry.NoCache, err = strconv.ParseBool(value); err != nil {
+				return control, allowed, errors.New("invalid nocache provided, expected true or false")
+			}
+		}
+		if value := query.Get("refresh"); value != "" {
+			if entry.Refresh, err = strconv.ParseBool(value); err != nil {
+				return control, allowed, errors.New("invalid refresh provided, expected true or false")
+			}
+		}
+		if value := query.Get("maxAge"); value != "" {
+			maxAge, err := strconv.Atoi(value)
+			if err != nil {
+				return control, allowed, errors.New("invalid maxAge provided, expected seconds")
+			}
+			entry.MaxAge = &maxAge
+		}
+		control, err = entry.merge(control, allowed)
+		if err != nil {
+			return control, allowed, err
+		}
+	}
+
+	if !allowed || !LoadedConfig.CacheControl.Header {
+		return control, allowed, nil
+	}
+	for _, directive := range strings.Split(strings.Join(r.Header.Values("Cache-Control"), ","), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store":
+			control.noCache = true
+		case directive == "no-cache":
+			control.refresh = true
+		case strings.HasPrefix(directive, "max-age="):
+			maxAge, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || maxAge < 0 {
+				return control, allowed, errors.New("invalid Cache-Control max-age provided, expected seconds")
+			}
+			control = control.withMaxAge(maxAge)
+		}
+	}
+
+	return control, allowed, nil
+}
+
+/*
+merge - adds the cache control of a batch entry to the cache control of its batch
+base - cache control of the batch
+allowed - whether the request may control the cache
+
+returns
+cacheControl
+error for an invalid maxAge, or an entry controlling the cache of a request which may not
+ */
+func (c batchCacheControl) merge(base cacheControl, allowed bool) (cacheControl, error) {
+	if !c.NoCache && !c.Refresh && c.MaxAge == nil {
+		return base, nil
+	} else if !allowed {
+		return base, errors.New("cache control is not allowed")
+	}
+
+	base.noCache = base.noCache || c.NoCache
+	base.refresh = base.refresh || c.Refresh
+	if c.MaxAge != nil {
+		if *c.MaxAge < 0 {
+			return base, errors.New("invalid maxAge provided, expected seconds")
+		}
+		base = base.withMaxAge(*c.MaxAge)
+	}
+	return base, nil
+}
+
+//withMaxAge - adds a max age in seconds, keeping the strictest, a max age of 0 refreshes
+func (c cacheControl) withMaxAge(seconds int) cacheControl {
+	if seconds == 0 {
+		c.refresh = true
+		return c
+	}
+	maxAge := time.Duration(seconds) * time.Second
+	if c.maxAge == 0 || maxAge < c.maxAge {
+		c.maxAge = maxAge
+	}
+	return c
+}
+
+/*
+cachedLocation - gets the location of a query from cache, if its cache control allows it
+cacheKey - query + lang
+fields - Fields to return, cache.DefaultFields if 0
+control - cacheControl of the query
+
+returns
+ip_api location
+true if it was found and may be used
+error
+ */
+func cachedLocation(cacheKey string, fields cache.Fields, control cacheControl) (*ip_api.Location, bool, error) {
+	record, found, err := cachedRecord(cacheKey, fields, control)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	return &record.Location, true, nil
+}
+
+//cachedRecord - cachedLocation, returning the cache record of the location
+func cachedRecord(cacheKey string, fields cache.Fields, control cacheControl) (*cache.Record, bool, error) {
+	if control.bypass() {
+		return nil, false, nil
+	}
+
+	record, found, err := cache.GetRecord(cacheKey, fields)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if control.maxAge > 0 && recordAge(record) > control.maxAge {
+		return nil, false, nil
+	}
+	return record, true, nil
+}
+
+/*
+recordAge - gets how long ago a record was cached
+record - cache record
+ */
+func recordAge(record *cache.Record) time.Duration {
+	return time.Since(recordInsertionTime(record))
+}
+
+/*
+recordInsertionTime - gets the time a record was cached
+records cached before insertion times were stored get it from their expiration time and the configured age of their status
+record - cache record
+ */
+func recordInsertionTime(record *cache.Record) time.Time {
+	if !record.InsertionTime.IsZero() {
+		return record.InsertionTime
+	}
+	age := *LoadedConfig.Cache.SuccessAgeDuration
+	if record.Location.Status == "fail" {
+		age = *LoadedConfig.Cache.FailedAgeDuration
+	}
+	return record.ExpirationTime.Add(-age)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//setupCacheControl - configures cache control, a client which may control the cache and one which may not
+func setupCacheControl(t *testing.T, enabled bool, restricted bool, header bool) {
+	LoadedConfig.CacheControl = config.CacheControl{Enabled: enabled, Restricted: restricted, Header: header}
+	LoadedConfig.Clients = []config.Client{{ID: "control", Key: "control-key", CacheControl: true}, {ID: "user", Key: "user-key"}}
+	t.Cleanup(func() {
+		LoadedConfig.CacheControl = config.CacheControl{}
+		LoadedConfig.Clients = nil
+	})
+}
+
+func TestRequestCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		restricted   bool
+		header       bool
+		clientID     string
+		query        string
+		cacheControl string
+		expected     cacheControl
+		allowed      bool
+		err          string
+	}{
+		{"no cache control", false, false, "", "", "", cacheControl{}, true, ""},
+		{"nocache", false, false, "", "?nocache=true", "", cacheControl{noCache: true}, true, ""},
+		{"refresh", false, false, "", "?refresh=1", "", cacheControl{refresh: true}, true, ""},
+		{"nocache false", false, false, "", "?nocache=false", "", cacheControl{}, true, ""},
+		{"maxAge", false, false, "", "?maxAge=60", "", cacheControl{maxAge: time.Minute}, true, ""},
+		{"maxAge 0 refreshes", false, false, "", "?maxAge=0", "", cacheControl{refresh: true}, true, ""},
+		{"negative maxAge", false, false, "", "?maxAge=-1", "", cacheControl{}, true, "invalid maxAge provided, expected seconds"},
+		{"invalid maxAge", false, false, "", "?maxAge=soon", "", cacheControl{}, true, "invalid maxAge provided, expected seconds"},
+		{"invalid nocache", false, false, "", "?nocache=maybe", "", cacheControl{}, true, "invalid nocache provided, expected true or false"},
+		{"invalid refresh", false, false, "", "?refresh=maybe", "", cacheControl{}, true, "invalid refresh provided, expected true or false"},
+		{"restricted without credentials", true, false, "", "?nocache=true", "", cacheControl{}, false, "cache control is not allowed"},
+		{"restricted client without cache control", true, false, "user", "?refresh=true", "", cacheControl{}, false, "cache control is not allowed"},
+		{"restricted client with cache control", true, false, "control", "?refresh=true", "", cacheControl{refresh: true}, true, ""},
+		{"restricted without parameters", true, false, "", "", "", cacheControl{}, false, ""},
+		{"header ignored unless enabled", false, false, "", "", "no-cache", cacheControl{}, true, ""},
+		{"header no-store", false, true, "", "", "no-store", cacheControl{noCache: true}, true, ""},
+		{"header no-cache", false, true, "", "", "No-Cache", cacheControl{refresh: true}, true, ""},
+		{"header max-age", false, true, "", "", "max-age=120", cacheControl{maxAge: 2 * time.Minute}, true, ""},
+		{"header max-age 0 refreshes", false, true, "", "", "max-age=0", cacheControl{refresh: true}, true, ""},
+		{"header negative max-age", false, true, "", "", "max-age=-5", cacheControl{}, true, "invalid Cache-Control max-age provided, expected seconds"},
+		{"header strictest max-age", false, true, "", "?maxAge=60", "max-age=30", cacheControl{maxAge: 30 * time.Second}, true, ""},
+		{"header looser max-age", false, true, "", "?maxAge=30", "max-age=60", cacheControl{maxAge: 30 * time.Second}, true, ""},
+		{"header directives", false, true, "", "", "no-store, max-age=10", cacheControl{noCache: true, maxAge: 10 * time.Second}, true, ""},
+		{"header ignored for restricted clients", true, true, "user", "", "no-cache", cacheControl{}, false, ""},
+		{"header restricted client with cache control", true, true, "control", "", "no-cache", cacheControl{refresh: true}, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupCacheControl(t, true, test.restricted, test.header)
+			r := httptest.NewRequest("GET", "/json/8.8.8.8"+test.query, nil)
+			if test.clientID != "" {
+				r.SetBasicAuth(test.clientID, test.clientID+"-key")
+			}
+			if test.cacheControl != "" {
+				r.Header.Set("Cache-Control", test.cacheControl)
+			}
+
+			control, allowed, err := requestCacheControl(r)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != test.allowed {
+				t.Errorf("got allowed %t, expected %t", allowed, test.allowed)
+			}
+			if test.err == "" && control != test.expected {
+				t.Errorf("got %+v, expected %+v", control, test.expected)
+			}
+		})
+	}
+}
+
+func TestRequestCacheControlDisabled(t *testing.T) {
+	setupCacheControl(t, false, false, true)
+	r := httptest.NewRequest("GET", "/json/8.8.8.8?refresh=true", nil)
+	r.Header.Set("Cache-Control", "no-cache")
+
+	control, allowed, err := requestCacheControl(r)
+	if err == nil || err.Error() != "cache control is not allowed" || allowed || control != (cacheControl{}) {
+		t.Errorf("got %+v, allowed %t and error %v, expected cache control not allowed", control, allowed, err)
+	}
+}
+
+func TestBatchCacheControlMerge(t *testing.T) {
+	zero, minute, negative := 0, 60, -1
+
+	tests := []struct {
+		name     string
+		entry    batchCacheControl
+		base     cacheControl
+		allowed  bool
+		expected cacheControl
+		err      string
+	}{
+		{"no cache control keeps the batch's", batchCacheControl{}, cacheControl{refresh: true}, false, cacheControl{refresh: true}, ""},
+		{"not allowed", batchCacheControl{NoCache: true}, cacheControl{}, false, cacheControl{}, "cache control is not allowed"},
+		{"nocache", batchCacheControl{NoCache: true}, cacheControl{}, true, cacheControl{noCache: true}, ""},
+		{"adds to the batch's", batchCacheControl{Refresh: true}, cacheControl{noCache: true}, true, cacheControl{noCache: true, refresh: true}, ""},
+		{"maxAge", batchCacheControl{MaxAge: &minute}, cacheControl{}, true, cacheControl{maxAge: time.Minute}, ""},
+		{"stricter batch maxAge", batchCacheControl{MaxAge: &minute}, cacheControl{maxAge: time.Second}, true, cacheControl{maxAge: time.Second}, ""},
+		{"maxAge 0 refreshes", batchCacheControl{MaxAge: &zero}, cacheControl{}, true, cacheControl{refresh: true}, ""},
+		{"negative maxAge", batchCacheControl{MaxAge: &negative}, cacheControl{}, true, cacheControl{}, "invalid maxAge provided, expected seconds"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			control, err := test.entry.merge(test.base, test.allowed)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if control != test.expected {
+				t.Errorf("got %+v, expected %+v", control, test.expected)
+			}
+		})
+	}
+}
+
+func TestCachedRecordMaxAge(t *testing.T) {
+	now := time.Now().UTC()
+	record := cache.Record{
+		InsertionTime:  now.Add(-10 * time.Minute),
+		ExpirationTime: now.Add(time.Hour),
+		Location:       ip_api.Location{Status: "success", Query: "8.8.8.8"},
+	}
+	if _, err := cache.AddRecord("8.8.8.8", record); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("8.8.8.8")
+
+	tests := []struct {
+		name     string
+		control  cacheControl
+		expected bool
+	}{
+		{"no cache control", cacheControl{}, true},
+		{"younger than maxAge", cacheControl{maxAge: 20 * time.Minute}, true},
+		{"older than maxAge", cacheControl{maxAge: 5 * time.Minute}, false},
+		{"nocache", cacheControl{noCache: true}, false},
+		{"refresh", cacheControl{refresh: true}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, found, err := cachedLocation("8.8.8.8", cache.AllFields, test.control); found != test.expected || err != nil {
+				t.Errorf("got found %t (%v), expected %t", found, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestBatchCacheControlEntries(t *testing.T) {
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Country: "United States", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("8.8.8.8")
+
+	//every entry is either cached or fails, so IP-API is never called
+	body := `[{"query":"8.8.8.8"},{"query":"8.8.8.8","nocache":true},{"query":"8.8.8.8","maxAge":-1},{"query":"8.8.8.8","maxAge":3600}]`
+
+	tests := []struct {
+		name       string
+		restricted bool
+		clientID   string
+		statusCode int
+		expected   []string
+	}{
+		{"allowed", false, "", http.StatusOK, []string{"success", "invalid maxAge provided, expected seconds", "success"}},
+		{"restricted entries fail on their own", true, "user", http.StatusOK, []string{"success", "cache control is not allowed", "cache control is not allowed", "cache control is not allowed"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupCacheControl(t, true, test.restricted, false)
+			//the nocache entry can't be answered without IP-API, so it's left out of the allowed batch
+			requestBody := body
+			if !test.restricted {
+				requestBody = strings.Replace(body, `{"query":"8.8.8.8","nocache":true},`, "", 1)
+			}
+			r := httptest.NewRequest("POST", "/batch?fields=status,message,query", strings.NewReader(requestBody))
+			if test.clientID != "" {
+				r.SetBasicAuth(test.clientID, test.clientID+"-key")
+			}
+			w := httptest.NewRecorder()
+			ipAPIBatch(w, r)
+			if w.Code != test.statusCode {
+				t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+
+			var locations []ip_api.Location
+			if err := json.Unmarshal(w.Body.Bytes(), &locations); err != nil {
+				t.Fatalf("got %s: %v", w.Body.String(), err)
+			}
+			if len(locations) != len(test.expected) {
+				t.Fatalf("got %d locations, expected %d", len(locations), len(test.expected))
+			}
+			for i, location := range locations {
+				result := location.Status
+				if location.Status == "fail" {
+					result = location.Message
+				}
+				if result != test.expected[i] {
+					t.Errorf("got entry %d %q, expected %q", i, result, test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchCacheControlNotAllowed(t *testing.T) {
+	setupCacheControl(t, true, true, false)
+	r := httptest.NewRequest("POST", "/batch?refresh=true", strings.NewReader(`[{"query":"8.8.8.8"}]`))
+	r.SetBasicAuth("user", "user-key")
+	w := httptest.NewRecorder()
+	ipAPIBatch(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got status %d %s, expected %d", w.Code, w.Body.String(), http.StatusForbidden)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+/*
+clientIP - gets the IP address of the client which made a request
+forwarding headers (Forwarded, X-Forwarded-For, X-Real-IP) are only used when the request comes from a trusted proxy,
+otherwise any client could pick the address it is geolocated as
+r - http request
+
+returns
+client IP address, "" if it can't be determined
+ */
+func clientIP(r *http.Request) string {
+	remoteIP := parseHostIP(r.RemoteAddr)
+	if remoteIP == nil {
+		return ""
+	}
+
+	if !trustedProxy(remoteIP) {
+		return remoteIP.String()
+	}
+
+	//walk the forwarding chain from the closest hop back, the first untrusted address is the client
+	var chain []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		chain = parseForwarded(forwarded)
+	} else if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		for _, header := range forwardedFor {
+			chain = append(chain, strings.Split(header, ",")...)
+		}
+	} else if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		chain = []string{realIP}
+	}
+
+	clientIP := remoteIP
+	for i := len(chain) - 1; i >= 0; i-- {
+		hopIP := parseHostIP(strings.TrimSpace(chain[i]))
+		if hopIP == nil {
+			//unknown or obfuscated hop, nothing before it can be trusted
+			break
+		}
+		clientIP = hopIP
+		if !trustedProxy(hopIP) {
+			break
+		}
+	}
+
+	return clientIP.String()
+}
+
+/*
+trustedProxy - checks if an IP address is in one of the configured trusted proxy networks
+ip - IP address
+ */
+func trustedProxy(ip net.IP) bool {
+	for _, trustedNetwork := range LoadedConfig.TrustedNetworks {
+		if trustedNetwork.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+parseForwarded - gets the for= values of RFC 7239 Forwarded headers in hop order
+headers - Forwarded header values
+ */
+func parseForwarded(headers []string) []string {
+	var chain []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					chain = append(chain, strings.Trim(pair[4:], "\""))
+				}
+			}
+		}
+	}
+	return chain
+}
+
+/*
+parseHostIP - parses an IP address which may have a port and IPv6 brackets (ex: 1.2.3.4:80, [::1]:80, [::1])
+host - address
+ */
+func parseHostIP(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"))
+}
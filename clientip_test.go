@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	_, trustedNetwork, _ := net.ParseCIDR("10.0.0.0/8")
+	LoadedConfig.TrustedNetworks = []*net.IPNet{trustedNetwork}
+	defer func() {
+		LoadedConfig.TrustedNetworks = nil
+	}()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{"untrusted peer", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer ignores forwarded for", "203.0.113.7:1234", map[string][]string{"X-Forwarded-For": {"8.8.8.8"}}, "203.0.113.7"},
+		{"untrusted peer ignores forwarded", "203.0.113.7:1234", map[string][]string{"Forwarded": {"for=8.8.8.8"}}, "203.0.113.7"},
+		{"trusted peer without headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"trusted peer uses forwarded for", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"8.8.8.8"}}, "8.8.8.8"},
+		{"chain stops at the first untrusted hop", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1, 8.8.8.8, 10.0.0.2"}}, "8.8.8.8"},
+		{"chain over several headers", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1", "8.8.8.8, 10.0.0.2"}}, "8.8.8.8"},
+		{"chain of trusted hops", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"invalid hop stops the chain", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1, unknown, 10.0.0.2"}}, "10.0.0.2"},
+		{"forwarded over forwarded for", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=8.8.8.8"}, "X-Forwarded-For": {"1.1.1.1"}}, "8.8.8.8"},
+		{"forwarded ipv6 with port", "10.0.0.1:1234", map[string][]string{"Forwarded": {`for="[2001:db8::1]:80";proto=https, for=10.0.0.2`}}, "2001:db8::1"},
+		{"forwarded obfuscated hop", "10.0.0.1:1234", map[string][]string{"Forwarded": {"for=1.1.1.1, for=_hidden"}}, "10.0.0.1"},
+		{"real ip", "10.0.0.1:1234", map[string][]string{"X-Real-IP": {"8.8.8.8"}}, "8.8.8.8"},
+		{"forwarded for over real ip", "10.0.0.1:1234", map[string][]string{"X-Forwarded-For": {"1.1.1.1"}, "X-Real-IP": {"8.8.8.8"}}, "1.1.1.1"},
+		{"ipv6 peer", "[2001:db8::2]:1234", nil, "2001:db8::2"},
+		{"invalid peer", "invalid", nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/json/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for header, values := range test.headers {
+				for _, value := range values {
+					r.Header.Add(header, value)
+				}
+			}
+			if ip := clientIP(r); ip != test.expected {
+				t.Errorf("got %q, expected %q", ip, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  []string
+		expected []string
+	}{
+		{"single for", []string{"for=8.8.8.8"}, []string{"8.8.8.8"}},
+		{"quoted ipv6", []string{`for="[2001:db8::1]:80"`}, []string{"[2001:db8::1]:80"}},
+		{"other pairs", []string{"proto=https;for=8.8.8.8;by=10.0.0.1"}, []string{"8.8.8.8"}},
+		{"upper case", []string{"For=8.8.8.8"}, []string{"8.8.8.8"}},
+		{"elements in order", []string{"for=1.1.1.1, for=8.8.8.8"}, []string{"1.1.1.1", "8.8.8.8"}},
+		{"headers in order", []string{"for=1.1.1.1", "for=8.8.8.8"}, []string{"1.1.1.1", "8.8.8.8"}},
+		{"element without for", []string{"proto=https, for=8.8.8.8"}, []string{"8.8.8.8"}},
+		{"empty for", []string{"for="}, nil},
+		{"no headers", nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if chain := parseForwarded(test.headers); !reflect.DeepEqual(chain, test.expected) {
+				t.Errorf("got %q, expected %q", chain, test.expected)
+			}
+		})
+	}
+}
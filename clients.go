@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/BenB196/ip-api-proxy/config"
+	"net/http"
+)
+
+/*
+authenticateClient - gets the configured client a request authenticates as with HTTP basic auth (id:key)
+r - http request
+
+returns
+client, nil if the request has no or invalid credentials
+true if the request has credentials
+ */
+func authenticateClient(r *http.Request) (*config.Client, bool) {
+	id, key, ok := r.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+
+	for i := range LoadedConfig.Clients {
+		client := &LoadedConfig.Clients[i]
+		//compare keys in constant time so that keys can't be guessed by timing
+		if client.ID == id && subtle.ConstantTimeCompare([]byte(client.Key), []byte(key)) == 1 {
+			return client, true
+		}
+	}
+	return nil, true
+}
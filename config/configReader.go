@@ -3,20 +3,43 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/outputProfile"
 	"github.com/BenB196/ip-api-proxy/utils"
 	"io/ioutil"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
 type Config struct {
-	Cache      Cache      `json:"cache,omitempty"`
-	APIKey     string     `json:"apiKey,omitempty"`
-	Port       int        `json:"port,omitempty"`
-	Debugging  bool       `json:"debugging,omitempty"`
-	Prometheus Prometheus `json:"prometheus,omitempty"`
+	Cache                   Cache                            `json:"cache,omitempty"`
+	APIKey                  string                           `json:"apiKey,omitempty"`
+	Port                    int                              `json:"port,omitempty"`
+	Debugging               bool                             `json:"debugging,omitempty"`
+	Prometheus              Prometheus                       `json:"prometheus,omitempty"`
+	DefaultFields           string                           `json:"defaultFields,omitempty"`
+	DefaultFieldsSet        *cache.Fields                    `json:"defaultFieldsSet,omitempty"`
+	CORS                    CORS                             `json:"cors,omitempty"`
+	TrustedProxies          []string                         `json:"trustedProxies,omitempty"`
+	TrustedNetworks         []*net.IPNet                     `json:"-"`
+	Clients                 []Client                         `json:"clients,omitempty"`
+	Export                  Export                           `json:"export,omitempty"`
+	Profiles                map[string]outputProfile.Profile `json:"profiles,omitempty"`
+	RateLimit               RateLimit                        `json:"rateLimit,omitempty"`
+	Jobs                    Jobs                             `json:"jobs,omitempty"`
+	GRPC                    GRPC                             `json:"grpc,omitempty"`
+	DNS                     DNS                              `json:"dns,omitempty"`
+	ForwardAuth             ForwardAuth                      `json:"forwardAuth,omitempty"`
+	ReverseProxy            ReverseProxy                     `json:"reverseProxy,omitempty"`
+	Admin                   Admin                            `json:"admin,omitempty"`
+	CacheControl            CacheControl                     `json:"cacheControl,omitempty"`
+	ShutdownTimeout         string                           `json:"shutdownTimeout,omitempty"`
+	ShutdownTimeoutDuration *time.Duration                   `json:"shutdownTimeoutDuration,omitempty"`
 }
 
 type Cache struct {
@@ -27,6 +50,104 @@ type Cache struct {
 	SuccessAgeDuration *time.Duration `json:"successAgeDuration,omitempty"`
 	FailedAge          string         `json:"failedAge,omitempty"`
 	FailedAgeDuration  *time.Duration `json:"failedAgeDuration,omitempty"`
+	Snapshots          int            `json:"snapshots,omitempty"`
+}
+
+type CORS struct {
+	Enabled        bool     `json:"enabled,omitempty"`
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	MaxAge         int      `json:"maxAge,omitempty"`
+}
+
+type RateLimit struct {
+	SingleRequests   *int           `json:"singleRequests,omitempty"`
+	BatchRequests    *int           `json:"batchRequests,omitempty"`
+	Interval         string         `json:"interval,omitempty"`
+	IntervalDuration *time.Duration `json:"intervalDuration,omitempty"`
+}
+
+type Jobs struct {
+	Enabled    bool `json:"enabled,omitempty"`
+	MaxQueries int  `json:"maxQueries,omitempty"`
+}
+
+type GRPC struct {
+	Enabled bool `json:"enabled,omitempty"`
+	Port    int  `json:"port,omitempty"`
+}
+
+type DNS struct {
+	Enabled              bool           `json:"enabled,omitempty"`
+	Port                 int            `json:"port,omitempty"`
+	Zone                 string         `json:"zone,omitempty"`
+	LookupBudget         string         `json:"lookupBudget,omitempty"`
+	LookupBudgetDuration *time.Duration `json:"lookupBudgetDuration,omitempty"`
+}
+
+type ForwardAuth struct {
+	Enabled                bool           `json:"enabled,omitempty"`
+	IPHeaders              []string       `json:"ipHeaders,omitempty"`
+	DefaultAction          string         `json:"defaultAction,omitempty"`
+	ErrorAction            string         `json:"errorAction,omitempty"`
+	Rules                  []AccessRule   `json:"rules,omitempty"`
+	ReloadInterval         string         `json:"reloadInterval,omitempty"`
+	ReloadIntervalDuration *time.Duration `json:"reloadIntervalDuration,omitempty"`
+}
+
+type AccessRule struct {
+	Name       string   `json:"name,omitempty"`
+	Action     string   `json:"action,omitempty"`
+	Countries  []string `json:"countries,omitempty"`
+	Continents []string `json:"continents,omitempty"`
+	ASNs       []int    `json:"asns,omitempty"`
+	Proxy      *bool    `json:"proxy,omitempty"`
+	Hosting    *bool    `json:"hosting,omitempty"`
+	Mobile     *bool    `json:"mobile,omitempty"`
+}
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+type ReverseProxy struct {
+	Enabled              bool              `json:"enabled,omitempty"`
+	Port                 int               `json:"port,omitempty"`
+	Backend              string            `json:"backend,omitempty"`
+	BackendURL           *url.URL          `json:"-"`
+	Headers              map[string]string `json:"headers,omitempty"`
+	LookupBudget         string            `json:"lookupBudget,omitempty"`
+	LookupBudgetDuration *time.Duration    `json:"lookupBudgetDuration,omitempty"`
+}
+
+/*
+ASNField - pseudo field of reverse proxy headers holding the AS number without its organization (ex: 15169)
+*/
+const ASNField = "asn"
+
+type Export struct {
+	Enabled             bool `json:"enabled,omitempty"`
+	IPv4AggregatePrefix int  `json:"ipv4AggregatePrefix,omitempty"`
+	IPv6AggregatePrefix int  `json:"ipv6AggregatePrefix,omitempty"`
+}
+
+type Client struct {
+	ID           string `json:"id,omitempty"`
+	Key          string `json:"key,omitempty"`
+	Admin        bool   `json:"admin,omitempty"`
+	CacheControl bool   `json:"cacheControl,omitempty"`
+}
+
+type Admin struct {
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+type CacheControl struct {
+	Enabled    bool `json:"enabled,omitempty"`
+	Restricted bool `json:"restricted,omitempty"`
+	Header     bool `json:"header,omitempty"`
 }
 
 type Prometheus struct {
@@ -34,7 +155,11 @@ type Prometheus struct {
 	Port    int  `json:"port,omitempty"`
 }
 
-func ReadConfig(configLocation string) (Config, error) {
+/*
+ResolvePath - gets the absolute path of the config file
+configLocation - config file location, ./config.json if empty
+*/
+func ResolvePath(configLocation string) (string, error) {
 	var err error
 
 	//get working directory if no location passed
@@ -42,17 +167,27 @@ func ReadConfig(configLocation string) (Config, error) {
 		configLocation, err = os.Getwd()
 		configLocation = configLocation + utils.DirPath + "config.json"
 		if err != nil {
-			return Config{}, errors.New("error: getting working directory: " + err.Error())
+			return "", errors.New("error: getting working directory: " + err.Error())
 		}
 	} else {
 		//get absolute path of config file if specified
 		configLocation, err = filepath.Abs(configLocation)
 
 		if err != nil {
-			return Config{}, errors.New("error: getting absolute path of config location: " + err.Error())
+			return "", errors.New("error: getting absolute path of config location: " + err.Error())
 		}
 	}
 
+	return configLocation, nil
+}
+
+func ReadConfig(configLocation string) (Config, error) {
+	configLocation, err := ResolvePath(configLocation)
+
+	if err != nil {
+		return Config{}, err
+	}
+
 	//open config file
 	var skipConfig bool
 	//init config var
@@ -100,6 +235,14 @@ func ReadConfig(configLocation string) (Config, error) {
 			config.Cache.WriteInterval = "30m"
 		}
 
+		//validate snapshot generations kept
+		if config.Cache.Snapshots == 0 {
+			//set to default 3
+			config.Cache.Snapshots = 3
+		} else if config.Cache.Snapshots < 0 {
+			return Config{}, errors.New("error: cache snapshots cannot be below 1")
+		}
+
 		//validate write location if not empty string
 		if config.Cache.WriteLocation != "" {
 			//make sure output location is valid
@@ -165,6 +308,186 @@ func ReadConfig(configLocation string) (Config, error) {
 		config.Cache.FailedAgeDuration = &failedAgeDuration
 	}
 
+	//validate default fields
+	if config.DefaultFields != "" {
+		defaultFieldsSet, err := cache.ParseFields(config.DefaultFields)
+
+		if err != nil {
+			return Config{}, errors.New("error: parsing default fields: " + err.Error())
+		} else if defaultFieldsSet == 0 {
+			return Config{}, errors.New("error: default fields cannot be empty")
+		}
+
+		config.DefaultFieldsSet = &defaultFieldsSet
+	} else {
+		//set to default IP-API default fields
+		config.DefaultFields = cache.IPAPIDefaultFields.String()
+		defaultFieldsSet := cache.IPAPIDefaultFields
+		config.DefaultFieldsSet = &defaultFieldsSet
+	}
+
+	//validate cors, only if enabled
+	if config.CORS.Enabled {
+		if len(config.CORS.AllowedOrigins) == 0 {
+			//set default to all origins
+			config.CORS.AllowedOrigins = []string{"*"}
+		}
+
+		if len(config.CORS.AllowedMethods) == 0 {
+			//set default to the methods the endpoints support
+			config.CORS.AllowedMethods = []string{"GET", "POST", "OPTIONS"}
+		}
+
+		if len(config.CORS.AllowedHeaders) == 0 {
+			//set default to content type so batch requests can post json
+			config.CORS.AllowedHeaders = []string{"Content-Type"}
+		}
+
+		if config.CORS.MaxAge < 0 {
+			return Config{}, errors.New("error: cors max age cannot be below 0")
+		}
+	}
+
+	//validate trusted proxies, single IPs are treated as /32 or /128 networks
+	for _, trustedProxy := range config.TrustedProxies {
+		if !strings.Contains(trustedProxy, "/") {
+			if ip := net.ParseIP(trustedProxy); ip != nil && ip.To4() != nil {
+				trustedProxy = trustedProxy + "/32"
+			} else {
+				trustedProxy = trustedProxy + "/128"
+			}
+		}
+
+		_, trustedNetwork, err := net.ParseCIDR(trustedProxy)
+
+		if err != nil {
+			return Config{}, errors.New("error: parsing trusted proxy: " + err.Error())
+		}
+
+		config.TrustedNetworks = append(config.TrustedNetworks, trustedNetwork)
+	}
+
+	//validate clients, ids must be unique so that a request maps onto a single client
+	clientIDs := map[string]bool{}
+	for _, client := range config.Clients {
+		if client.ID == "" {
+			return Config{}, errors.New("error: client id cannot be empty")
+		} else if client.Key == "" {
+			return Config{}, errors.New("error: client " + client.ID + " key cannot be empty")
+		} else if clientIDs[client.ID] {
+			return Config{}, errors.New("error: duplicate client id: " + client.ID)
+		}
+		clientIDs[client.ID] = true
+	}
+
+	//validate admin, the admin api is only served to admin clients
+	if config.Admin.Enabled {
+		adminClient := false
+		for _, client := range config.Clients {
+			adminClient = adminClient || client.Admin
+		}
+
+		if !adminClient {
+			return Config{}, errors.New("error: admin requires at least one client with admin enabled")
+		}
+	}
+
+	//validate cache control, restricted cache control is only honoured for cache control clients
+	if config.CacheControl.Enabled && config.CacheControl.Restricted {
+		cacheControlClient := false
+		for _, client := range config.Clients {
+			cacheControlClient = cacheControlClient || client.CacheControl
+		}
+
+		if !cacheControlClient {
+			return Config{}, errors.New("error: restricted cache control requires at least one client with cache control enabled")
+		}
+	}
+
+	//validate output profiles, built in profiles are added unless a profile of the same name is configured
+	for name, profile := range config.Profiles {
+		if name == "" {
+			return Config{}, errors.New("error: profile name cannot be empty")
+		}
+
+		err = profile.Validate()
+
+		if err != nil {
+			return Config{}, errors.New("error: profile " + name + ": " + err.Error())
+		}
+	}
+
+	if config.Profiles == nil {
+		config.Profiles = map[string]outputProfile.Profile{}
+	}
+	for name, profile := range outputProfile.Builtin {
+		if _, ok := config.Profiles[name]; !ok {
+			config.Profiles[name] = profile
+		}
+	}
+
+	//validate rate limit, IP-API's free API allows 45 single and 15 batch requests a minute, the pro API is unlimited
+	if config.RateLimit.Interval != "" {
+		intervalDuration, err := time.ParseDuration(config.RateLimit.Interval)
+
+		if err != nil {
+			return Config{}, errors.New("error: parsing rate limit interval duration: " + err.Error())
+		} else if intervalDuration <= 0 {
+			return Config{}, errors.New("error: rate limit interval must be above 0")
+		}
+
+		config.RateLimit.IntervalDuration = &intervalDuration
+	} else {
+		//set to default 1 minute
+		config.RateLimit.Interval = "1m"
+		intervalDuration := time.Minute
+		config.RateLimit.IntervalDuration = &intervalDuration
+	}
+
+	//rate limits are off unless configured, so existing deployments keep forwarding requests as they arrive
+	if config.RateLimit.SingleRequests == nil {
+		singleRequests := 0
+		config.RateLimit.SingleRequests = &singleRequests
+	} else if *config.RateLimit.SingleRequests < 0 {
+		return Config{}, errors.New("error: rate limit single requests cannot be below 0")
+	}
+
+	if config.RateLimit.BatchRequests == nil {
+		batchRequests := 0
+		config.RateLimit.BatchRequests = &batchRequests
+	} else if *config.RateLimit.BatchRequests < 0 {
+		return Config{}, errors.New("error: rate limit batch requests cannot be below 0")
+	}
+
+	//validate jobs, jobs are persisted next to the cache so they can resume after a restart
+	if config.Jobs.Enabled {
+		if !config.Cache.Persist {
+			return Config{}, errors.New("error: jobs require cache persist to be enabled")
+		}
+
+		if config.Jobs.MaxQueries == 0 {
+			//set default to 10 million queries
+			config.Jobs.MaxQueries = 10000000
+		} else if config.Jobs.MaxQueries < 0 {
+			return Config{}, errors.New("error: jobs max queries cannot be below 0")
+		}
+	}
+
+	//validate export aggregate prefixes
+	if config.Export.IPv4AggregatePrefix == 0 {
+		//set default to /24
+		config.Export.IPv4AggregatePrefix = 24
+	} else if config.Export.IPv4AggregatePrefix < 1 || config.Export.IPv4AggregatePrefix > 32 {
+		return Config{}, errors.New("error: export ipv4 aggregate prefix must be between 1 and 32")
+	}
+
+	if config.Export.IPv6AggregatePrefix == 0 {
+		//set default to /48
+		config.Export.IPv6AggregatePrefix = 48
+	} else if config.Export.IPv6AggregatePrefix < 1 || config.Export.IPv6AggregatePrefix > 128 {
+		return Config{}, errors.New("error: export ipv6 aggregate prefix must be between 1 and 128")
+	}
+
 	//validate port
 	if config.Port == 0 {
 		//set default 8080
@@ -175,6 +498,247 @@ func ReadConfig(configLocation string) (Config, error) {
 		return Config{}, errors.New("error: port cannot be above 65535")
 	}
 
+	//validate grpc port
+	if config.GRPC.Enabled {
+		if config.GRPC.Port == 0 {
+			//set default 9090
+			config.GRPC.Port = 9090
+		} else if config.GRPC.Port < 1024 {
+			return Config{}, errors.New("error: grpc port cannot be below 1024")
+		} else if config.GRPC.Port > 65535 {
+			return Config{}, errors.New("error: grpc port cannot be above 65535")
+		}
+
+		if config.GRPC.Port == config.Port {
+			return Config{}, errors.New("error: grpc port cannot be the same as port")
+		}
+	}
+
+	//validate dns
+	if config.DNS.Enabled {
+		if config.DNS.Port == 0 {
+			//set default 5353, so the proxy doesn't need to run as root
+			config.DNS.Port = 5353
+		} else if config.DNS.Port < 1 || config.DNS.Port > 65535 {
+			return Config{}, errors.New("error: dns port must be between 1 and 65535")
+		}
+		if config.DNS.Port == config.Port || (config.GRPC.Enabled && config.DNS.Port == config.GRPC.Port) {
+			return Config{}, errors.New("error: dns port cannot be the same as port or grpc port")
+		}
+
+		//zones are matched lower case and without the trailing dot
+		config.DNS.Zone = strings.ToLower(strings.Trim(config.DNS.Zone, "."))
+		if config.DNS.Zone == "" {
+			//set default to geo.internal
+			config.DNS.Zone = "geo.internal"
+		}
+
+		if config.DNS.LookupBudget != "" {
+			lookupBudgetDuration, err := time.ParseDuration(config.DNS.LookupBudget)
+
+			if err != nil {
+				return Config{}, errors.New("error: parsing dns lookup budget duration: " + err.Error())
+			} else if lookupBudgetDuration < 0 {
+				return Config{}, errors.New("error: dns lookup budget cannot be below 0")
+			}
+
+			config.DNS.LookupBudgetDuration = &lookupBudgetDuration
+		} else {
+			//set to default 1 second, well within the timeout of dns clients
+			config.DNS.LookupBudget = "1s"
+			lookupBudgetDuration := time.Second
+			config.DNS.LookupBudgetDuration = &lookupBudgetDuration
+		}
+	}
+
+	//validate forward auth, rules are evaluated in order and the first matching rule decides
+	if config.ForwardAuth.Enabled {
+		config.ForwardAuth.DefaultAction, err = validateAction(config.ForwardAuth.DefaultAction, ActionAllow)
+
+		if err != nil {
+			return Config{}, errors.New("error: forward auth default action: " + err.Error())
+		}
+
+		//lookups that fail with an error (ex: IP-API unreachable) are denied by default
+		config.ForwardAuth.ErrorAction, err = validateAction(config.ForwardAuth.ErrorAction, ActionDeny)
+
+		if err != nil {
+			return Config{}, errors.New("error: forward auth error action: " + err.Error())
+		}
+
+		for i, header := range config.ForwardAuth.IPHeaders {
+			if strings.TrimSpace(header) == "" {
+				return Config{}, errors.New("error: forward auth ip header cannot be empty")
+			}
+			config.ForwardAuth.IPHeaders[i] = strings.TrimSpace(header)
+		}
+
+		for i := range config.ForwardAuth.Rules {
+			err = validateAccessRule(&config.ForwardAuth.Rules[i], i)
+
+			if err != nil {
+				return Config{}, err
+			}
+		}
+
+		if config.ForwardAuth.ReloadInterval != "" {
+			reloadIntervalDuration, err := time.ParseDuration(config.ForwardAuth.ReloadInterval)
+
+			if err != nil {
+				return Config{}, errors.New("error: parsing forward auth reload interval duration: " + err.Error())
+			} else if reloadIntervalDuration <= 0 {
+				return Config{}, errors.New("error: forward auth reload interval must be above 0")
+			}
+
+			config.ForwardAuth.ReloadIntervalDuration = &reloadIntervalDuration
+		} else {
+			//set to default 10 seconds
+			config.ForwardAuth.ReloadInterval = "10s"
+			reloadIntervalDuration := 10 * time.Second
+			config.ForwardAuth.ReloadIntervalDuration = &reloadIntervalDuration
+		}
+	}
+
+	//validate reverse proxy, it listens on its own port in front of the backend
+	if config.ReverseProxy.Enabled {
+		if config.ReverseProxy.Port == 0 {
+			//set default 8081
+			config.ReverseProxy.Port = 8081
+		} else if config.ReverseProxy.Port < 1024 {
+			return Config{}, errors.New("error: reverse proxy port cannot be below 1024")
+		} else if config.ReverseProxy.Port > 65535 {
+			return Config{}, errors.New("error: reverse proxy port cannot be above 65535")
+		}
+
+		if config.ReverseProxy.Port == config.Port || (config.GRPC.Enabled && config.ReverseProxy.Port == config.GRPC.Port) || (config.DNS.Enabled && config.ReverseProxy.Port == config.DNS.Port) {
+			return Config{}, errors.New("error: reverse proxy port cannot be the same as port, grpc port or dns port")
+		}
+
+		if config.ReverseProxy.Backend == "" {
+			return Config{}, errors.New("error: reverse proxy backend cannot be empty")
+		}
+
+		config.ReverseProxy.BackendURL, err = url.Parse(config.ReverseProxy.Backend)
+
+		if err != nil {
+			return Config{}, errors.New("error: parsing reverse proxy backend: " + err.Error())
+		} else if (config.ReverseProxy.BackendURL.Scheme != "http" && config.ReverseProxy.BackendURL.Scheme != "https") || config.ReverseProxy.BackendURL.Host == "" {
+			return Config{}, errors.New("error: reverse proxy backend must be an http or https url (ex: http://localhost:3000)")
+		}
+
+		if len(config.ReverseProxy.Headers) == 0 {
+			//set default to the client's country, region, city, asn and coordinates
+			config.ReverseProxy.Headers = map[string]string{
+				"X-Client-Country": "countryCode",
+				"X-Client-Region":  "regionName",
+				"X-Client-City":    "city",
+				"X-Client-ASN":     ASNField,
+				"X-Client-Lat":     "lat",
+				"X-Client-Lon":     "lon",
+			}
+		}
+
+		for header, field := range config.ReverseProxy.Headers {
+			if strings.TrimSpace(header) == "" {
+				return Config{}, errors.New("error: reverse proxy header cannot be empty")
+			} else if field != ASNField && cache.FieldByName(field) == 0 {
+				return Config{}, errors.New("error: reverse proxy header " + header + ": invalid field " + field)
+			}
+		}
+
+		if config.ReverseProxy.LookupBudget != "" {
+			lookupBudgetDuration, err := time.ParseDuration(config.ReverseProxy.LookupBudget)
+
+			if err != nil {
+				return Config{}, errors.New("error: parsing reverse proxy lookup budget duration: " + err.Error())
+			} else if lookupBudgetDuration < 0 {
+				return Config{}, errors.New("error: reverse proxy lookup budget cannot be below 0")
+			}
+
+			config.ReverseProxy.LookupBudgetDuration = &lookupBudgetDuration
+		} else {
+			//set to default 50 milliseconds
+			config.ReverseProxy.LookupBudget = "50ms"
+			lookupBudgetDuration := 50 * time.Millisecond
+			config.ReverseProxy.LookupBudgetDuration = &lookupBudgetDuration
+		}
+	}
+
+	//validate shutdown timeout
+	if config.ShutdownTimeout != "" {
+		shutdownTimeoutDuration, err := time.ParseDuration(config.ShutdownTimeout)
+
+		if err != nil {
+			return Config{}, errors.New("error: parsing shutdown timeout duration: " + err.Error())
+		} else if shutdownTimeoutDuration <= 0 {
+			return Config{}, errors.New("error: shutdown timeout must be above 0")
+		}
+
+		config.ShutdownTimeoutDuration = &shutdownTimeoutDuration
+	} else {
+		//set to default 30 seconds
+		config.ShutdownTimeout = "30s"
+		shutdownTimeoutDuration := 30 * time.Second
+		config.ShutdownTimeoutDuration = &shutdownTimeoutDuration
+	}
+
 	return config, nil
 
 }
+
+/*
+validateAction - validates the action of an access rule
+action - allow or deny, case insensitive
+defaultAction - action used if empty
+*/
+func validateAction(action string, defaultAction string) (string, error) {
+	action = strings.ToLower(strings.TrimSpace(action))
+	if action == "" {
+		return defaultAction, nil
+	} else if action != ActionAllow && action != ActionDeny {
+		return "", errors.New("invalid action " + action + ", expected " + ActionAllow + " or " + ActionDeny)
+	}
+	return action, nil
+}
+
+/*
+validateAccessRule - validates an access rule, and normalizes its country and continent codes to upper case
+rule - access rule
+index - 0 based index of the rule, used to name unnamed rules
+*/
+func validateAccessRule(rule *AccessRule, index int) error {
+	if rule.Name == "" {
+		rule.Name = "rule " + strconv.Itoa(index+1)
+	}
+
+	var err error
+	rule.Action, err = validateAction(rule.Action, "")
+
+	if err != nil {
+		return errors.New("error: forward auth " + rule.Name + ": " + err.Error())
+	} else if rule.Action == "" {
+		return errors.New("error: forward auth " + rule.Name + ": action cannot be empty")
+	}
+
+	for i, country := range rule.Countries {
+		rule.Countries[i] = strings.ToUpper(strings.TrimSpace(country))
+		if len(rule.Countries[i]) != 2 {
+			return errors.New("error: forward auth " + rule.Name + ": invalid country code " + country + ", expected ISO 3166-1 alpha-2 codes (ex: US)")
+		}
+	}
+
+	for i, continent := range rule.Continents {
+		rule.Continents[i] = strings.ToUpper(strings.TrimSpace(continent))
+		if len(rule.Continents[i]) != 2 {
+			return errors.New("error: forward auth " + rule.Name + ": invalid continent code " + continent + ", expected two letter codes (ex: EU)")
+		}
+	}
+
+	for _, asn := range rule.ASNs {
+		if asn <= 0 {
+			return errors.New("error: forward auth " + rule.Name + ": asns must be above 0")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+corsHandler - wraps a handler with the configured CORS headers and answers preflight OPTIONS requests
+responses vary by Origin whenever CORS is enabled, so a shared cache never serves one origin's CORS headers to another
+next - handler to wrap
+ */
+func corsHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !LoadedConfig.CORS.Enabled {
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+
+		//only set CORS headers for allowed origins, the browser blocks everything else
+		allowedOrigin := corsAllowedOrigin(origin)
+		if allowedOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		}
+
+		//answer preflight requests directly, these never reach the endpoint handlers
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowedOrigin != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(LoadedConfig.CORS.AllowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(LoadedConfig.CORS.AllowedHeaders, ", "))
+				if LoadedConfig.CORS.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(LoadedConfig.CORS.MaxAge))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+/*
+corsAllowedOrigin - gets the Access-Control-Allow-Origin value for an origin
+origin - Origin header of the request
+
+returns
+"*" if all origins are allowed, origin if it is allowed, else ""
+ */
+func corsAllowedOrigin(origin string) string {
+	for _, allowedOrigin := range LoadedConfig.CORS.AllowedOrigins {
+		if allowedOrigin == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowedOrigin, origin) {
+			return origin
+		}
+	}
+	return ""
+}
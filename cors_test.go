@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-proxy/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsHandler(t *testing.T) {
+	defer func() {
+		LoadedConfig.CORS = config.CORS{}
+	}()
+
+	allowList := config.CORS{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         600,
+	}
+	wildcard := allowList
+	wildcard.AllowedOrigins = []string{"*"}
+	wildcard.MaxAge = 0
+
+	tests := []struct {
+		name       string
+		cors       config.CORS
+		method     string
+		origin     string
+		preflight  bool
+		statusCode int
+		handled    bool
+		expected   map[string]string
+	}{
+		{"disabled", config.CORS{AllowedOrigins: []string{"*"}}, "GET", "https://example.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "", "Vary": ""}},
+		{"no origin", allowList, "GET", "", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"}},
+		{"allowed origin", allowList, "GET", "https://example.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Methods": ""}},
+		{"allowed origin of another case", allowList, "GET", "https://EXAMPLE.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "https://EXAMPLE.com"}},
+		{"disallowed origin", allowList, "GET", "https://other.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"}},
+		{"wildcard origin", wildcard, "POST", "https://other.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "*"}},
+		{"preflight", allowList, "OPTIONS", "https://example.com", true, http.StatusNoContent, false, map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Methods": "GET, POST", "Access-Control-Allow-Headers": "Authorization, Content-Type", "Access-Control-Max-Age": "600"}},
+		{"preflight without max age", wildcard, "OPTIONS", "https://other.com", true, http.StatusNoContent, false, map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Methods": "GET, POST", "Access-Control-Max-Age": ""}},
+		{"preflight of a disallowed origin", allowList, "OPTIONS", "https://other.com", true, http.StatusNoContent, false, map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": "", "Access-Control-Allow-Headers": "", "Access-Control-Max-Age": ""}},
+		{"options without a request method is no preflight", allowList, "OPTIONS", "https://example.com", false, http.StatusOK, true, map[string]string{"Access-Control-Allow-Origin": "https://example.com", "Access-Control-Allow-Methods": ""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			LoadedConfig.CORS = test.cors
+			handled := false
+			handler := corsHandler(func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(test.method, "/json/8.8.8.8", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				r.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != test.statusCode {
+				t.Errorf("got status %d, expected %d", w.Code, test.statusCode)
+			}
+			if handled != test.handled {
+				t.Errorf("got handled %t, expected %t", handled, test.handled)
+			}
+			for header, expected := range test.expected {
+				if value := w.Header().Get(header); value != expected {
+					t.Errorf("got %s %q, expected %q", header, value, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//enrichCSVPath - path of the csv enrichment endpoint
+const enrichCSVPath = "/enrich/csv"
+
+//tsvContentType - content type of tab separated input and output
+const tsvContentType = "text/tab-separated-values; charset=utf-8"
+
+//csvEnrichCommand - name of the csv enrichment subcommand
+const csvEnrichCommand = "enrich-csv"
+
+//defaultEnrichPrefix - prefix of the appended columns, {column} is replaced with the ip column's header (or number without a header)
+const defaultEnrichPrefix = "{column}_"
+
+//csvDelimiters - named delimiters, any other single character can be passed as is
+var csvDelimiters = map[string]rune{
+	"comma":     ',',
+	"tab":       '\t',
+	"semicolon": ';',
+	"pipe":      '|',
+}
+
+/*
+csvEnrichOptions - options of a csv enrichment
+columns - ip columns, by header name or 1 based number
+fields - fields appended for every ip column
+fieldOrder - names of the appended fields, in output order
+lang - lang the ips are looked up in
+key - IP-API key
+comma - delimiter of the input and output
+header - whether the first row is a header row
+prefix - prefix of the appended columns, see defaultEnrichPrefix
+ */
+type csvEnrichOptions struct {
+	columns    []string
+	fields     cache.Fields
+	fieldOrder []string
+	lang       string
+	key        string
+	comma      rune
+	header     bool
+	prefix     string
+}
+
+/*
+ipAPIEnrichCSV - handles csv enrichment requests, responds with the posted csv or tsv file with geo columns appended for every ip column
+the file is either the request body or the file field of a multipart upload
+ */
+func ipAPIEnrichCSV(w http.ResponseWriter, r *http.Request) {
+	promMetrics.IncrementRequestsProcessed()
+
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "csv enrichment only supports POST requests")
+		return
+	}
+
+	//uploaded files are read from the file form field
+	body := io.Reader(r.Body)
+	fileName := ""
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, fileHeader, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "error reading uploaded file: "+err.Error())
+			return
+		}
+		defer file.Close()
+		body = file
+		fileName = fileHeader.Filename
+		contentType = fileHeader.Header.Get("Content-Type")
+	}
+
+	query := r.URL.Query()
+	options, err := parseCSVEnrichOptions(query.Get("columns"), query.Get("fields"), query.Get("lang"), query.Get("delimiter"), query.Get("header"), query.Get("prefix"))
+	if err == nil && query.Get("delimiter") == "" && isTSV(fileName, contentType) {
+		options.comma = '\t'
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Get("key") != "" {
+		options.key = query.Get("key")
+	}
+
+	//build the file in memory so errors can still be returned as an error response
+	var output bytes.Buffer
+	stats, err := enrichCSV(r.Context(), body, &output, options)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logEnrichStats("csv rows", stats)
+
+	outputName := "enriched.csv"
+	outputContentType := "text/csv; charset=utf-8"
+	if options.comma == '\t' {
+		outputName = "enriched.tsv"
+		outputContentType = tsvContentType
+	}
+	if fileName != "" {
+		outputName = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)) + "_" + outputName
+	}
+
+	promMetrics.IncrementHandlerRequests("200")
+	w.Header().Set("Content-Type", outputContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+strings.ReplaceAll(outputName, `"`, "")+`"`)
+	w.Header().Set("X-Enrich-Rows", strconv.Itoa(stats.Rows))
+	w.Header().Set("X-Enrich-Queries", strconv.Itoa(stats.Queries))
+	w.Header().Set("X-Enrich-Failed", strconv.Itoa(stats.Failed))
+	w.WriteHeader(http.StatusOK)
+	_, _ = output.WriteTo(w)
+}
+
+/*
+runCSVEnrichCommand - enriches a csv or tsv file (or stdin) from the command line, without running the proxy
+args - arguments after the subcommand, the file to enrich follows the flags
+ */
+func runCSVEnrichCommand(args []string) error {
+	flags := flag.NewFlagSet(csvEnrichCommand, flag.ContinueOnError)
+	configLocation := flags.String("config", "", "Configuration file location. Defaults to working directory.")
+	columns := flags.String("columns", "", "Comma separated ip columns to enrich, by header name or 1 based number.")
+	fields := flags.String("fields", "", "Fields appended for every ip column. Defaults to the default fields.")
+	lang := flags.String("lang", "", "Lang the ips are looked up in.")
+	delimiter := flags.String("delimiter", "", "Delimiter of the csv (comma, tab, semicolon, pipe or a single character). Defaults to tab for .tsv files, otherwise comma.")
+	prefix := flags.String("prefix", defaultEnrichPrefix, "Prefix of the appended columns, {column} is replaced with the ip column's name.")
+	header := flags.Bool("header", true, "Whether the first row of the csv is a header row.")
+	output := flags.String("output", "", "File the enriched csv is written to. Defaults to stdout.")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("only one csv file can be enriched at a time")
+	}
+	input := flags.Arg(0)
+	if input == "" {
+		input = "-"
+	}
+
+	err = loadConfig(*configLocation)
+	if err != nil {
+		return err
+	}
+
+	options, err := parseCSVEnrichOptions(*columns, *fields, *lang, *delimiter, strconv.FormatBool(*header), *prefix)
+	if err != nil {
+		return err
+	}
+
+	initRateLimiters()
+	cache.ReadCache(&LoadedConfig.Cache.WriteLocation)
+	if err := enrichCSVFile(input, *output, options, *delimiter != ""); err != nil {
+		return err
+	}
+
+	//keep the lookups for the next run
+	if LoadedConfig.Cache.Persist {
+		if err := cache.WriteCache(&LoadedConfig.Cache.WriteLocation); err != nil {
+			log.Println("error: writing cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+enrichCSVFile - enriches a csv or tsv file, used by the enrich-csv subcommand
+inputName - file to read, - for stdin
+outputName - file to write, empty or - for stdout
+options - csvEnrichOptions
+delimiterSet - whether a delimiter was passed, otherwise .tsv input is read as tab separated
+ */
+func enrichCSVFile(inputName string, outputName string, options csvEnrichOptions, delimiterSet bool) error {
+	input := os.Stdin
+	if inputName != "-" {
+		file, err := os.Open(inputName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	if !delimiterSet && isTSV(inputName, "") {
+		options.comma = '\t'
+	}
+
+	output := os.Stdout
+	if outputName != "" && outputName != "-" {
+		file, err := os.Create(outputName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	stats, err := enrichCSV(context.Background(), input, output, options)
+	if err != nil {
+		return err
+	}
+	logEnrichStats("csv rows", stats)
+	return nil
+}
+
+/*
+parseCSVEnrichOptions - validates the options of a csv enrichment, shared by the endpoint and the enrich-csv subcommand
+columns - comma separated ip columns, by header name or 1 based number
+fields - fields parameter
+lang - lang parameter
+delimiter - delimiter name or character, empty for a comma
+header - whether the first row is a header row, empty for true
+prefix - prefix of the appended columns, empty for defaultEnrichPrefix
+ */
+func parseCSVEnrichOptions(columns string, fields string, lang string, delimiter string, header string, prefix string) (csvEnrichOptions, error) {
+	options := csvEnrichOptions{comma: ',', header: true, prefix: defaultEnrichPrefix, key: LoadedConfig.APIKey}
+
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if column != "" {
+			options.columns = append(options.columns, column)
+		}
+	}
+	if len(options.columns) == 0 {
+		return options, errors.New("no columns provided, expected the header names or numbers of the ip columns")
+	}
+
+	var err error
+	options.fields, err = cache.ParseFields(fields)
+	if err != nil {
+		return options, err
+	}
+	options.fieldOrder = requestedFieldOrder(fields, options.fields)
+
+	if lang != "" {
+		options.lang, err = ip_api.ValidateLang(lang)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	if delimiter != "" {
+		if comma, ok := csvDelimiters[strings.ToLower(delimiter)]; ok {
+			options.comma = comma
+		} else if utf8.RuneCountInString(delimiter) == 1 {
+			options.comma, _ = utf8.DecodeRuneInString(delimiter)
+		} else {
+			return options, errors.New("invalid delimiter provided, expected comma, tab, semicolon, pipe or a single character")
+		}
+		if options.comma == '"' || options.comma == '\r' || options.comma == '\n' {
+			return options, errors.New("invalid delimiter provided, expected comma, tab, semicolon, pipe or a single character")
+		}
+	}
+
+	if header != "" {
+		options.header, err = strconv.ParseBool(header)
+		if err != nil {
+			return options, errors.New("invalid header provided, expected true or false")
+		}
+	}
+
+	if prefix != "" {
+		options.prefix = prefix
+	}
+
+	return options, nil
+}
+
+//isTSV - checks if a file name or content type is tab separated
+func isTSV(fileName string, contentType string) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".tsv") || strings.HasPrefix(contentType, "text/tab-separated-values")
+}
+
+/*
+enrichCSV - appends geo columns for every ip column of a csv file
+every unique ip is only looked up once, from cache, or with IP-API in batches of up to maxBatchQueries
+a failed lookup, or an IP-API error, leaves the ip's columns empty (unless status or message were asked for)
+ctx - cancels the lookups
+reader - csv input
+writer - csv output, the input rows with the geo columns appended
+options - csvEnrichOptions
+
+returns
+EnrichStats
+error for invalid csv input or unknown columns
+ */
+func enrichCSV(ctx context.Context, reader io.Reader, writer io.Writer, options csvEnrichOptions) (EnrichStats, error) {
+	var stats EnrichStats
+
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = options.comma
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	rows, err := csvReader.ReadAll()
+	if err != nil {
+		return stats, errors.New("error reading csv: " + err.Error())
+	}
+	if len(rows) == 0 {
+		return stats, errors.New("csv is empty")
+	}
+
+	var header []string
+	if options.header {
+		header = rows[0]
+		rows = rows[1:]
+	}
+	stats.Rows = len(rows)
+
+	columnIndexes, columnNames, err := csvColumnIndexes(header, options.columns)
+	if err != nil {
+		return stats, err
+	}
+
+	//collect the unique ips, in the order they first appear
+	var queries []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for _, index := range columnIndexes {
+			if query := csvCell(row, index); query != "" && !seen[query] {
+				seen[query] = true
+				queries = append(queries, query)
+			}
+		}
+	}
+	stats.Queries = len(queries)
+
+	locations, err := lookupQueries(ctx, queries, options.fields, options.lang, options.key, &stats)
+	if err != nil {
+		return stats, err
+	}
+
+	//pad short rows, so the appended columns line up
+	width := len(header)
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	csvWriter.Comma = options.comma
+	if options.header {
+		for len(header) < width {
+			header = append(header, "")
+		}
+		for _, columnName := range columnNames {
+			prefix := strings.ReplaceAll(options.prefix, "{column}", columnName)
+			for _, field := range options.fieldOrder {
+				header = append(header, prefix+field)
+			}
+		}
+		_ = csvWriter.Write(header)
+	}
+	for _, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		for _, index := range columnIndexes {
+			location := locations[csvCell(row, index)]
+			for _, field := range options.fieldOrder {
+				value := ""
+				if location != nil {
+					value, _ = cache.LocationFieldValue(location, field)
+				}
+				row = append(row, value)
+			}
+		}
+		_ = csvWriter.Write(row)
+	}
+	csvWriter.Flush()
+	return stats, csvWriter.Error()
+}
+
+/*
+csvColumnIndexes - finds the ip columns of a csv file
+header - header row, nil if the file has none
+columns - header names (case insensitive) or 1 based numbers
+
+returns
+0 based column indexes
+column names used in the prefix of the appended columns
+error for an unknown column
+ */
+func csvColumnIndexes(header []string, columns []string) ([]int, []string, error) {
+	indexes := make([]int, len(columns))
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		indexes[i] = -1
+		for j, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), column) {
+				indexes[i] = j
+				break
+			}
+		}
+		if indexes[i] == -1 {
+			number, err := strconv.Atoi(column)
+			if err != nil || number < 1 || (header != nil && number > len(header)) {
+				return nil, nil, errors.New("column " + column + " not found")
+			}
+			indexes[i] = number - 1
+		}
+
+		names[i] = strconv.Itoa(indexes[i] + 1)
+		if header != nil {
+			names[i] = strings.TrimSpace(header[indexes[i]])
+		}
+	}
+	return indexes, names, nil
+}
+
+//csvCell - gets a trimmed cell of a row, empty if the row is too short
+func csvCell(row []string, index int) string {
+	if index >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[index])
+}
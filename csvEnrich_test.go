@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVColumnIndexes(t *testing.T) {
+	header := []string{"time", " Client IP ", "server_ip"}
+
+	tests := []struct {
+		name    string
+		header  []string
+		columns []string
+		indexes []int
+		names   []string
+		err     string
+	}{
+		{"header name", header, []string{"server_ip"}, []int{2}, []string{"server_ip"}, ""},
+		{"header name is trimmed and case insensitive", header, []string{"client ip"}, []int{1}, []string{"Client IP"}, ""},
+		{"number uses the header name", header, []string{"2"}, []int{1}, []string{"Client IP"}, ""},
+		{"several columns in order", header, []string{"server_ip", "2"}, []int{2, 1}, []string{"server_ip", "Client IP"}, ""},
+		{"number without a header", nil, []string{"3"}, []int{2}, []string{"3"}, ""},
+		{"number past the header", header, []string{"4"}, nil, nil, "column 4 not found"},
+		{"number below 1", nil, []string{"0"}, nil, nil, "column 0 not found"},
+		{"unknown header name", header, []string{"ip"}, nil, nil, "column ip not found"},
+		{"name without a header", nil, []string{"ip"}, nil, nil, "column ip not found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			indexes, names, err := csvColumnIndexes(test.header, test.columns)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(indexes, test.indexes) || !reflect.DeepEqual(names, test.names) {
+				t.Errorf("got %v %q, expected %v %q", indexes, names, test.indexes, test.names)
+			}
+		})
+	}
+}
+
+func TestEnrichCSV(t *testing.T) {
+	//only cached ips are enriched, so IP-API is never called
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Country: "United States", CountryCode: "US", City: "Mountain View", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddLocation("1.1.1.1", ip_api.Location{Status: "success", Country: "Australia", CountryCode: "AU", Query: "1.1.1.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddLocation("10.0.0.1", ip_api.Location{Status: "fail", Message: "private range", Query: "10.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cache.DeleteLocation("8.8.8.8")
+		cache.DeleteLocation("1.1.1.1")
+		cache.DeleteLocation("10.0.0.1")
+	}()
+
+	tests := []struct {
+		name      string
+		columns   string
+		fields    string
+		delimiter string
+		header    string
+		prefix    string
+		input     string
+		expected  string
+	}{
+		{
+			"header row",
+			"ip", "countryCode,city", "", "", "",
+			"time,ip\n1,8.8.8.8\n2,1.1.1.1\n",
+			"time,ip,ip_countryCode,ip_city\n1,8.8.8.8,US,Mountain View\n2,1.1.1.1,AU,\n",
+		},
+		{
+			"failed and empty ips leave their columns empty",
+			"ip", "countryCode", "", "", "",
+			"ip,note\n10.0.0.1,private\n,missing\n",
+			"ip,note,ip_countryCode\n10.0.0.1,private,\n,missing,\n",
+		},
+		{
+			"failed ips keep status and message",
+			"ip", "status,message", "", "", "",
+			"ip\n10.0.0.1\n",
+			"ip,ip_status,ip_message\n10.0.0.1,fail,private range\n",
+		},
+		{
+			"several columns",
+			"client,2", "countryCode", "", "", "{column}.",
+			"client,server\n8.8.8.8,1.1.1.1\n",
+			"client,server,client.countryCode,server.countryCode\n8.8.8.8,1.1.1.1,US,AU\n",
+		},
+		{
+			"short rows are padded",
+			"1", "countryCode", "", "", "",
+			"ip,note\n8.8.8.8\n1.1.1.1,long,row\n",
+			"ip,note,,ip_countryCode\n8.8.8.8,,,US\n1.1.1.1,long,row,AU\n",
+		},
+		{
+			"no header row",
+			"1", "country", "tab", "false", "",
+			"8.8.8.8\ta\n1.1.1.1\tb\n",
+			"8.8.8.8\ta\tUnited States\n1.1.1.1\tb\tAustralia\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := parseCSVEnrichOptions(test.columns, test.fields, "", test.delimiter, test.header, test.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output bytes.Buffer
+			if _, err = enrichCSV(context.Background(), strings.NewReader(test.input), &output, options); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.expected {
+				t.Errorf("got %q, expected %q", output.String(), test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//dnsFields - fields looked up for a dns answer
+const dnsFields = cache.FieldStatus | cache.FieldMessage | cache.FieldCountryCode | cache.FieldAS | cache.FieldCity | cache.FieldQuery
+
+//Labels in front of the zone selecting the address family (ex: 4.3.2.1.origin.geo.internal)
+const (
+	dnsIPv4Label = "origin"
+	dnsIPv6Label = "ip6"
+)
+
+//maxDNSUDPSize - largest udp response without EDNS, larger answers are truncated so the client retries over tcp
+const maxDNSUDPSize = 512
+
+//dnsTCPTimeout - how long an idle tcp dns connection is kept open
+const dnsTCPTimeout = 10 * time.Second
+
+//maxDNSUDPRequests - most udp queries answered at once, further datagrams wait in the socket buffer
+const maxDNSUDPRequests = 256
+
+/*
+dnsServer - dns front-end answering geo TXT queries, Team Cymru style
+udp - udp listener
+tcp - tcp listener
+ */
+type dnsServer struct {
+	udp net.PacketConn
+	tcp net.Listener
+}
+
+/*
+startDNS - starts the dns front-end on the configured dns port, over udp and tcp
+
+returns
+dnsServer, serving in the background
+error if the port can't be listened on
+ */
+func startDNS() (*dnsServer, error) {
+	address := ":" + strconv.Itoa(LoadedConfig.DNS.Port)
+	udp, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		_ = udp.Close()
+		return nil, err
+	}
+	server := &dnsServer{udp: udp, tcp: tcp}
+
+	log.Println("Starting DNS server on port " + strconv.Itoa(LoadedConfig.DNS.Port) + " for zone " + LoadedConfig.DNS.Zone + "...")
+	go server.serveUDP()
+	go server.serveTCP()
+	return server, nil
+}
+
+//Close - stops the dns listeners
+func (s *dnsServer) Close() error {
+	udpErr := s.udp.Close()
+	tcpErr := s.tcp.Close()
+	if udpErr != nil {
+		return udpErr
+	}
+	return tcpErr
+}
+
+//serveUDP - answers udp dns queries until the listener is closed, up to maxDNSUDPRequests at once
+func (s *dnsServer) serveUDP() {
+	buffer := make([]byte, math.MaxUint16)
+	requests := make(chan struct{}, maxDNSUDPRequests)
+	for {
+		requests <- struct{}{}
+		n, address, err := s.udp.ReadFrom(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("error: dns udp: " + err.Error())
+			}
+			return
+		}
+		request := append([]byte(nil), buffer[:n]...)
+		go func() {
+			defer func() { <-requests }()
+			response := answerDNS(request, true)
+			if response != nil {
+				_, _ = s.udp.WriteTo(response, address)
+			}
+		}()
+	}
+}
+
+//serveTCP - answers tcp dns queries until the listener is closed
+func (s *dnsServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("error: dns tcp: " + err.Error())
+			}
+			return
+		}
+		go serveDNSConn(conn)
+	}
+}
+
+//serveDNSConn - answers the length prefixed dns queries of a tcp connection, until it is closed or idle
+func serveDNSConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		_ = conn.SetDeadline(time.Now().Add(dnsTCPTimeout))
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		request := make([]byte, length)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		response := answerDNS(request, false)
+		if response == nil {
+			return
+		}
+		prefixed := make([]byte, 2, 2+len(response))
+		binary.BigEndian.PutUint16(prefixed, uint16(len(response)))
+		if _, err := conn.Write(append(prefixed, response...)); err != nil {
+			return
+		}
+	}
+}
+
+/*
+answerDNS - builds the response to a dns query
+TXT queries for addresses under the zone are answered with "country | asn | city", with the cache record's time left as TTL
+addresses which aren't cached are looked up within the dns lookup budget, and answered with SERVFAIL if it runs out, so the client retries once the lookup is cached
+request - dns query message
+udp - whether the response goes over udp, and has to be truncated to maxDNSUDPSize
+
+returns
+response message, nil if the request can't be parsed
+ */
+func answerDNS(request []byte, udp bool) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(request)
+	if err != nil || header.Response {
+		return nil
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return dnsResponse(header, nil, dnsmessage.RCodeFormatError, "", 0, udp)
+	}
+
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementSingleRequestsProcessed()
+
+	query, err := parseDNSName(question.Name.String())
+	if err == errDNSOutsideZone {
+		return dnsResponse(header, &question, dnsmessage.RCodeRefused, "", 0, udp)
+	} else if err != nil {
+		if LoadedConfig.Debugging {
+			log.Println("Failed dns request: " + question.Name.String() + " " + err.Error())
+		}
+		return dnsResponse(header, &question, dnsmessage.RCodeNameError, "", 0, udp)
+	}
+
+	//names under the zone exist for every type, but only have TXT records
+	if question.Type != dnsmessage.TypeTXT || question.Class != dnsmessage.ClassINET {
+		return dnsResponse(header, &question, dnsmessage.RCodeSuccess, "", 0, udp)
+	}
+
+	promMetrics.IncrementSingleQueriesProcessed()
+	promMetrics.IncrementQueriesProcessed()
+
+	//Get location from cache, or from IP-API if it isn't cached
+	location, result := lookupWithinBudget(query, dnsFields, *LoadedConfig.DNS.LookupBudgetDuration)
+	if location == nil {
+		if LoadedConfig.Debugging {
+			log.Println("Failed dns request: " + query + " lookup " + result)
+		}
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		return dnsResponse(header, &question, dnsmessage.RCodeServerFailure, "", 0, udp)
+	}
+
+	//failed queries (ex: reserved ranges) have no answer, like unrouted addresses
+	if location.Status == "fail" {
+		promMetrics.IncrementFailedQueries()
+		promMetrics.IncrementFailedSingleQueries()
+		return dnsResponse(header, &question, dnsmessage.RCodeNameError, "", 0, udp)
+	}
+	promMetrics.IncrementSuccessfulQueries()
+	promMetrics.IncrementSuccessfulSingeQueries()
+
+	asNumber, _ := utils.ParseAS(location.AS)
+	asn := ""
+	if asNumber != 0 {
+		asn = strconv.Itoa(asNumber)
+	}
+	answer := location.CountryCode + " | " + asn + " | " + location.City
+
+	return dnsResponse(header, &question, dnsmessage.RCodeSuccess, answer, dnsTTL(query), udp)
+}
+
+/*
+dnsTTL - gets the TTL of an answer, the time left until its cache record expires
+query - looked up address
+ */
+func dnsTTL(query string) uint32 {
+	ttl := *LoadedConfig.Cache.SuccessAgeDuration
+	if record, found, _ := cache.GetRecord(query, dnsFields); found {
+		ttl = time.Until(record.ExpirationTime)
+	}
+	if ttl < time.Second {
+		return 1
+	}
+	return uint32(ttl / time.Second)
+}
+
+/*
+dnsResponse - builds a dns response message
+header - header of the query
+question - question of the query, nil if it couldn't be parsed
+rCode - response code
+answer - TXT answer, "" for none
+ttl - TTL of the answer
+udp - truncate the response to maxDNSUDPSize
+ */
+func dnsResponse(header dnsmessage.Header, question *dnsmessage.Question, rCode dnsmessage.RCode, answer string, ttl uint32, udp bool) []byte {
+	responseHeader := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    rCode != dnsmessage.RCodeRefused,
+		RecursionDesired: header.RecursionDesired,
+		RCode:            rCode,
+	}
+
+	response := buildDNSResponse(responseHeader, question, answer, ttl)
+
+	//answers are short, but a long city name could still not fit, the client retries over tcp when TC is set
+	if udp && len(response) > maxDNSUDPSize {
+		responseHeader.Truncated = true
+		response = buildDNSResponse(responseHeader, question, "", 0)
+	}
+	return response
+}
+
+/*
+buildDNSResponse - builds a dns response message with a header as is
+answer - TXT answer, split into 255 byte strings, "" for none
+
+returns
+response message, nil if it can't be built
+ */
+func buildDNSResponse(responseHeader dnsmessage.Header, question *dnsmessage.Question, answer string, ttl uint32) []byte {
+	builder := dnsmessage.NewBuilder(nil, responseHeader)
+	builder.EnableCompression()
+	_ = builder.StartQuestions()
+	if question != nil {
+		_ = builder.Question(*question)
+	}
+	if answer != "" {
+		//a TXT character string holds up to 255 bytes, longer answers are split over several
+		var txt []string
+		for len(answer) > 255 {
+			txt = append(txt, answer[:255])
+			answer = answer[255:]
+		}
+		txt = append(txt, answer)
+
+		_ = builder.StartAnswers()
+		_ = builder.TXTResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.TXTResource{TXT: txt})
+	}
+	response, err := builder.Finish()
+	if err != nil {
+		log.Println("error: building dns response: " + err.Error())
+		return nil
+	}
+	return response
+}
+
+//errDNSOutsideZone - the queried name isn't under the configured zone
+var errDNSOutsideZone = errors.New("name is outside of the zone")
+
+/*
+parseDNSName - gets the address a name under the zone is for
+IPv4 addresses are written as reversed octets followed by origin (ex: 4.3.2.1.origin.geo.internal for 1.2.3.4)
+IPv6 addresses are written as reversed nibbles followed by ip6, like ip6.arpa
+name - queried name
+
+returns
+address
+errDNSOutsideZone, or an error for an invalid name under the zone
+ */
+func parseDNSName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	zone := LoadedConfig.DNS.Zone
+	if !strings.HasSuffix(name, "."+zone) {
+		return "", errDNSOutsideZone
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, "."+zone), ".")
+	family := labels[len(labels)-1]
+	labels = labels[:len(labels)-1]
+
+	var address string
+	switch family {
+	case dnsIPv4Label:
+		if len(labels) != 4 {
+			return "", errors.New("expected 4 octets")
+		}
+		octets := make([]string, 4)
+		for i, label := range labels {
+			octets[3-i] = label
+		}
+		address = strings.Join(octets, ".")
+		if ip := net.ParseIP(address); ip == nil || ip.To4() == nil {
+			return "", errors.New("invalid ipv4 address")
+		}
+	case dnsIPv6Label:
+		if len(labels) != 32 {
+			return "", errors.New("expected 32 nibbles")
+		}
+		var nibbles strings.Builder
+		for i := len(labels) - 1; i >= 0; i-- {
+			if len(labels[i]) != 1 || !strings.Contains("0123456789abcdef", labels[i]) {
+				return "", errors.New("invalid nibble " + labels[i])
+			}
+			nibbles.WriteString(labels[i])
+			if i%4 == 0 && i != 0 {
+				nibbles.WriteByte(':')
+			}
+		}
+		ip := net.ParseIP(nibbles.String())
+		if ip == nil {
+			return "", errors.New("invalid ipv6 address")
+		}
+		address = ip.String()
+	default:
+		return "", errors.New("expected " + dnsIPv4Label + " or " + dnsIPv6Label + " before the zone")
+	}
+	return address, nil
+}
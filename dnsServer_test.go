@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"golang.org/x/net/dns/dnsmessage"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDNSName(t *testing.T) {
+	LoadedConfig.DNS.Zone = "geo.internal"
+
+	//2001:4860:4860::8888 as reversed nibbles
+	ipv6Nibbles := "8.8.8.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.6.8.4.0.6.8.4.1.0.0.2"
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+		err      string
+	}{
+		{"ipv4", "4.3.2.1.origin.geo.internal", "1.2.3.4", ""},
+		{"ipv4 fqdn", "8.8.8.8.origin.geo.internal.", "8.8.8.8", ""},
+		{"ipv4 upper case", "4.3.2.1.ORIGIN.Geo.Internal", "1.2.3.4", ""},
+		{"ipv6", ipv6Nibbles + ".ip6.geo.internal", "2001:4860:4860::8888", ""},
+		{"ipv6 upper case nibbles", strings.ToUpper("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2") + ".ip6.geo.internal", "2001:db8::1", ""},
+		{"outside zone", "4.3.2.1.origin.example.com", "", errDNSOutsideZone.Error()},
+		{"zone suffix without a dot", "4.3.2.1.origin.notgeo.internal", "", errDNSOutsideZone.Error()},
+		{"zone apex", "geo.internal", "", errDNSOutsideZone.Error()},
+		{"no family label", "4.3.2.1.geo.internal", "", "expected origin or ip6 before the zone"},
+		{"ipv4 too few octets", "3.2.1.origin.geo.internal", "", "expected 4 octets"},
+		{"ipv4 too many octets", "5.4.3.2.1.origin.geo.internal", "", "expected 4 octets"},
+		{"ipv4 octet out of range", "256.3.2.1.origin.geo.internal", "", "invalid ipv4 address"},
+		{"ipv4 not a number", "a.3.2.1.origin.geo.internal", "", "invalid ipv4 address"},
+		{"ipv6 too few nibbles", strings.TrimPrefix(ipv6Nibbles, "8.") + ".ip6.geo.internal", "", "expected 32 nibbles"},
+		{"ipv6 invalid nibble", "g" + strings.TrimPrefix(ipv6Nibbles, "8") + ".ip6.geo.internal", "", "invalid nibble g"},
+		{"ipv6 long label", "88" + strings.TrimPrefix(ipv6Nibbles, "8") + ".ip6.geo.internal", "", "invalid nibble 88"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, err := parseDNSName(test.query)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if address != test.expected {
+				t.Errorf("got %s, expected %s", address, test.expected)
+			}
+		})
+	}
+}
+
+//dnsQuery - builds a dns query message for a name and type
+func dnsQuery(t *testing.T, name string, queryType dnsmessage.Type) []byte {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 4242, RecursionDesired: true})
+	_ = builder.StartQuestions()
+	if err := builder.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: queryType, Class: dnsmessage.ClassINET}); err != nil {
+		t.Fatal(err)
+	}
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func TestAnswerDNS(t *testing.T) {
+	LoadedConfig.DNS.Zone = "geo.internal"
+	budget := time.Second
+	LoadedConfig.DNS.LookupBudgetDuration = &budget
+	successAge := time.Hour
+	LoadedConfig.Cache.SuccessAgeDuration = &successAge
+
+	longCity := strings.Repeat("Llanfairpwllgwyngyll", 30)
+
+	//only cached addresses are asked for, so IP-API is never called
+	locations := map[string]ip_api.Location{
+		"192.0.2.1": {Status: "success", CountryCode: "US", AS: "AS15169 Google LLC", City: "Mountain View", Query: "192.0.2.1"},
+		"192.0.2.2": {Status: "success", CountryCode: "GB", City: longCity, Query: "192.0.2.2"},
+		"192.0.2.3": {Status: "fail", Message: "reserved range", Query: "192.0.2.3"},
+	}
+	for query, location := range locations {
+		if _, err := cache.AddLocation(query, location, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for query := range locations {
+			cache.DeleteLocation(query)
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		query     string
+		queryType dnsmessage.Type
+		udp       bool
+		rCode     dnsmessage.RCode
+		truncated bool
+		answer    string
+	}{
+		{"answer", "1.2.0.192.origin.geo.internal.", dnsmessage.TypeTXT, true, dnsmessage.RCodeSuccess, false, "US | 15169 | Mountain View"},
+		{"long answer is truncated over udp", "2.2.0.192.origin.geo.internal.", dnsmessage.TypeTXT, true, dnsmessage.RCodeSuccess, true, ""},
+		{"long answer over tcp", "2.2.0.192.origin.geo.internal.", dnsmessage.TypeTXT, false, dnsmessage.RCodeSuccess, false, "GB |  | " + longCity},
+		{"failed lookup", "3.2.0.192.origin.geo.internal.", dnsmessage.TypeTXT, true, dnsmessage.RCodeNameError, false, ""},
+		{"other types have no answer", "1.2.0.192.origin.geo.internal.", dnsmessage.TypeA, true, dnsmessage.RCodeSuccess, false, ""},
+		{"invalid name", "1.2.0.origin.geo.internal.", dnsmessage.TypeTXT, true, dnsmessage.RCodeNameError, false, ""},
+		{"outside zone", "1.2.0.192.origin.example.com.", dnsmessage.TypeTXT, true, dnsmessage.RCodeRefused, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := answerDNS(dnsQuery(t, test.query, test.queryType), test.udp)
+			if test.udp && len(response) > maxDNSUDPSize {
+				t.Errorf("got %d bytes, expected at most %d over udp", len(response), maxDNSUDPSize)
+			}
+
+			var parser dnsmessage.Parser
+			header, err := parser.Start(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.ID != 4242 || !header.Response || header.RCode != test.rCode || header.Truncated != test.truncated {
+				t.Errorf("got id %d, response %t, rcode %s and truncated %t, expected 4242, true, %s and %t", header.ID, header.Response, header.RCode, header.Truncated, test.rCode, test.truncated)
+			}
+			if err = parser.SkipAllQuestions(); err != nil {
+				t.Fatal(err)
+			}
+			answers, err := parser.AllAnswers()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			answer := ""
+			for _, resource := range answers {
+				if txt, ok := resource.Body.(*dnsmessage.TXTResource); ok {
+					answer += strings.Join(txt.TXT, "")
+				}
+			}
+			if answer != test.answer {
+				t.Errorf("got answer %q, expected %q", answer, test.answer)
+			}
+		})
+	}
+}
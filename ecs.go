@@ -1,5 +1,25 @@
 package main
 
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//ECS output modes
+const (
+	//ecsFlat - the original flat EcsLocation (ecs=true or ecs=flat)
+	ecsFlat = "flat"
+	//ecsNested - nested ECS geo and as fields (ecs=nested)
+	ecsNested = "nested"
+)
+
+//ecsPrefixes - objects the nested ECS fields can be placed under
+var ecsPrefixes = []string{"source", "destination", "client"}
+
+//EcsLocation - flat ECS output (ecs=flat)
 type EcsLocation struct {
 	Status 			string		`json:"status,omitempty"`
 	Message			string		`json:"message,omitempty"`
@@ -25,4 +45,260 @@ type EcsLocation struct {
 	Proxy			*bool		`json:"proxy,omitempty"`
 	Hosting			*bool		`json:"hosting,omitempty"`
 	Query			string		`json:"query,omitempty"`
-}
\ No newline at end of file
+}
+
+//ecsFieldNames - maps IP-API field names to the ECS names used by EcsLocation
+var ecsFieldNames = map[string]string{
+	"status":        "status",
+	"message":       "message",
+	"continent":     "continent_name",
+	"continentCode": "continent_iso_code",
+	"country":       "country_name",
+	"countryCode":   "country_iso_code",
+	"region":        "region_iso_code",
+	"regionName":    "region_name",
+	"city":          "city_name",
+	"district":      "district",
+	"zip":           "postal_code",
+	"lat":           "lat",
+	"lon":           "lon",
+	"timezone":      "timezone",
+	"currency":      "currency",
+	"isp":           "isp",
+	"org":           "org",
+	"as":            "as",
+	"asname":        "as_name",
+	"reverse":       "reverse",
+	"mobile":        "mobile",
+	"proxy":         "proxy",
+	"hosting":       "hosting",
+	"query":         "query",
+}
+
+/*
+toEcsLocation - converts an ip_api Location into an EcsLocation
+location - ip_api location
+ */
+func toEcsLocation(location *ip_api.Location) EcsLocation {
+	return EcsLocation{
+		Status:        location.Status,
+		Message:       location.Message,
+		Continent:     location.Continent,
+		ContinentCode: location.ContinentCode,
+		Country:       location.Country,
+		CountryCode:   location.CountryCode,
+		Region:        location.Region,
+		RegionName:    location.RegionName,
+		City:          location.City,
+		District:      location.District,
+		ZIP:           location.ZIP,
+		Lat:           location.Lat,
+		Lon:           location.Lon,
+		Timezone:      location.Timezone,
+		Currency:      location.Currency,
+		ISP:           location.ISP,
+		Org:           location.Org,
+		AS:            location.AS,
+		ASName:        location.ASName,
+		Reverse:       location.Reverse,
+		Mobile:        location.Mobile,
+		Proxy:         location.Proxy,
+		Hosting:       location.Hosting,
+		Query:         location.Query,
+	}
+}
+
+//EcsNestedLocation - nested ECS output (ecs=nested), the geo and as fields are inline unless a prefix is used
+type EcsNestedLocation struct {
+	Status      string     `json:"status,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	Query       string     `json:"query,omitempty"`
+	*EcsEntity
+	Source      *EcsEntity `json:"source,omitempty"`
+	Destination *EcsEntity `json:"destination,omitempty"`
+	Client      *EcsEntity `json:"client,omitempty"`
+	IPAPI       *EcsIPAPI  `json:"ip_api,omitempty"`
+}
+
+//EcsEntity - ECS fields describing the queried address (ex: source.ip, source.geo.*, source.as.*)
+type EcsEntity struct {
+	IP     string  `json:"ip,omitempty"`
+	Domain string  `json:"domain,omitempty"`
+	Geo    *EcsGeo `json:"geo,omitempty"`
+	AS     *EcsAS  `json:"as,omitempty"`
+}
+
+//EcsGeo - ECS geo fields, see https://www.elastic.co/guide/en/ecs/current/ecs-geo.html
+type EcsGeo struct {
+	Location       *EcsGeoPoint `json:"location,omitempty"`
+	ContinentName  string       `json:"continent_name,omitempty"`
+	ContinentCode  string       `json:"continent_code,omitempty"`
+	CountryName    string       `json:"country_name,omitempty"`
+	CountryISOCode string       `json:"country_iso_code,omitempty"`
+	RegionName     string       `json:"region_name,omitempty"`
+	RegionISOCode  string       `json:"region_iso_code,omitempty"`
+	CityName       string       `json:"city_name,omitempty"`
+	PostalCode     string       `json:"postal_code,omitempty"`
+	Timezone       string       `json:"timezone,omitempty"`
+}
+
+//EcsGeoPoint - Elasticsearch geo_point object
+type EcsGeoPoint struct {
+	Lat *float32 `json:"lat"`
+	Lon *float32 `json:"lon"`
+}
+
+//EcsAS - ECS as fields, see https://www.elastic.co/guide/en/ecs/current/ecs-as.html
+type EcsAS struct {
+	Number       int              `json:"number,omitempty"`
+	Organization *EcsOrganization `json:"organization,omitempty"`
+}
+
+//EcsOrganization - ECS as.organization fields
+type EcsOrganization struct {
+	Name string `json:"name,omitempty"`
+}
+
+//EcsIPAPI - IP-API fields that have no ECS equivalent
+type EcsIPAPI struct {
+	District string `json:"district,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	ISP      string `json:"isp,omitempty"`
+	Org      string `json:"org,omitempty"`
+	ASName   string `json:"asname,omitempty"`
+	Mobile   *bool  `json:"mobile,omitempty"`
+	Proxy    *bool  `json:"proxy,omitempty"`
+	Hosting  *bool  `json:"hosting,omitempty"`
+}
+
+/*
+parseEcs - parses the ecs request value
+ecs - flat, nested, or a bool (true is flat, like it always was)
+
+returns
+ECS output mode, "" for IP-API output
+ */
+func parseEcs(ecs string) string {
+	switch strings.ToLower(ecs) {
+	case ecsFlat:
+		return ecsFlat
+	case ecsNested:
+		return ecsNested
+	}
+	if ecsBool, _ := strconv.ParseBool(ecs); ecsBool {
+		return ecsFlat
+	}
+	return ""
+}
+
+/*
+validEcsPrefix - checks that an ECS prefix is one of ecsPrefixes
+prefix - ecsPrefix request value
+ */
+func validEcsPrefix(prefix string) bool {
+	for _, ecsPrefix := range ecsPrefixes {
+		if prefix == ecsPrefix {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+toEcsOutput - converts an ip_api Location into the requested ECS output
+location - ip_api location
+ecs - ECS output mode, "" returns location unchanged
+prefix - ECS prefix for nested output, "" for none
+ */
+func toEcsOutput(location *ip_api.Location, ecs string, prefix string) interface{} {
+	switch ecs {
+	case ecsFlat:
+		ecsLocation := toEcsLocation(location)
+		return &ecsLocation
+	case ecsNested:
+		ecsNestedLocation := toEcsNestedLocation(location, prefix)
+		return &ecsNestedLocation
+	}
+	return location
+}
+
+/*
+toEcsNestedLocation - converts an ip_api Location into an EcsNestedLocation
+location - ip_api location
+prefix - source, destination, client, or "" to leave the ECS fields at the top level
+ */
+func toEcsNestedLocation(location *ip_api.Location, prefix string) EcsNestedLocation {
+	ecsNestedLocation := EcsNestedLocation{
+		Status:  location.Status,
+		Message: location.Message,
+		Query:   location.Query,
+	}
+
+	entity := &EcsEntity{}
+	//ip is an ECS ip field, so it is only set for IP queries, DNS queries are the domain instead
+	if net.ParseIP(location.Query) != nil {
+		entity.IP = location.Query
+		entity.Domain = location.Reverse
+	} else {
+		entity.Domain = location.Query
+	}
+
+	geo := EcsGeo{
+		ContinentName:  location.Continent,
+		ContinentCode:  location.ContinentCode,
+		CountryName:    location.Country,
+		CountryISOCode: location.CountryCode,
+		RegionName:     location.RegionName,
+		RegionISOCode:  location.Region,
+		CityName:       location.City,
+		PostalCode:     location.ZIP,
+		Timezone:       location.Timezone,
+	}
+	//ECS region codes include the country (ex: US-NJ)
+	if location.Region != "" && location.CountryCode != "" {
+		geo.RegionISOCode = location.CountryCode + "-" + location.Region
+	}
+	if location.Lat != nil && location.Lon != nil {
+		geo.Location = &EcsGeoPoint{Lat: location.Lat, Lon: location.Lon}
+	}
+	if geo != (EcsGeo{}) {
+		entity.Geo = &geo
+	}
+
+	asNumber, asOrganization := utils.ParseAS(location.AS)
+	if asNumber != 0 || asOrganization != "" {
+		entity.AS = &EcsAS{Number: asNumber}
+		if asOrganization != "" {
+			entity.AS.Organization = &EcsOrganization{Name: asOrganization}
+		}
+	}
+
+	if *entity != (EcsEntity{}) {
+		switch prefix {
+		case "source":
+			ecsNestedLocation.Source = entity
+		case "destination":
+			ecsNestedLocation.Destination = entity
+		case "client":
+			ecsNestedLocation.Client = entity
+		default:
+			ecsNestedLocation.EcsEntity = entity
+		}
+	}
+
+	ipAPI := EcsIPAPI{
+		District: location.District,
+		Currency: location.Currency,
+		ISP:      location.ISP,
+		Org:      location.Org,
+		ASName:   location.ASName,
+		Mobile:   location.Mobile,
+		Proxy:    location.Proxy,
+		Hosting:  location.Hosting,
+	}
+	if ipAPI != (EcsIPAPI{}) {
+		ecsNestedLocation.IPAPI = &ipAPI
+	}
+
+	return ecsNestedLocation
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseEcs(t *testing.T) {
+	tests := []struct {
+		ecs      string
+		expected string
+	}{
+		{"", ""},
+		{"false", ""},
+		{"0", ""},
+		{"invalid", ""},
+		{"true", ecsFlat},
+		{"1", ecsFlat},
+		{"flat", ecsFlat},
+		{"nested", ecsNested},
+		{"Nested", ecsNested},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ecs, func(t *testing.T) {
+			if ecs := parseEcs(test.ecs); ecs != test.expected {
+				t.Errorf("got %q, expected %q", ecs, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"github.com/BenB196/ip-api-proxy/mmdbExport"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+//exportMMDBPath - path of the MMDB export endpoint
+const exportMMDBPath = "/export/mmdb"
+
+/*
+exportMMDB - handles MMDB export requests, responds with the cache as a GeoLite2 City compatible database
+aggregate=true collapses identical hosts into the configured export prefixes
+when clients are configured, requests must authenticate with HTTP basic auth as one of them
+ */
+func exportMMDB(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "export only supports GET requests")
+		return
+	}
+
+	//authenticate client if any are configured
+	if len(LoadedConfig.Clients) > 0 {
+		if client, _ := authenticateClient(r); client == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ip-api-proxy"`)
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing client credentials")
+			return
+		}
+	}
+
+	aggregate := false
+	if aggregateParam := r.URL.Query().Get("aggregate"); aggregateParam != "" {
+		var err error
+		aggregate, err = strconv.ParseBool(aggregateParam)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid aggregate provided, expected true or false")
+			return
+		}
+	}
+
+	//build the database in memory so errors can still be returned as an error response
+	var database bytes.Buffer
+	networks, err := mmdbExport.Export(&database, exportOptions(aggregate))
+	if err != nil {
+		log.Println("error: exporting mmdb: " + err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "error exporting mmdb: "+err.Error())
+		return
+	}
+
+	log.Println("Exported " + strconv.Itoa(networks) + " networks to mmdb")
+	promMetrics.IncrementHandlerRequests("200")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="ip-api-proxy.mmdb"`)
+	w.Header().Set("Content-Length", strconv.Itoa(database.Len()))
+	w.WriteHeader(http.StatusOK)
+	_, _ = database.WriteTo(w)
+}
+
+/*
+exportMMDBFile - writes the cache to an MMDB file, used by the --exportMMDB flag
+fileName - database file to write
+aggregate - collapse identical hosts into the configured export prefixes
+ */
+func exportMMDBFile(fileName string, aggregate bool) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	networks, err := mmdbExport.Export(file, exportOptions(aggregate))
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	log.Println("Exported " + strconv.Itoa(networks) + " networks to " + fileName)
+	return file.Close()
+}
+
+//exportOptions - builds the mmdbExport Options from the loaded config
+func exportOptions(aggregate bool) mmdbExport.Options {
+	return mmdbExport.Options{
+		Aggregate:  aggregate,
+		IPv4Prefix: LoadedConfig.Export.IPv4AggregatePrefix,
+		IPv6Prefix: LoadedConfig.Export.IPv6AggregatePrefix,
+	}
+}
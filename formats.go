@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/outputProfile"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//Response formats, each one matches the IP-API endpoint of the same name
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+	formatCSV  = "csv"
+	formatLine = "line"
+	formatPHP  = "php"
+)
+
+var formatContentTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatXML:     "application/xml; charset=utf-8",
+	formatCSV:     "text/csv; charset=utf-8",
+	formatLine:    "text/plain; charset=utf-8",
+	formatPHP:     "text/plain; charset=utf-8",
+	formatGeoJSON: geoJSONContentType,
+	formatNDJSON:  "application/x-ndjson",
+	formatMsgPack: "application/msgpack",
+}
+
+//JSONP callbacks are limited to (dotted) javascript identifiers so that a callback can't inject script
+var callbackRegexp = regexp.MustCompile(`^[a-zA-Z_$][0-9a-zA-Z_$]*(\.[a-zA-Z_$][0-9a-zA-Z_$]*)*$`)
+
+//maxCallbackLength - longest callback name accepted
+const maxCallbackLength = 128
+
+//fields IP-API returns for a failed query regardless of the requested fields
+const failedFields = cache.FieldStatus | cache.FieldMessage | cache.FieldQuery
+
+/*
+formatField - a single field value of a location as IP-API prints it
+name - IP-API field name (or ECS or profile name when one is requested)
+value - string value of the field
+kind - PHP serialize type of the field (s = string, d = double, i = integer, b = boolean)
+ */
+type formatField struct {
+	name  string
+	value string
+	kind  string
+}
+
+/*
+outputOptions - request options which change how a location is written
+fields - requested Fields, used by the non json formats to decide which fields to print
+format - one of the format constants
+ecs - ECS output mode (flat, nested), "" for IP-API output, the non json formats use the flat ECS field names
+ecsPrefix - object the nested ECS fields are placed under (source, destination, client), "" for none
+callback - JSONP callback to wrap json responses in, empty for none
+profile - output profile selected with profile=name, nil for none, replaces the IP-API and ECS output
+fieldOrder - requested field names in the order they were requested, the column order of negotiated csv output
+header - csv output starts with a header row, true when csv is negotiated on the json and batch endpoints
+meta - json locations carry their locationMeta, set with meta=true on the json and batch endpoints
+ */
+type outputOptions struct {
+	fields     cache.Fields
+	format     string
+	ecs        string
+	ecsPrefix  string
+	callback   string
+	profile    *outputProfile.Profile
+	fieldOrder []string
+	header     bool
+	meta       bool
+}
+
+/*
+negotiatedOptions - gets the outputOptions of a json or batch request from its format parameter or Accept header
+ */
+func negotiatedOptions(r *http.Request) (outputOptions, error) {
+	format, err := negotiateFormat(r)
+	options := outputOptions{format: format, header: format == formatCSV}
+	return options, err
+}
+
+/*
+setContentType - sets the content type of the requested format
+ */
+func setContentType(w http.ResponseWriter, options outputOptions) {
+	if options.header {
+		w.Header().Set("Content-Type", csvHeaderContentType)
+	} else {
+		w.Header().Set("Content-Type", formatContentTypes[options.format])
+	}
+}
+
+/*
+toOutput - converts a location into the json value written for it, following the requested profile or ECS output
+location - ip_api location
+options - outputOptions of the request
+ */
+func toOutput(location *ip_api.Location, options outputOptions) interface{} {
+	if options.profile != nil {
+		return options.profile.Output(location)
+	}
+	return toEcsOutput(location, options.ecs, options.ecsPrefix)
+}
+
+/*
+writeLocation - writes a location to the response in the requested format
+statusCode - HTTP status code
+location - ip_api location, already projected to the requested fields
+options - outputOptions of the request
+ */
+func writeLocation(w http.ResponseWriter, statusCode int, location *ip_api.Location, options outputOptions) {
+	statusCode, body := locationBody(w, statusCode, location, nil, options)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+/*
+writeJSONError - writes an error of an endpoint in the proxy's fail response shape
+statusCode - HTTP status code
+message - error message
+ */
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+	location := ip_api.Location{Status: "fail", Message: message}
+	jsonLocation, _ := json.Marshal(&location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(jsonLocation)
+}
+
+/*
+locationBody - encodes a location in the requested format, and sets the headers of JSONP responses
+statusCode - HTTP status code
+location - ip_api location, already projected to the requested fields
+meta - locationMeta added to json output, nil for none
+options - outputOptions of the request
+
+returns
+HTTP status code to write, JSONP responses are always 200
+encoded location
+ */
+func locationBody(w http.ResponseWriter, statusCode int, location *ip_api.Location, meta *locationMeta, options outputOptions) (int, []byte) {
+	var body []byte
+	if options.format == formatJSON || options.format == formatGeoJSON {
+		if options.format == formatGeoJSON {
+			body, _ = json.Marshal(toGeoJSONFeature(location, options))
+		} else {
+			body, _ = json.Marshal(toMetaOutput(location, meta, options))
+		}
+		if options.callback != "" {
+			//browsers don't run scripts returned with an error status, so JSONP failures are only reported in the body
+			statusCode = http.StatusOK
+			body = wrapCallback(options.callback, body)
+			w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		}
+	} else if options.format == formatNDJSON || options.format == formatMsgPack || options.header {
+		body = encodeOutputs([]ip_api.Location{*location}, options, false)
+	} else {
+		body = encodeLocation(location, options)
+	}
+	return statusCode, body
+}
+
+/*
+writeLocations - writes a batch of locations to the response as a json array, a GeoJSON FeatureCollection, or one of the negotiated formats
+statusCode - HTTP status code
+locations - ip_api locations, already projected to the requested fields
+metas - locationMeta of each location added to json output, nil for none
+options - outputOptions of the request
+ */
+func writeLocations(w http.ResponseWriter, statusCode int, locations []ip_api.Location, metas []*locationMeta, options outputOptions) {
+	var body []byte
+	setContentType(w, options)
+	if options.format == formatGeoJSON {
+		body, _ = json.Marshal(toGeoJSONFeatureCollection(locations, options))
+	} else if options.format != formatJSON {
+		body = encodeOutputs(locations, options, true)
+	} else {
+		outputs := make([]interface{}, len(locations))
+		for i := range locations {
+			var meta *locationMeta
+			if i < len(metas) {
+				meta = metas[i]
+			}
+			outputs[i] = toMetaOutput(&locations[i], meta, options)
+		}
+		body, _ = json.Marshal(outputs)
+	}
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+/*
+encodeLocation - encodes a location in one of IP-API's non json formats
+location - ip_api location
+options - outputOptions of the request, format is one of xml, csv, line, php
+
+returns
+encoded location
+ */
+func encodeLocation(location *ip_api.Location, options outputOptions) []byte {
+	var formatFields []formatField
+	if options.profile != nil && location.Status != "fail" {
+		formatFields = profileFormatFields(location, options.profile)
+	} else {
+		formatFields = locationFormatFields(location, options.fields, options.ecs != "")
+	}
+
+	var buffer bytes.Buffer
+	switch options.format {
+	case formatXML:
+		buffer.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<query>\n")
+		for _, field := range formatFields {
+			//CDATA can't contain its own terminator, so split it across two sections
+			value := strings.Replace(field.value, "]]>", "]]]]><![CDATA[>", -1)
+			buffer.WriteString("\t<" + field.name + "><![CDATA[" + value + "]]></" + field.name + ">\n")
+		}
+		buffer.WriteString("</query>\n")
+	case formatCSV:
+		values := make([]string, len(formatFields))
+		for i, field := range formatFields {
+			values[i] = field.value
+		}
+		csvWriter := csv.NewWriter(&buffer)
+		_ = csvWriter.Write(values)
+		csvWriter.Flush()
+	case formatLine:
+		for _, field := range formatFields {
+			buffer.WriteString(field.value + "\n")
+		}
+	case formatPHP:
+		buffer.WriteString("a:" + strconv.Itoa(len(formatFields)) + ":{")
+		for _, field := range formatFields {
+			buffer.WriteString(phpSerialize("s", field.name))
+			buffer.WriteString(phpSerialize(field.kind, field.value))
+		}
+		buffer.WriteString("}")
+	}
+	return buffer.Bytes()
+}
+
+/*
+validCallback - checks that a JSONP callback name is safe to echo back
+callback - callback name passed by the client
+ */
+func validCallback(callback string) bool {
+	return len(callback) <= maxCallbackLength && callbackRegexp.MatchString(callback)
+}
+
+/*
+wrapCallback - wraps a json body in a JSONP callback like IP-API does: callback(body);
+the leading empty comment stops the response from being sniffed as anything other than javascript
+callback - validated callback name
+body - json body
+ */
+func wrapCallback(callback string, body []byte) []byte {
+	wrapped := make([]byte, 0, len(callback)+len(body)+7)
+	wrapped = append(wrapped, "/**/"+callback+"("...)
+	wrapped = append(wrapped, body...)
+	wrapped = append(wrapped, ");"...)
+	return wrapped
+}
+
+/*
+phpSerialize - serializes a single value the way PHP's serialize() does
+kind - s (string), d (double), i (integer) or b (boolean)
+value - string value
+ */
+func phpSerialize(kind string, value string) string {
+	switch kind {
+	case "d", "i":
+		return kind + ":" + value + ";"
+	case "b":
+		if value == "true" {
+			return "b:1;"
+		}
+		return "b:0;"
+	default:
+		return "s:" + strconv.Itoa(len(value)) + ":\"" + value + "\";"
+	}
+}
+
+/*
+locationFormatFields - gets the requested fields of a location in IP-API's field order
+location - ip_api location
+fields - requested Fields, defaults to cache.DefaultFields
+ecs - use ECS field names
+
+returns
+slice of formatField
+ */
+func locationFormatFields(location *ip_api.Location, fields cache.Fields, ecs bool) []formatField {
+	//failed queries only ever return status, message and query
+	if location.Status == "fail" {
+		fields = failedFields
+	} else if fields == 0 {
+		fields = cache.DefaultFields
+	}
+
+	var formatFields []formatField
+	for _, name := range fields.Names() {
+		value, kind := cache.LocationFieldValue(location, name)
+		if ecs {
+			name = ecsFieldNames[name]
+		}
+		formatFields = append(formatFields, formatField{name: name, value: value, kind: kind})
+	}
+	return formatFields
+}
+
+/*
+profileFormatFields - gets the values of a location's output profile, names stay dotted
+location - ip_api location
+profile - output profile
+
+returns
+slice of formatField
+ */
+func profileFormatFields(location *ip_api.Location, profile *outputProfile.Profile) []formatField {
+	var formatFields []formatField
+	for _, value := range profile.Values(location) {
+		formatField := formatField{name: value.Name, kind: "s"}
+		switch typedValue := value.Value.(type) {
+		case string:
+			formatField.value = typedValue
+		case float32:
+			formatField.value, formatField.kind = strconv.FormatFloat(float64(typedValue), 'f', -1, 32), "d"
+		case bool:
+			formatField.value, formatField.kind = strconv.FormatBool(typedValue), "b"
+		case int:
+			formatField.value, formatField.kind = strconv.Itoa(typedValue), "i"
+		}
+		formatFields = append(formatFields, formatField)
+	}
+	return formatFields
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidCallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		callback string
+		expected bool
+	}{
+		{"name", "callback", true},
+		{"jquery style", "jQuery3600_1665400000000", true},
+		{"dollar and underscore", "$_cb", true},
+		{"namespaced", "app.handlers.geo", true},
+		{"longest allowed", strings.Repeat("a", maxCallbackLength), true},
+		{"too long", strings.Repeat("a", maxCallbackLength+1), false},
+		{"empty", "", false},
+		{"leading digit", "1callback", false},
+		{"empty namespace part", "app..geo", false},
+		{"trailing dot", "app.", false},
+		{"call", "alert(1)", false},
+		{"statement", "cb;alert", false},
+		{"script tag", "</script>", false},
+		{"space", "call back", false},
+		{"brackets", "app[0]", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := validCallback(test.callback); valid != test.expected {
+				t.Errorf("got %t, expected %t", valid, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//forwardAuthPath - path of the forward auth endpoint
+const forwardAuthPath = "/auth"
+
+//forwardAuthFields - fields looked up to evaluate access rules and fill the X-Geo headers
+const forwardAuthFields = cache.FieldStatus | cache.FieldMessage | cache.FieldContinentCode | cache.FieldCountryCode | cache.FieldAS |
+	cache.FieldMobile | cache.FieldProxy | cache.FieldHosting | cache.FieldQuery
+
+//Forward auth settings, swapped when the config file changes so rules reload without a restart
+var (
+	forwardAuthSettings      config.ForwardAuth
+	forwardAuthSettingsMutex sync.RWMutex
+)
+
+//currentForwardAuth - gets the current forward auth settings
+func currentForwardAuth() config.ForwardAuth {
+	forwardAuthSettingsMutex.RLock()
+	defer forwardAuthSettingsMutex.RUnlock()
+	return forwardAuthSettings
+}
+
+//setForwardAuth - replaces the forward auth settings
+func setForwardAuth(settings config.ForwardAuth) {
+	forwardAuthSettingsMutex.Lock()
+	defer forwardAuthSettingsMutex.Unlock()
+	forwardAuthSettings = settings
+}
+
+/*
+startForwardAuth - loads the forward auth settings, and reloads them whenever the config file changes
+the config file is checked every reload interval, a config that fails to validate is logged and the previous rules are kept
+configLocation - config file location, as passed with --config
+ */
+func startForwardAuth(configLocation string) {
+	setForwardAuth(LoadedConfig.ForwardAuth)
+
+	configPath, err := config.ResolvePath(configLocation)
+	if err != nil {
+		log.Println("error: forward auth rules won't reload: " + err.Error())
+		return
+	}
+	var modTime time.Time
+	if info, err := os.Stat(configPath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	go func() {
+		for range time.Tick(*LoadedConfig.ForwardAuth.ReloadIntervalDuration) {
+			info, err := os.Stat(configPath)
+			//a missing file is likely being replaced, keep the current rules
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			reloadedConfig, err := config.ReadConfig(configLocation)
+			if err != nil {
+				log.Println("error: reloading forward auth rules, keeping the current rules: " + err.Error())
+				continue
+			}
+			if !reloadedConfig.ForwardAuth.Enabled {
+				log.Println("error: reloading forward auth rules, forward auth can't be disabled without a restart, keeping the current rules")
+				continue
+			}
+			setForwardAuth(reloadedConfig.ForwardAuth)
+			log.Println("Reloaded " + strconv.Itoa(len(reloadedConfig.ForwardAuth.Rules)) + " forward auth rules")
+		}
+	}()
+}
+
+/*
+forwardAuth - handles forward auth requests from reverse proxies (nginx auth_request, Traefik ForwardAuth, Envoy ext_authz)
+the client ip is looked up and evaluated against the access rules, the response is 200 if the client is allowed and 403 if it is denied
+the client's location is returned in X-Geo headers, so the reverse proxy can forward them upstream
+ */
+func forwardAuth(w http.ResponseWriter, r *http.Request) {
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementSingleRequestsProcessed()
+
+	settings := currentForwardAuth()
+
+	ip := forwardAuthIP(r, settings.IPHeaders)
+	if ip == "" {
+		log.Println("Failed forward auth request: client ip could not be determined")
+		writeForwardAuth(w, settings.ErrorAction)
+		return
+	}
+	w.Header().Set("X-Geo-IP", ip)
+
+	promMetrics.IncrementSingleQueriesProcessed()
+	promMetrics.IncrementQueriesProcessed()
+
+	//Get location from cache, or from IP-API if it isn't cached
+	location, _, err := lookupLocation(r.Context(), ip, "", forwardAuthFields, LoadedConfig.APIKey)
+	if err != nil {
+		log.Println("Failed forward auth request: " + err.Error())
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		writeForwardAuth(w, settings.ErrorAction)
+		return
+	}
+
+	//failed lookups (ex: private ranges) have no location, so only rules without geo conditions can match them
+	if location.Status == "fail" {
+		promMetrics.IncrementFailedQueries()
+		promMetrics.IncrementFailedSingleQueries()
+	} else {
+		promMetrics.IncrementSuccessfulQueries()
+		promMetrics.IncrementSuccessfulSingeQueries()
+		setGeoHeaders(w.Header(), location)
+	}
+
+	action := settings.DefaultAction
+	if rule := matchAccessRule(settings.Rules, location); rule != nil {
+		action = rule.Action
+		w.Header().Set("X-Geo-Rule", rule.Name)
+	}
+
+	if LoadedConfig.Debugging {
+		log.Println("Forward auth " + ip + ": " + action)
+	}
+	writeForwardAuth(w, action)
+}
+
+//writeForwardAuth - writes the response of an action, 200 for allow and 403 for deny
+func writeForwardAuth(w http.ResponseWriter, action string) {
+	code := http.StatusOK
+	if action != config.ActionAllow {
+		code = http.StatusForbidden
+	}
+	promMetrics.IncrementHandlerRequests(strconv.Itoa(code))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+}
+
+/*
+forwardAuthIP - gets the client ip a forward auth request is for
+ipHeaders are only read from trusted proxies, otherwise any client could pick the address it is evaluated as
+they are checked in order, for lists like X-Forwarded-For the closest address that isn't a trusted proxy is used
+without ipHeaders, or if none of them is set, the client ip is determined like for the other endpoints
+r - http request
+ipHeaders - configured headers holding the client ip
+ */
+func forwardAuthIP(r *http.Request, ipHeaders []string) string {
+	if remoteIP := parseHostIP(r.RemoteAddr); remoteIP == nil || !trustedProxy(remoteIP) {
+		return clientIP(r)
+	}
+
+	for _, header := range ipHeaders {
+		values := r.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+
+		var chain []string
+		if strings.EqualFold(header, "Forwarded") {
+			chain = parseForwarded(values)
+		} else {
+			for _, value := range values {
+				chain = append(chain, strings.Split(value, ",")...)
+			}
+		}
+
+		var clientIP string
+		for i := len(chain) - 1; i >= 0; i-- {
+			hopIP := parseHostIP(strings.TrimSpace(chain[i]))
+			if hopIP == nil {
+				break
+			}
+			clientIP = hopIP.String()
+			if !trustedProxy(hopIP) {
+				break
+			}
+		}
+		if clientIP != "" {
+			return clientIP
+		}
+	}
+	return clientIP(r)
+}
+
+/*
+matchAccessRule - finds the first access rule matching a location
+a rule matches if all of its conditions match, and a condition matches if the location has any of its values
+location - ip_api location
+ */
+func matchAccessRule(rules []config.AccessRule, location *ip_api.Location) *config.AccessRule {
+	asn, _ := utils.ParseAS(location.AS)
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.Countries) > 0 && !containsString(rule.Countries, location.CountryCode) {
+			continue
+		}
+		if len(rule.Continents) > 0 && !containsString(rule.Continents, location.ContinentCode) {
+			continue
+		}
+		if len(rule.ASNs) > 0 && !containsInt(rule.ASNs, asn) {
+			continue
+		}
+		if !matchFlag(rule.Proxy, location.Proxy) || !matchFlag(rule.Hosting, location.Hosting) || !matchFlag(rule.Mobile, location.Mobile) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+//matchFlag - checks a proxy, hosting or mobile condition, a location without the flag only matches a false condition
+func matchFlag(condition *bool, flag *bool) bool {
+	if condition == nil {
+		return true
+	}
+	return *condition == (flag != nil && *flag)
+}
+
+//containsString - checks if a slice contains a value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+//containsInt - checks if a slice contains a value
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+setGeoHeaders - sets the X-Geo headers of a location, headers of unknown values are left out
+header - response headers
+location - ip_api location
+ */
+func setGeoHeaders(header http.Header, location *ip_api.Location) {
+	setHeader := func(name string, value string) {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	setFlag := func(name string, flag *bool) {
+		if flag != nil {
+			header.Set(name, strconv.FormatBool(*flag))
+		}
+	}
+
+	setHeader("X-Geo-Country", location.CountryCode)
+	setHeader("X-Geo-Continent", location.ContinentCode)
+	if asn, asName := utils.ParseAS(location.AS); asn != 0 {
+		header.Set("X-Geo-ASN", strconv.Itoa(asn))
+		setHeader("X-Geo-AS-Org", asName)
+	}
+	setFlag("X-Geo-Proxy", location.Proxy)
+	setFlag("X-Geo-Hosting", location.Hosting)
+	setFlag("X-Geo-Mobile", location.Mobile)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/config"
+	"net"
+	"net/http/httptest"
+	"testing"
+)
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func TestMatchAccessRule(t *testing.T) {
+	rules := []config.AccessRule{
+		{Name: "deny-proxies", Action: config.ActionDeny, Proxy: boolPointer(true)},
+		{Name: "allow-google", Action: config.ActionAllow, ASNs: []int{15169, 36040}},
+		{Name: "deny-us-hosting", Action: config.ActionDeny, Countries: []string{"US"}, Hosting: boolPointer(true)},
+		{Name: "allow-eu", Action: config.ActionAllow, Continents: []string{"EU"}},
+		{Name: "deny-non-mobile-us", Action: config.ActionDeny, Countries: []string{"US", "CA"}, Mobile: boolPointer(false)},
+	}
+
+	tests := []struct {
+		name     string
+		location ip_api.Location
+		expected string
+	}{
+		{"first match wins over a later match", ip_api.Location{CountryCode: "DE", ContinentCode: "EU", Proxy: boolPointer(true)}, "deny-proxies"},
+		{"asn in list", ip_api.Location{CountryCode: "US", AS: "AS15169 Google LLC", Hosting: boolPointer(true)}, "allow-google"},
+		{"second asn in list", ip_api.Location{CountryCode: "US", AS: "AS36040 YouTube", Hosting: boolPointer(true)}, "allow-google"},
+		{"asn not in list", ip_api.Location{CountryCode: "US", AS: "AS13335 Cloudflare, Inc.", Hosting: boolPointer(true)}, "deny-us-hosting"},
+		{"all conditions must match", ip_api.Location{CountryCode: "US", Hosting: boolPointer(false), Mobile: boolPointer(true)}, ""},
+		{"continent", ip_api.Location{CountryCode: "FR", ContinentCode: "EU"}, "allow-eu"},
+		{"unset flag matches false condition", ip_api.Location{CountryCode: "CA"}, "deny-non-mobile-us"},
+		{"unset flag doesn't match true condition", ip_api.Location{CountryCode: "US", AS: "AS13335 Cloudflare, Inc.", Mobile: boolPointer(false)}, "deny-non-mobile-us"},
+		{"no match", ip_api.Location{CountryCode: "JP", ContinentCode: "AS", Mobile: boolPointer(true)}, ""},
+		{"failed lookup only matches rules without geo conditions", ip_api.Location{Status: "fail", Message: "private range"}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := test.location
+			rule := matchAccessRule(rules, &location)
+			name := ""
+			if rule != nil {
+				name = rule.Name
+			}
+			if name != test.expected {
+				t.Errorf("got rule %q, expected %q", name, test.expected)
+			}
+		})
+	}
+}
+
+func TestMatchFlag(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition *bool
+		flag      *bool
+		expected  bool
+	}{
+		{"no condition, unset flag", nil, nil, true},
+		{"no condition, false flag", nil, boolPointer(false), true},
+		{"no condition, true flag", nil, boolPointer(true), true},
+		{"true condition, unset flag", boolPointer(true), nil, false},
+		{"true condition, false flag", boolPointer(true), boolPointer(false), false},
+		{"true condition, true flag", boolPointer(true), boolPointer(true), true},
+		{"false condition, unset flag", boolPointer(false), nil, true},
+		{"false condition, false flag", boolPointer(false), boolPointer(false), true},
+		{"false condition, true flag", boolPointer(false), boolPointer(true), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := matchFlag(test.condition, test.flag); matched != test.expected {
+				t.Errorf("got %t, expected %t", matched, test.expected)
+			}
+		})
+	}
+}
+
+func TestForwardAuthIP(t *testing.T) {
+	_, trustedNetwork, _ := net.ParseCIDR("10.0.0.0/8")
+	LoadedConfig.TrustedNetworks = []*net.IPNet{trustedNetwork}
+	defer func() {
+		LoadedConfig.TrustedNetworks = nil
+	}()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		ipHeaders  []string
+		expected   string
+	}{
+		{"untrusted peer ignores ip headers", "203.0.113.7:1234", map[string]string{"X-Client-IP": "8.8.8.8"}, []string{"X-Client-IP"}, "203.0.113.7"},
+		{"untrusted peer ignores forwarded for", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "8.8.8.8"}, []string{"X-Forwarded-For"}, "203.0.113.7"},
+		{"trusted peer uses ip header", "10.0.0.1:1234", map[string]string{"X-Client-IP": "8.8.8.8"}, []string{"X-Client-IP"}, "8.8.8.8"},
+		{"trusted peer walks chain past trusted hops", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1, 8.8.8.8, 10.0.0.2"}, []string{"X-Forwarded-For"}, "8.8.8.8"},
+		{"headers checked in order", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Client-IP": "8.8.8.8"}, []string{"X-Client-IP", "X-Forwarded-For"}, "8.8.8.8"},
+		{"falls back to the next header", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"}, []string{"X-Client-IP", "X-Forwarded-For"}, "1.1.1.1"},
+		{"forwarded header", "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:80";proto=https`}, []string{"Forwarded"}, "2001:db8::1"},
+		{"no ip headers set", "10.0.0.1:1234", map[string]string{}, []string{"X-Client-IP"}, "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth", nil)
+			r.RemoteAddr = test.remoteAddr
+			for header, value := range test.headers {
+				r.Header.Set(header, value)
+			}
+			if ip := forwardAuthIP(r, test.ipHeaders); ip != test.expected {
+				t.Errorf("got %s, expected %s", ip, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//geoIP2Path - path prefix of the GeoIP2 Precision web services, ex: /geoip/v2.1/city/8.8.8.8
+const geoIP2Path = "/geoip/v2.1/"
+
+//GeoIP2 web services, insights includes everything city does, city includes everything country does
+const (
+	geoIP2Country  = "country"
+	geoIP2City     = "city"
+	geoIP2Insights = "insights"
+)
+
+//GeoIP2Response - GeoIP2 Precision web service response, see https://dev.maxmind.com/geoip/docs/web-services/responses
+type GeoIP2Response struct {
+	City         *GeoIP2City         `json:"city,omitempty"`
+	Continent    *GeoIP2Continent    `json:"continent,omitempty"`
+	Country      *GeoIP2Country      `json:"country,omitempty"`
+	Location     *GeoIP2Location     `json:"location,omitempty"`
+	Postal       *GeoIP2Postal       `json:"postal,omitempty"`
+	Subdivisions []GeoIP2Subdivision `json:"subdivisions,omitempty"`
+	Traits       GeoIP2Traits        `json:"traits"`
+}
+
+type GeoIP2City struct {
+	Names map[string]string `json:"names,omitempty"`
+}
+
+type GeoIP2Continent struct {
+	Code  string            `json:"code,omitempty"`
+	Names map[string]string `json:"names,omitempty"`
+}
+
+type GeoIP2Country struct {
+	ISOCode string            `json:"iso_code,omitempty"`
+	Names   map[string]string `json:"names,omitempty"`
+}
+
+type GeoIP2Location struct {
+	Latitude  *float32 `json:"latitude,omitempty"`
+	Longitude *float32 `json:"longitude,omitempty"`
+	TimeZone  string   `json:"time_zone,omitempty"`
+}
+
+type GeoIP2Postal struct {
+	Code string `json:"code,omitempty"`
+}
+
+type GeoIP2Subdivision struct {
+	ISOCode string            `json:"iso_code,omitempty"`
+	Names   map[string]string `json:"names,omitempty"`
+}
+
+type GeoIP2Traits struct {
+	IPAddress                    string `json:"ip_address"`
+	AutonomousSystemNumber       int    `json:"autonomous_system_number,omitempty"`
+	AutonomousSystemOrganization string `json:"autonomous_system_organization,omitempty"`
+	Domain                       string `json:"domain,omitempty"`
+	ISP                          string `json:"isp,omitempty"`
+	Organization                 string `json:"organization,omitempty"`
+	UserType                     string `json:"user_type,omitempty"`
+	IsAnonymous                  bool   `json:"is_anonymous,omitempty"`
+	IsHostingProvider            bool   `json:"is_hosting_provider,omitempty"`
+}
+
+//GeoIP2Error - GeoIP2 web service error response
+type GeoIP2Error struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+/*
+geoIP2 - handles GeoIP2 Precision web service requests (/geoip/v2.1/{country,city,insights}/{ip address,me})
+when clients are configured, requests must authenticate with HTTP basic auth as one of them (account id = client id, license key = client key)
+ */
+func geoIP2(w http.ResponseWriter, r *http.Request) {
+	//increment requests processed
+	promMetrics.IncrementRequestsProcessed()
+
+	if r.Method != "GET" {
+		writeGeoIP2Error(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "GeoIP2 web services only support GET requests.")
+		return
+	}
+
+	//authenticate client if any are configured
+	if len(LoadedConfig.Clients) > 0 {
+		client, hasCredentials := authenticateClient(r)
+		if !hasCredentials {
+			writeGeoIP2Error(w, http.StatusUnauthorized, "ACCOUNT_ID_REQUIRED", "You have not supplied an account ID and license key.")
+			return
+		} else if client == nil {
+			writeGeoIP2Error(w, http.StatusUnauthorized, "AUTHORIZATION_INVALID", "You have supplied an invalid account ID and/or license key.")
+			return
+		}
+	}
+
+	//rejected requests aren't single requests or queries
+	promMetrics.IncrementSingleRequestsProcessed()
+	promMetrics.IncrementSingleQueriesProcessed()
+	promMetrics.IncrementQueriesProcessed()
+
+	//get service and ip address from path
+	pathParts := strings.Split(strings.TrimPrefix(r.URL.Path, geoIP2Path), "/")
+	if len(pathParts) != 2 || (pathParts[0] != geoIP2Country && pathParts[0] != geoIP2City && pathParts[0] != geoIP2Insights) {
+		writeGeoIP2Error(w, http.StatusNotFound, "PATH_NOT_FOUND", "The path you requested is not a GeoIP2 web service.")
+		return
+	}
+	service := pathParts[0]
+
+	ip := pathParts[1]
+	if ip == "me" {
+		ip = clientIP(r)
+	}
+	if ip == "" {
+		writeGeoIP2Error(w, http.StatusBadRequest, "IP_ADDRESS_REQUIRED", "You have not supplied an IP address.")
+		return
+	}
+	if net.ParseIP(ip) == nil {
+		writeGeoIP2Error(w, http.StatusBadRequest, "IP_ADDRESS_INVALID", "The value "+ip+" is not a valid IP address.")
+		return
+	}
+
+	//GeoIP2 locale codes are the same as IP-API's lang values
+	var validatedLang string
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		var err error
+		validatedLang, err = ip_api.ValidateLang(lang)
+		if err != nil {
+			writeGeoIP2Error(w, http.StatusBadRequest, "LANGUAGE_INVALID", err.Error())
+			return
+		}
+	}
+
+	location, _, err := lookupLocation(r.Context(), ip, validatedLang, cache.AllFields, LoadedConfig.APIKey)
+	if err != nil {
+		log.Println("Failed GeoIP2 request: " + err.Error())
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		writeGeoIP2Error(w, http.StatusServiceUnavailable, "SERVER_ERROR", err.Error())
+		return
+	}
+
+	if location.Status != "success" {
+		promMetrics.IncrementFailedQueries()
+		promMetrics.IncrementFailedSingleQueries()
+		switch location.Message {
+		case "private range", "reserved range":
+			writeGeoIP2Error(w, http.StatusBadRequest, "IP_ADDRESS_RESERVED", "The IP address "+ip+" belongs to a reserved or private range.")
+		case "invalid query":
+			writeGeoIP2Error(w, http.StatusBadRequest, "IP_ADDRESS_INVALID", "The value "+ip+" is not a valid IP address.")
+		default:
+			writeGeoIP2Error(w, http.StatusNotFound, "IP_ADDRESS_NOT_FOUND", "The address "+ip+" is not in our database.")
+		}
+		return
+	}
+
+	promMetrics.IncrementSuccessfulQueries()
+	promMetrics.IncrementSuccessfulSingeQueries()
+	promMetrics.IncrementHandlerRequests("200")
+
+	if validatedLang == "" {
+		validatedLang = "en"
+	}
+	body, _ := json.Marshal(toGeoIP2Response(location, ip, service, validatedLang))
+	w.Header().Set("Content-Type", "application/vnd.maxmind.com-"+service+"+json; charset=UTF-8; version=2.1")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+/*
+toGeoIP2Response - converts an ip_api Location into a GeoIP2 web service response
+location - ip_api location with all fields
+ip - queried ip address
+service - country, city or insights
+lang - locale of the names
+ */
+func toGeoIP2Response(location *ip_api.Location, ip string, service string, lang string) GeoIP2Response {
+	response := GeoIP2Response{
+		Traits: GeoIP2Traits{IPAddress: ip},
+	}
+
+	if location.ContinentCode != "" || location.Continent != "" {
+		response.Continent = &GeoIP2Continent{Code: location.ContinentCode, Names: geoIP2Names(lang, location.Continent)}
+	}
+	if location.CountryCode != "" || location.Country != "" {
+		response.Country = &GeoIP2Country{ISOCode: location.CountryCode, Names: geoIP2Names(lang, location.Country)}
+	}
+
+	if service == geoIP2Country {
+		return response
+	}
+
+	if location.City != "" {
+		response.City = &GeoIP2City{Names: geoIP2Names(lang, location.City)}
+	}
+	if location.Lat != nil || location.Lon != nil || location.Timezone != "" {
+		response.Location = &GeoIP2Location{Latitude: location.Lat, Longitude: location.Lon, TimeZone: location.Timezone}
+	}
+	if location.ZIP != "" {
+		response.Postal = &GeoIP2Postal{Code: location.ZIP}
+	}
+	if location.Region != "" || location.RegionName != "" {
+		response.Subdivisions = []GeoIP2Subdivision{{ISOCode: location.Region, Names: geoIP2Names(lang, location.RegionName)}}
+	}
+
+	response.Traits.AutonomousSystemNumber, response.Traits.AutonomousSystemOrganization = utils.ParseAS(location.AS)
+	response.Traits.ISP = location.ISP
+	response.Traits.Organization = location.Org
+	response.Traits.Domain = location.Reverse
+
+	if service == geoIP2City {
+		return response
+	}
+
+	if location.Proxy != nil {
+		response.Traits.IsAnonymous = *location.Proxy
+	}
+	if location.Hosting != nil && *location.Hosting {
+		response.Traits.IsHostingProvider = true
+		response.Traits.UserType = "hosting"
+	} else if location.Mobile != nil && *location.Mobile {
+		response.Traits.UserType = "cellular"
+	}
+
+	return response
+}
+
+/*
+geoIP2Names - builds a GeoIP2 names object
+lang - locale of the name
+name - name, nil names are returned for an empty name
+ */
+func geoIP2Names(lang string, name string) map[string]string {
+	if name == "" {
+		return nil
+	}
+	return map[string]string{lang: name}
+}
+
+/*
+writeGeoIP2Error - writes a GeoIP2 web service error response
+statusCode - HTTP status code
+code - GeoIP2 error code (ex: IP_ADDRESS_INVALID)
+message - error message
+ */
+func writeGeoIP2Error(w http.ResponseWriter, statusCode int, code string, message string) {
+	promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+	body, _ := json.Marshal(GeoIP2Error{Code: code, Error: message})
+	w.Header().Set("Content-Type", "application/vnd.maxmind.com-error+json; charset=UTF-8; version=2.0")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
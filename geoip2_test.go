@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestToGeoIP2Response(t *testing.T) {
+	lat, lon := float32(37.386), float32(-122.0838)
+	yes, no := true, false
+	location := &ip_api.Location{
+		Status:        "success",
+		Continent:     "North America",
+		ContinentCode: "NA",
+		Country:       "United States",
+		CountryCode:   "US",
+		Region:        "CA",
+		RegionName:    "California",
+		City:          "Mountain View",
+		ZIP:           "94043",
+		Lat:           &lat,
+		Lon:           &lon,
+		Timezone:      "America/Los_Angeles",
+		ISP:           "Google LLC",
+		Org:           "Google Public DNS",
+		AS:            "AS15169 Google LLC",
+		Reverse:       "dns.google",
+		Proxy:         &yes,
+		Hosting:       &yes,
+		Mobile:        &no,
+		Query:         "8.8.8.8",
+	}
+
+	continent := &GeoIP2Continent{Code: "NA", Names: map[string]string{"de": "North America"}}
+	country := &GeoIP2Country{ISOCode: "US", Names: map[string]string{"de": "United States"}}
+	cityTraits := GeoIP2Traits{
+		IPAddress:                    "8.8.8.8",
+		AutonomousSystemNumber:       15169,
+		AutonomousSystemOrganization: "Google LLC",
+		Domain:                       "dns.google",
+		ISP:                          "Google LLC",
+		Organization:                 "Google Public DNS",
+	}
+	insightsTraits := cityTraits
+	insightsTraits.IsAnonymous = true
+	insightsTraits.IsHostingProvider = true
+	insightsTraits.UserType = "hosting"
+	city := GeoIP2Response{
+		City:         &GeoIP2City{Names: map[string]string{"de": "Mountain View"}},
+		Continent:    continent,
+		Country:      country,
+		Location:     &GeoIP2Location{Latitude: &lat, Longitude: &lon, TimeZone: "America/Los_Angeles"},
+		Postal:       &GeoIP2Postal{Code: "94043"},
+		Subdivisions: []GeoIP2Subdivision{{ISOCode: "CA", Names: map[string]string{"de": "California"}}},
+		Traits:       cityTraits,
+	}
+	insights := city
+	insights.Traits = insightsTraits
+
+	mobile := *location
+	mobile.Hosting = &no
+	mobile.Mobile = &yes
+	mobileInsights := insights
+	mobileInsights.Traits.IsHostingProvider = false
+	mobileInsights.Traits.UserType = "cellular"
+
+	tests := []struct {
+		name     string
+		location *ip_api.Location
+		service  string
+		expected GeoIP2Response
+	}{
+		{"country", location, geoIP2Country, GeoIP2Response{Continent: continent, Country: country, Traits: GeoIP2Traits{IPAddress: "8.8.8.8"}}},
+		{"city", location, geoIP2City, city},
+		{"insights", location, geoIP2Insights, insights},
+		{"insights cellular", &mobile, geoIP2Insights, mobileInsights},
+		{"no fields", &ip_api.Location{Status: "success"}, geoIP2Insights, GeoIP2Response{Traits: GeoIP2Traits{IPAddress: "8.8.8.8"}}},
+		{"AS without organization", &ip_api.Location{Status: "success", AS: "AS15169"}, geoIP2City, GeoIP2Response{Traits: GeoIP2Traits{IPAddress: "8.8.8.8", AutonomousSystemNumber: 15169}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := toGeoIP2Response(test.location, "8.8.8.8", test.service, "de")
+			if !reflect.DeepEqual(response, test.expected) {
+				got, _ := json.Marshal(response)
+				expected, _ := json.Marshal(test.expected)
+				t.Errorf("got %s, expected %s", got, expected)
+			}
+		})
+	}
+}
+
+//counterValue - gets the value of a prometheus counter of the default registry
+func counterValue(t *testing.T, name string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("got no counter %s", name)
+	return 0
+}
+
+func TestGeoIP2Metrics(t *testing.T) {
+	LoadedConfig.Clients = []config.Client{{ID: "42", Key: "license"}}
+	defer func() {
+		LoadedConfig.Clients = nil
+	}()
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Country: "United States", CountryCode: "US", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("8.8.8.8")
+
+	tests := []struct {
+		name       string
+		method     string
+		clientID   string
+		key        string
+		statusCode int
+		counted    bool
+	}{
+		{"wrong method", "POST", "42", "license", http.StatusMethodNotAllowed, false},
+		{"no credentials", "GET", "", "", http.StatusUnauthorized, false},
+		{"invalid credentials", "GET", "42", "other", http.StatusUnauthorized, false},
+		{"authenticated", "GET", "42", "license", http.StatusOK, true},
+	}
+
+	metrics := []string{"ip_api_proxy_single_requests_processed_total", "ip_api_proxy_single_queries_processed_total", "ip_api_proxy_queries_total"}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := map[string]float64{}
+			for _, metric := range metrics {
+				before[metric] = counterValue(t, metric)
+			}
+
+			r := httptest.NewRequest(test.method, geoIP2Path+geoIP2Country+"/8.8.8.8", nil)
+			if test.clientID != "" {
+				r.SetBasicAuth(test.clientID, test.key)
+			}
+			w := httptest.NewRecorder()
+			geoIP2(w, r)
+			if w.Code != test.statusCode {
+				t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+
+			expected := 0.0
+			if test.counted {
+				expected = 1
+			}
+			for _, metric := range metrics {
+				if counted := counterValue(t, metric) - before[metric]; counted != expected {
+					t.Errorf("got %s increased by %v, expected %v", metric, counted, expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"strconv"
+)
+
+//formatGeoJSON - GeoJSON output of the json and batch endpoints (format=geojson or Accept: application/geo+json)
+const formatGeoJSON = "geojson"
+
+const geoJSONContentType = "application/geo+json"
+
+//GeoJSONFeature - GeoJSON Feature of a single location, see RFC 7946
+type GeoJSONFeature struct {
+	Type       string           `json:"type"`
+	ID         string           `json:"id,omitempty"`
+	Geometry   *GeoJSONGeometry `json:"geometry"`
+	Properties interface{}      `json:"properties"`
+}
+
+//GeoJSONGeometry - GeoJSON Point geometry, coordinates are [lon, lat]
+type GeoJSONGeometry struct {
+	Type        string        `json:"type"`
+	Coordinates []json.Number `json:"coordinates"`
+}
+
+//GeoJSONFeatureCollection - GeoJSON FeatureCollection of a batch, failed queries are listed in the failed foreign member
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+	Failed   []interface{}    `json:"failed,omitempty"`
+}
+
+/*
+toGeoJSONFeature - converts a location into a GeoJSON Feature
+location - ip_api location, lat and lon become the geometry, the other fields the properties
+options - outputOptions of the request, the properties follow the requested ECS output
+ */
+func toGeoJSONFeature(location *ip_api.Location, options outputOptions) GeoJSONFeature {
+	feature := GeoJSONFeature{
+		Type: "Feature",
+		ID:   location.Query,
+	}
+
+	//locations without lat and lon (ex: not in the requested fields) have no geometry
+	if location.Lat != nil && location.Lon != nil {
+		feature.Geometry = &GeoJSONGeometry{
+			Type: "Point",
+			Coordinates: []json.Number{
+				json.Number(strconv.FormatFloat(float64(*location.Lon), 'f', -1, 32)),
+				json.Number(strconv.FormatFloat(float64(*location.Lat), 'f', -1, 32)),
+			},
+		}
+	}
+
+	properties := *location
+	properties.Lat = nil
+	properties.Lon = nil
+	feature.Properties = toOutput(&properties, options)
+
+	return feature
+}
+
+/*
+toGeoJSONFeatureCollection - converts a batch of locations into a GeoJSON FeatureCollection
+locations - ip_api locations, failed locations are listed in the failed foreign member instead of as features
+options - outputOptions of the request
+ */
+func toGeoJSONFeatureCollection(locations []ip_api.Location, options outputOptions) GeoJSONFeatureCollection {
+	featureCollection := GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+
+	for i := range locations {
+		if locations[i].Status == "fail" {
+			featureCollection.Failed = append(featureCollection.Failed, toOutput(&locations[i], options))
+			continue
+		}
+		featureCollection.Features = append(featureCollection.Features, toGeoJSONFeature(&locations[i], options))
+	}
+
+	return featureCollection
+}
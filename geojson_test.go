@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"testing"
+)
+
+func TestToGeoJSONFeatureCollection(t *testing.T) {
+	var lat float32 = 37.751
+	var lon float32 = -97.822
+	var zero float32 = 0
+
+	tests := []struct {
+		name      string
+		locations []ip_api.Location
+		expected  string
+	}{
+		{
+			"point",
+			[]ip_api.Location{{Status: "success", Country: "United States", Lat: &lat, Lon: &lon, Query: "8.8.8.8"}},
+			`{"type":"FeatureCollection","features":[{"type":"Feature","id":"8.8.8.8","geometry":{"type":"Point","coordinates":[-97.822,37.751]},"properties":{"status":"success","country":"United States","query":"8.8.8.8"}}]}`,
+		},
+		{
+			"zero coordinates",
+			[]ip_api.Location{{Status: "success", Lat: &zero, Lon: &zero, Query: "1.1.1.1"}},
+			`{"type":"FeatureCollection","features":[{"type":"Feature","id":"1.1.1.1","geometry":{"type":"Point","coordinates":[0,0]},"properties":{"status":"success","query":"1.1.1.1"}}]}`,
+		},
+		{
+			"no coordinates",
+			[]ip_api.Location{{Status: "success", Country: "United States", Query: "8.8.8.8"}},
+			`{"type":"FeatureCollection","features":[{"type":"Feature","id":"8.8.8.8","geometry":null,"properties":{"status":"success","country":"United States","query":"8.8.8.8"}}]}`,
+		},
+		{
+			"failed queries",
+			[]ip_api.Location{{Status: "success", Lat: &lat, Lon: &lon, Query: "8.8.8.8"}, {Status: "fail", Message: "private range", Query: "10.0.0.1"}},
+			`{"type":"FeatureCollection","features":[{"type":"Feature","id":"8.8.8.8","geometry":{"type":"Point","coordinates":[-97.822,37.751]},"properties":{"status":"success","query":"8.8.8.8"}}],"failed":[{"status":"fail","message":"private range","query":"10.0.0.1"}]}`,
+		},
+		{
+			"only failed queries",
+			[]ip_api.Location{{Status: "fail", Message: "invalid query", Query: "invalid"}},
+			`{"type":"FeatureCollection","features":[],"failed":[{"status":"fail","message":"invalid query","query":"invalid"}]}`,
+		},
+		{
+			"empty batch",
+			nil,
+			`{"type":"FeatureCollection","features":[]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := json.Marshal(toGeoJSONFeatureCollection(test.locations, outputOptions{format: formatGeoJSON}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.expected {
+				t.Errorf("got %s, expected %s", body, test.expected)
+			}
+		})
+	}
+}
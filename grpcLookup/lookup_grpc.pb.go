@@ -0,0 +1,232 @@
+// gRPC lookup service of ip-api-proxy, served alongside the HTTP endpoints when grpc is enabled.
+// Lookups share the proxy's cache, rate limits and metrics with the HTTP endpoints.
+//
+// Regenerate the Go code after changing this file, from the repository root:
+//   protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative grpcLookup/lookup.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: grpcLookup/lookup.proto
+
+package grpcLookup
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	LookupService_Lookup_FullMethodName       = "/ipapiproxy.v1.LookupService/Lookup"
+	LookupService_BatchLookup_FullMethodName  = "/ipapiproxy.v1.LookupService/BatchLookup"
+	LookupService_StreamLookup_FullMethodName = "/ipapiproxy.v1.LookupService/StreamLookup"
+)
+
+// LookupServiceClient is the client API for LookupService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LookupServiceClient interface {
+	// Lookup looks up a single query, like /json/{query}.
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Location, error)
+	// BatchLookup looks up many queries at once, like /batch. Locations are returned in request order.
+	BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error)
+	// StreamLookup looks up queries as they are sent, like /batch/stream.
+	// Cache hits are answered straight away and misses once their batch is looked up, so locations are not in request order.
+	// Every location carries its query, and the id of its request.
+	StreamLookup(ctx context.Context, opts ...grpc.CallOption) (LookupService_StreamLookupClient, error)
+}
+
+type lookupServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLookupServiceClient(cc grpc.ClientConnInterface) LookupServiceClient {
+	return &lookupServiceClient{cc}
+}
+
+func (c *lookupServiceClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*Location, error) {
+	out := new(Location)
+	err := c.cc.Invoke(ctx, LookupService_Lookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lookupServiceClient) BatchLookup(ctx context.Context, in *BatchLookupRequest, opts ...grpc.CallOption) (*BatchLookupResponse, error) {
+	out := new(BatchLookupResponse)
+	err := c.cc.Invoke(ctx, LookupService_BatchLookup_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lookupServiceClient) StreamLookup(ctx context.Context, opts ...grpc.CallOption) (LookupService_StreamLookupClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LookupService_ServiceDesc.Streams[0], LookupService_StreamLookup_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lookupServiceStreamLookupClient{stream}
+	return x, nil
+}
+
+type LookupService_StreamLookupClient interface {
+	Send(*LookupRequest) error
+	Recv() (*Location, error)
+	grpc.ClientStream
+}
+
+type lookupServiceStreamLookupClient struct {
+	grpc.ClientStream
+}
+
+func (x *lookupServiceStreamLookupClient) Send(m *LookupRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *lookupServiceStreamLookupClient) Recv() (*Location, error) {
+	m := new(Location)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LookupServiceServer is the server API for LookupService service.
+// All implementations must embed UnimplementedLookupServiceServer
+// for forward compatibility
+type LookupServiceServer interface {
+	// Lookup looks up a single query, like /json/{query}.
+	Lookup(context.Context, *LookupRequest) (*Location, error)
+	// BatchLookup looks up many queries at once, like /batch. Locations are returned in request order.
+	BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error)
+	// StreamLookup looks up queries as they are sent, like /batch/stream.
+	// Cache hits are answered straight away and misses once their batch is looked up, so locations are not in request order.
+	// Every location carries its query, and the id of its request.
+	StreamLookup(LookupService_StreamLookupServer) error
+	mustEmbedUnimplementedLookupServiceServer()
+}
+
+// UnimplementedLookupServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLookupServiceServer struct {
+}
+
+func (UnimplementedLookupServiceServer) Lookup(context.Context, *LookupRequest) (*Location, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedLookupServiceServer) BatchLookup(context.Context, *BatchLookupRequest) (*BatchLookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchLookup not implemented")
+}
+func (UnimplementedLookupServiceServer) StreamLookup(LookupService_StreamLookupServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLookup not implemented")
+}
+func (UnimplementedLookupServiceServer) mustEmbedUnimplementedLookupServiceServer() {}
+
+// UnsafeLookupServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LookupServiceServer will
+// result in compilation errors.
+type UnsafeLookupServiceServer interface {
+	mustEmbedUnimplementedLookupServiceServer()
+}
+
+func RegisterLookupServiceServer(s grpc.ServiceRegistrar, srv LookupServiceServer) {
+	s.RegisterService(&LookupService_ServiceDesc, srv)
+}
+
+func _LookupService_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LookupServiceServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LookupService_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LookupServiceServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LookupService_BatchLookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchLookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LookupServiceServer).BatchLookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LookupService_BatchLookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LookupServiceServer).BatchLookup(ctx, req.(*BatchLookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LookupService_StreamLookup_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LookupServiceServer).StreamLookup(&lookupServiceStreamLookupServer{stream})
+}
+
+type LookupService_StreamLookupServer interface {
+	Send(*Location) error
+	Recv() (*LookupRequest, error)
+	grpc.ServerStream
+}
+
+type lookupServiceStreamLookupServer struct {
+	grpc.ServerStream
+}
+
+func (x *lookupServiceStreamLookupServer) Send(m *Location) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *lookupServiceStreamLookupServer) Recv() (*LookupRequest, error) {
+	m := new(LookupRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LookupService_ServiceDesc is the grpc.ServiceDesc for LookupService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LookupService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ipapiproxy.v1.LookupService",
+	HandlerType: (*LookupServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _LookupService_Lookup_Handler,
+		},
+		{
+			MethodName: "BatchLookup",
+			Handler:    _LookupService_BatchLookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamLookup",
+			Handler:       _LookupService_StreamLookup_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "grpcLookup/lookup.proto",
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/grpcLookup"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+lookupServer - implements the gRPC LookupService with the same cache, rate limits and metrics as the HTTP endpoints
+ */
+type lookupServer struct {
+	grpcLookup.UnimplementedLookupServiceServer
+}
+
+/*
+grpcStreamBatch - cache misses of a StreamLookup, looked up together
+queries - queries to look up
+ids - request ids of the queries
+key - IP-API key of the batch
+ */
+type grpcStreamBatch struct {
+	queries []streamQuery
+	ids     []string
+	key     string
+}
+
+/*
+startGRPC - starts the gRPC server on the configured grpc port
+
+returns
+gRPC server, serving in the background
+error if the port can't be listened on
+ */
+func startGRPC() (*grpc.Server, error) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(LoadedConfig.GRPC.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(grpcUnaryMetrics),
+		grpc.StreamInterceptor(grpcStreamMetrics),
+	)
+	grpcLookup.RegisterLookupServiceServer(server, &lookupServer{})
+
+	log.Println("Starting gRPC server on port " + strconv.Itoa(LoadedConfig.GRPC.Port) + "...")
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Println("error: gRPC server: " + err.Error())
+		}
+	}()
+	return server, nil
+}
+
+//grpcUnaryMetrics - counts unary gRPC requests by method and status code
+func grpcUnaryMetrics(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	response, err := handler(ctx, request)
+	promMetrics.IncrementGRPCRequests(path.Base(info.FullMethod), status.Code(err).String())
+	return response, err
+}
+
+//grpcStreamMetrics - counts streaming gRPC requests by method and status code
+func grpcStreamMetrics(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(server, stream)
+	promMetrics.IncrementGRPCRequests(path.Base(info.FullMethod), status.Code(err).String())
+	return err
+}
+
+/*
+Lookup - looks up a single query, a blank query looks up the calling client
+ */
+func (s *lookupServer) Lookup(ctx context.Context, request *grpcLookup.LookupRequest) (*grpcLookup.Location, error) {
+	//increment requests processed
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementSingleRequestsProcessed()
+	//increment queries processed (single query so can increment here)
+	promMetrics.IncrementSingleQueriesProcessed()
+	promMetrics.IncrementQueriesProcessed()
+
+	//Like IP-API, a blank query looks up the client making the request
+	queryIP := ip_api.QueryIP{Query: request.Query, Fields: request.Fields, Lang: request.Lang}
+	if queryIP.Query == "" {
+		if client, ok := peer.FromContext(ctx); ok {
+			queryIP.Query, _, _ = net.SplitHostPort(client.Addr.String())
+		}
+	}
+
+	query, err := newStreamQuery(queryIP, 0, "")
+	if err != nil {
+		log.Println("Failed gRPC single request: " + err.Error())
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key := LoadedConfig.APIKey
+	if request.Key != "" {
+		key = request.Key
+	}
+
+	//Get location from cache, or from IP-API if it isn't cached
+	location, _, err := lookupLocation(ctx, query.query.Query, query.lang, query.fields, key)
+	if err != nil {
+		log.Println("Failed gRPC single request: " + err.Error())
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	if location.Status == "fail" {
+		log.Println("Failed gRPC single query: " + query.query.Query)
+		promMetrics.IncrementFailedQueries()
+		promMetrics.IncrementFailedSingleQueries()
+	} else {
+		promMetrics.IncrementSuccessfulQueries()
+		promMetrics.IncrementSuccessfulSingeQueries()
+	}
+
+	return toGRPCLocation(location, ""), nil
+}
+
+/*
+BatchLookup - looks up a batch of queries, invalid queries and IP-API errors are returned as failed locations
+ */
+func (s *lookupServer) BatchLookup(ctx context.Context, request *grpcLookup.BatchLookupRequest) (*grpcLookup.BatchLookupResponse, error) {
+	//increment requests processed
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementBatchRequestsProcessed()
+
+	fields, err := cache.ParseFields(request.Fields)
+	var lang string
+	if err == nil && request.Lang != "" {
+		lang, err = ip_api.ValidateLang(request.Lang)
+	}
+	if err == nil && len(request.Queries) == 0 {
+		err = errors.New("no queries passed")
+	}
+	if err != nil {
+		log.Println("Failed gRPC batch request: " + err.Error())
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedBatchRequests()
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key := LoadedConfig.APIKey
+	if request.Key != "" {
+		key = request.Key
+	}
+
+	locations := make([]*grpcLookup.Location, len(request.Queries))
+	var queries []streamQuery
+	var indexes []int
+	for i, queryRequest := range request.Queries {
+		promMetrics.IncrementBatchQueriesProcessed()
+		query, err := newStreamQuery(ip_api.QueryIP{Query: queryRequest.Query, Fields: queryRequest.Fields, Lang: queryRequest.Lang}, fields, lang)
+		if err != nil {
+			//invalid queries fail on their own, like /batch
+			log.Println("Failed gRPC batch query: " + queryRequest.Query + " " + err.Error())
+			promMetrics.IncrementQueriesProcessed()
+			promMetrics.IncrementFailedQueries()
+			promMetrics.IncrementFailedBatchQueries()
+			locations[i] = &grpcLookup.Location{Status: "fail", Message: err.Error(), Query: queryRequest.Query}
+			continue
+		}
+		queries = append(queries, *query)
+		indexes = append(indexes, i)
+	}
+
+	results, _, _, err := lookupStreamQueries(ctx, queries, key, 0)
+	if err != nil {
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedBatchRequests()
+		return nil, status.FromContextError(err).Err()
+	}
+	for i, index := range indexes {
+		if results[i].Status == "fail" {
+			promMetrics.IncrementFailedBatchQueries()
+		} else {
+			promMetrics.IncrementSuccessfulBatchQueries()
+		}
+		locations[index] = toGRPCLocation(&results[i], "")
+	}
+
+	return &grpcLookup.BatchLookupResponse{Locations: locations}, nil
+}
+
+/*
+StreamLookup - looks up queries as they are received, cache hits are sent straight away
+misses are looked up once a batch is full, or once no more misses arrived within streamFlushDelay, so locations are not in request order
+ */
+func (s *lookupServer) StreamLookup(stream grpcLookup.LookupService_StreamLookupServer) error {
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementStreamRequestsProcessed()
+	promMetrics.IncrementStreamsActive()
+	defer promMetrics.DecreaseStreamsActive()
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	results := make(chan *grpcLookup.Location, streamBufferSize)
+	batches := make(chan grpcStreamBatch)
+
+	var receiveErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(batches)
+		receiveErr = receiveGRPCStream(ctx, stream, results, batches)
+	}()
+	go func() {
+		defer wg.Done()
+		fetchGRPCStream(ctx, batches, results)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		if err := stream.Send(result); err != nil {
+			log.Println("gRPC stream cancelled: " + err.Error())
+			cancel()
+			for range results {
+			}
+			return err
+		}
+	}
+	return receiveErr
+}
+
+/*
+receiveGRPCStream - reads the requests of a StreamLookup, sends cache hits and invalid requests to results and groups misses into batches
+a batch is flushed once it is full, once no more misses arrived within streamFlushDelay, or once a request with another key arrives
+ */
+func receiveGRPCStream(ctx context.Context, stream grpcLookup.LookupService_StreamLookupServer, results chan<- *grpcLookup.Location, batches chan<- grpcStreamBatch) error {
+	//Recv blocks, so it is read separately from the flush timer
+	requests := make(chan *grpcLookup.LookupRequest)
+	var recvErr error
+	go func() {
+		defer close(requests)
+		for {
+			request, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF {
+					recvErr = err
+				}
+				return
+			}
+			select {
+			case requests <- request:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var pending grpcStreamBatch
+	flushTimer := time.NewTimer(streamFlushDelay)
+	flushTimer.Stop()
+	flush := func() bool {
+		flushTimer.Stop()
+		if len(pending.queries) == 0 {
+			return true
+		}
+		select {
+		case batches <- pending:
+			pending = grpcStreamBatch{}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	send := func(result *grpcLookup.Location) bool {
+		select {
+		case results <- result:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case request, ok := <-requests:
+			if !ok {
+				flush()
+				return recvErr
+			}
+			promMetrics.IncrementStreamQueriesProcessed()
+
+			query, err := newStreamQuery(ip_api.QueryIP{Query: request.Query, Fields: request.Fields, Lang: request.Lang}, 0, "")
+			if err != nil {
+				promMetrics.IncrementQueriesProcessed()
+				promMetrics.IncrementFailedQueries()
+				if !send(&grpcLookup.Location{Status: "fail", Message: err.Error(), Query: request.Query, Id: request.Id}) {
+					return ctx.Err()
+				}
+				continue
+			}
+
+			location, found, err := cache.GetLocation(query.query.Query+query.lang, query.fields)
+			if err != nil {
+				log.Println(err)
+			}
+			if found {
+				promMetrics.IncrementQueriesProcessed()
+				promMetrics.IncrementCacheHits()
+				if location.Status == "fail" {
+					promMetrics.IncrementFailedQueries()
+				} else {
+					promMetrics.IncrementSuccessfulQueries()
+				}
+				if !send(toGRPCLocation(location, request.Id)) {
+					return ctx.Err()
+				}
+				continue
+			}
+
+			key := LoadedConfig.APIKey
+			if request.Key != "" {
+				key = request.Key
+			}
+			if len(pending.queries) > 0 && pending.key != key && !flush() {
+				return ctx.Err()
+			}
+			pending.key = key
+			pending.queries = append(pending.queries, *query)
+			pending.ids = append(pending.ids, request.Id)
+			if len(pending.queries) == 1 {
+				flushTimer.Reset(streamFlushDelay)
+			}
+			if len(pending.queries) >= maxBatchQueries && !flush() {
+				return ctx.Err()
+			}
+		case <-flushTimer.C:
+			if !flush() {
+				return ctx.Err()
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+/*
+fetchGRPCStream - looks up batches of StreamLookup cache misses and sends their locations
+an IP-API error fails every query of its batch, the stream carries on with the next batch
+ */
+func fetchGRPCStream(ctx context.Context, batches <-chan grpcStreamBatch, results chan<- *grpcLookup.Location) {
+	for batch := range batches {
+		locations, _, _, err := lookupStreamQueries(ctx, batch.queries, batch.key, 0)
+		if err != nil {
+			return
+		}
+		for i := range locations {
+			select {
+			case results <- toGRPCLocation(&locations[i], batch.ids[i]):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+/*
+toGRPCLocation - converts an ip_api Location into a gRPC Location
+location - ip_api location, already projected to the requested fields
+id - id of the StreamLookup request, "" otherwise
+ */
+func toGRPCLocation(location *ip_api.Location, id string) *grpcLookup.Location {
+	return &grpcLookup.Location{
+		Status:        location.Status,
+		Message:       location.Message,
+		Continent:     location.Continent,
+		ContinentCode: location.ContinentCode,
+		Country:       location.Country,
+		CountryCode:   location.CountryCode,
+		Region:        location.Region,
+		RegionName:    location.RegionName,
+		City:          location.City,
+		District:      location.District,
+		Zip:           location.ZIP,
+		Lat:           location.Lat,
+		Lon:           location.Lon,
+		Timezone:      location.Timezone,
+		Currency:      location.Currency,
+		Isp:           location.ISP,
+		Org:           location.Org,
+		As:            location.AS,
+		Asname:        location.ASName,
+		Reverse:       location.Reverse,
+		Mobile:        location.Mobile,
+		Proxy:         location.Proxy,
+		Hosting:       location.Hosting,
+		Query:         location.Query,
+		Id:            id,
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/grpcLookup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+// grpcLocationSummary - the fields of a gRPC location the tests compare
+func grpcLocationSummary(location *grpcLookup.Location) string {
+	if location == nil {
+		return ""
+	}
+	return location.Status + "|" + location.Message + "|" + location.Country + "|" + location.CountryCode + "|" + location.Query
+}
+
+// addGRPCTestLocations - caches the locations looked up by the gRPC tests, so IP-API is never called
+func addGRPCTestLocations(t *testing.T) func() {
+	locations := map[string]ip_api.Location{
+		"8.8.8.8":      {Status: "success", Country: "United States", CountryCode: "US", Query: "8.8.8.8"},
+		"8.8.8.8de":    {Status: "success", Country: "Vereinigte Staaten", CountryCode: "US", Query: "8.8.8.8"},
+		"192.0.2.10":   {Status: "success", Country: "Australia", CountryCode: "AU", Query: "192.0.2.10"},
+		"192.0.2.10de": {Status: "success", Country: "Australien", CountryCode: "AU", Query: "192.0.2.10"},
+	}
+	for key, location := range locations {
+		if _, err := cache.AddLocation(key, location, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key := range locations {
+			cache.DeleteLocation(key)
+		}
+	}
+}
+
+func TestGRPCLookup(t *testing.T) {
+	defer addGRPCTestLocations(t)()
+	server := &lookupServer{}
+	clientCtx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000}})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		request  *grpcLookup.LookupRequest
+		expected string
+		code     codes.Code
+	}{
+		{"default fields", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8"}, "success||United States|US|8.8.8.8", codes.OK},
+		{"fields", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8", Fields: "country"}, "||United States||", codes.OK},
+		{"numeric fields", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8", Fields: "16386"}, "success|||US|", codes.OK},
+		{"lang", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8", Fields: "country", Lang: "de"}, "||Vereinigte Staaten||", codes.OK},
+		{"blank query looks up the client", clientCtx, &grpcLookup.LookupRequest{Fields: "country,query"}, "||Australia||192.0.2.10", codes.OK},
+		{"blank query without a client", context.Background(), &grpcLookup.LookupRequest{}, "", codes.InvalidArgument},
+		{"invalid fields", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8", Fields: "nation"}, "", codes.InvalidArgument},
+		{"invalid lang", context.Background(), &grpcLookup.LookupRequest{Query: "8.8.8.8", Lang: "xx"}, "", codes.InvalidArgument},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location, err := server.Lookup(test.ctx, test.request)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got code %s (%v), expected %s", code, err, test.code)
+			}
+			if summary := grpcLocationSummary(location); summary != test.expected {
+				t.Errorf("got %q, expected %q", summary, test.expected)
+			}
+		})
+	}
+}
+
+func TestGRPCBatchLookup(t *testing.T) {
+	defer addGRPCTestLocations(t)()
+	server := &lookupServer{}
+
+	tests := []struct {
+		name     string
+		request  *grpcLookup.BatchLookupRequest
+		expected []string
+		code     codes.Code
+	}{
+		{
+			"batch fields and lang",
+			&grpcLookup.BatchLookupRequest{Fields: "country", Lang: "de", Queries: []*grpcLookup.LookupRequest{{Query: "8.8.8.8"}, {Query: "192.0.2.10"}}},
+			[]string{"||Vereinigte Staaten||", "||Australien||"},
+			codes.OK,
+		},
+		{
+			"queries override the batch",
+			&grpcLookup.BatchLookupRequest{Fields: "country", Queries: []*grpcLookup.LookupRequest{{Query: "8.8.8.8", Lang: "de"}, {Query: "192.0.2.10", Fields: "countryCode,query"}}},
+			[]string{"||Vereinigte Staaten||", "|||AU|192.0.2.10"},
+			codes.OK,
+		},
+		{
+			"invalid queries fail on their own in order",
+			&grpcLookup.BatchLookupRequest{Fields: "countryCode", Queries: []*grpcLookup.LookupRequest{{Query: ""}, {Query: "8.8.8.8"}, {Query: "192.0.2.10", Fields: "nation"}}},
+			[]string{"fail|request is blank|||", "|||US|", "fail|error: illegal field provided: nation|||192.0.2.10"},
+			codes.OK,
+		},
+		{
+			"no queries",
+			&grpcLookup.BatchLookupRequest{},
+			nil,
+			codes.InvalidArgument,
+		},
+		{
+			"invalid batch fields",
+			&grpcLookup.BatchLookupRequest{Fields: "nation", Queries: []*grpcLookup.LookupRequest{{Query: "8.8.8.8"}}},
+			nil,
+			codes.InvalidArgument,
+		},
+		{
+			"invalid batch lang",
+			&grpcLookup.BatchLookupRequest{Lang: "xx", Queries: []*grpcLookup.LookupRequest{{Query: "8.8.8.8"}}},
+			nil,
+			codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := server.BatchLookup(context.Background(), test.request)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("got code %s (%v), expected %s", code, err, test.code)
+			}
+			if err != nil {
+				return
+			}
+			if len(response.Locations) != len(test.expected) {
+				t.Fatalf("got %d locations, expected %d", len(response.Locations), len(test.expected))
+			}
+			for i, location := range response.Locations {
+				if summary := grpcLocationSummary(location); summary != test.expected[i] {
+					t.Errorf("got %q at %d, expected %q", summary, i, test.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//X-Cache values of single lookups
+const (
+	cacheHit   = "HIT"
+	cacheMiss  = "MISS"
+	cacheStale = "STALE"
+)
+
+/*
+httpCacheState - how the location of a single lookup was cached, for its HTTP caching headers
+xCache - HIT if it came from cache, MISS if it was looked up with IP-API, STALE if refreshing it failed and the cached location was served
+record - cache record of the location, nil if it wasn't cached (nocache)
+private - whether the response is for the client only, like lookups of the client making the request
+provider - provider the location is looked up with, for locations which weren't cached
+ */
+type httpCacheState struct {
+	xCache   string
+	record   *cache.Record
+	private  bool
+	provider string
+}
+
+//meta - gets the locationMeta of the location
+func (s httpCacheState) meta() *locationMeta {
+	switch {
+	case s.record == nil:
+		return &locationMeta{Source: cache.SourceUpstream, Provider: s.provider}
+	case s.xCache == cacheHit:
+		return recordMeta(s.record, cache.SourceCache)
+	case s.xCache == cacheStale:
+		return recordMeta(s.record, cache.SourceStale)
+	}
+	return recordMeta(s.record, "")
+}
+
+/*
+writeCachedLocation - writes the location of a single lookup with HTTP caching headers derived from its cache record
+Cache-Control and Expires follow the record's expiration, Age the time since it was cached, and the ETag the response body
+a request with a matching If-None-Match is answered with 304, unless the location wasn't cached (no-store), and HEAD requests get the headers only
+w - http response writer
+r - http request
+location - ip_api location, already projected to the requested fields
+options - outputOptions of the request
+state - httpCacheState of the location
+
+returns
+HTTP status code written
+ */
+func writeCachedLocation(w http.ResponseWriter, r *http.Request, location *ip_api.Location, options outputOptions, state httpCacheState) int {
+	var meta *locationMeta
+	if options.meta {
+		meta = state.meta()
+	}
+	statusCode, body := locationBody(w, http.StatusOK, location, meta, options)
+
+	header := w.Header()
+	header.Set("X-Cache", state.xCache)
+	if state.record == nil {
+		header.Set("Cache-Control", "no-store")
+	} else {
+		maxAge := int(time.Until(state.record.ExpirationTime) / time.Second)
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		visibility := "public"
+		if state.private {
+			visibility = "private"
+		}
+		header.Set("Cache-Control", visibility+", max-age="+strconv.Itoa(maxAge))
+		header.Set("Expires", state.record.ExpirationTime.UTC().Format(http.TimeFormat))
+		age := int(recordAge(state.record) / time.Second)
+		if age < 0 {
+			age = 0
+		}
+		header.Set("Age", strconv.Itoa(age))
+	}
+
+	etag := bodyETag(body)
+	header.Set("ETag", etag)
+	if state.record != nil && etagMatches(r.Header.Values("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(statusCode)
+	if r.Method != "HEAD" {
+		_, _ = w.Write(body)
+	}
+	return statusCode
+}
+
+//bodyETag - gets the strong ETag of a response body
+func bodyETag(body []byte) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write(body)
+	return `"` + strconv.FormatUint(hash.Sum64(), 16) + `"`
+}
+
+/*
+etagMatches - checks if If-None-Match headers match an ETag, using the weak comparison of RFC 9110
+ifNoneMatch - If-None-Match header values
+etag - ETag of the response
+ */
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	for _, value := range ifNoneMatch {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
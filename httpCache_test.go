@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc123"`
+
+	tests := []struct {
+		name        string
+		ifNoneMatch []string
+		expected    bool
+	}{
+		{"no header", nil, false},
+		{"empty header", []string{""}, false},
+		{"exact match", []string{`"abc123"`}, true},
+		{"weak match", []string{`W/"abc123"`}, true},
+		{"wildcard", []string{"*"}, true},
+		{"different etag", []string{`"def456"`}, false},
+		{"unquoted etag", []string{"abc123"}, false},
+		{"match in list", []string{`"def456", "abc123"`}, true},
+		{"match in list without spaces", []string{`"def456","abc123"`}, true},
+		{"match in second header", []string{`"def456"`, `W/"abc123"`}, true},
+		{"no match in list", []string{`"def456", W/"ghi789"`}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matched := etagMatches(test.ifNoneMatch, etag); matched != test.expected {
+				t.Errorf("got %t, expected %t", matched, test.expected)
+			}
+		})
+	}
+}
+
+func TestWriteCachedLocation(t *testing.T) {
+	location := &ip_api.Location{Status: "success", Country: "United States", Query: "8.8.8.8"}
+	options := outputOptions{format: formatJSON, fields: cache.FieldStatus | cache.FieldCountry | cache.FieldQuery}
+	record := &cache.Record{
+		InsertionTime:  time.Now().Add(-10 * time.Minute),
+		ExpirationTime: time.Now().Add(50 * time.Minute),
+		Location:       *location,
+	}
+	_, body := locationBody(httptest.NewRecorder(), http.StatusOK, location, nil, options)
+	etag := bodyETag(body)
+
+	tests := []struct {
+		name         string
+		method       string
+		ifNoneMatch  string
+		state        httpCacheState
+		statusCode   int
+		body         bool
+		cacheControl string
+	}{
+		{"hit", "GET", "", httpCacheState{xCache: cacheHit, record: record}, http.StatusOK, true, "public, max-age="},
+		{"private", "GET", "", httpCacheState{xCache: cacheHit, record: record, private: true}, http.StatusOK, true, "private, max-age="},
+		{"no-store", "GET", "", httpCacheState{xCache: cacheMiss}, http.StatusOK, true, "no-store"},
+		{"head", "HEAD", "", httpCacheState{xCache: cacheHit, record: record}, http.StatusOK, false, "public, max-age="},
+		{"matching etag", "GET", etag, httpCacheState{xCache: cacheHit, record: record}, http.StatusNotModified, false, "public, max-age="},
+		{"weak matching etag", "GET", "W/" + etag, httpCacheState{xCache: cacheHit, record: record}, http.StatusNotModified, false, "public, max-age="},
+		{"wildcard", "GET", "*", httpCacheState{xCache: cacheMiss, record: record}, http.StatusNotModified, false, "public, max-age="},
+		{"other etag", "GET", `"other"`, httpCacheState{xCache: cacheHit, record: record}, http.StatusOK, true, "public, max-age="},
+		{"no-store never 304", "GET", etag, httpCacheState{xCache: cacheMiss}, http.StatusOK, true, "no-store"},
+		{"no-store wildcard", "GET", "*", httpCacheState{xCache: cacheMiss}, http.StatusOK, true, "no-store"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/json/8.8.8.8", nil)
+			if test.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+
+			statusCode := writeCachedLocation(w, r, location, options, test.state)
+			if statusCode != test.statusCode || w.Code != test.statusCode {
+				t.Fatalf("got status %d (written %d), expected %d", statusCode, w.Code, test.statusCode)
+			}
+			if (w.Body.Len() > 0) != test.body {
+				t.Errorf("got body %q, expected body %t", w.Body.String(), test.body)
+			}
+			if w.Header().Get("X-Cache") != test.state.xCache {
+				t.Errorf("got X-Cache %s, expected %s", w.Header().Get("X-Cache"), test.state.xCache)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Errorf("got ETag %s, expected %s", w.Header().Get("ETag"), etag)
+			}
+			if test.statusCode == http.StatusOK && w.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+				t.Errorf("got Content-Length %s, expected %d", w.Header().Get("Content-Length"), len(body))
+			}
+
+			cacheControl := w.Header().Get("Cache-Control")
+			if test.cacheControl == "no-store" {
+				if cacheControl != "no-store" || w.Header().Get("Age") != "" || w.Header().Get("Expires") != "" {
+					t.Errorf("got Cache-Control %q, Age %q and Expires %q, expected no-store only", cacheControl, w.Header().Get("Age"), w.Header().Get("Expires"))
+				}
+				return
+			}
+
+			//the record was cached 10 minutes ago and expires in 50, allowing a second for the test to run
+			maxAge, err := strconv.Atoi(cacheControl[len(test.cacheControl):])
+			if cacheControl[:len(test.cacheControl)] != test.cacheControl || err != nil || maxAge < 50*60-1 || maxAge > 50*60 {
+				t.Errorf("got Cache-Control %q, expected %s%d", cacheControl, test.cacheControl, 50*60)
+			}
+			age, err := strconv.Atoi(w.Header().Get("Age"))
+			if err != nil || age < 10*60 || age > 10*60+1 {
+				t.Errorf("got Age %q, expected %d", w.Header().Get("Age"), 10*60)
+			}
+			if w.Header().Get("Expires") != record.ExpirationTime.UTC().Format(http.TimeFormat) {
+				t.Errorf("got Expires %q, expected %q", w.Header().Get("Expires"), record.ExpirationTime.UTC().Format(http.TimeFormat))
+			}
+		})
+	}
+}
+
+func TestCorsHandlerVary(t *testing.T) {
+	LoadedConfig.CORS.AllowedOrigins = []string{"*"}
+	defer func() {
+		LoadedConfig.CORS.Enabled = false
+		LoadedConfig.CORS.AllowedOrigins = nil
+	}()
+	handler := corsHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		origin   string
+		expected string
+	}{
+		{"cors disabled", false, "https://example.com", ""},
+		{"wildcard origin", true, "https://example.com", "Origin"},
+		{"no origin", true, "", "Origin"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			LoadedConfig.CORS.Enabled = test.enabled
+			r := httptest.NewRequest("GET", "/json/8.8.8.8", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if vary := w.Header().Get("Vary"); vary != test.expected {
+				t.Errorf("got Vary %q, expected %q", vary, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,793 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//jobsPath - path prefix of the bulk lookup job endpoints
+const jobsPath = "/jobs"
+
+//Job statuses
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+//jobQueueSize - jobs which can wait to run, further submissions are rejected
+const jobQueueSize = 1000
+
+//jobRetries - times a batch is retried after an IP-API error, before its queries are failed
+const jobRetries = 3
+
+/*
+Job - a bulk lookup job, persisted as <id>.json in the jobs directory
+the queries are persisted as <id>.queries (one query line per query) and the results as <id>.results (one json location per line, in query order)
+ID - random job id
+Client - id of the client which submitted the job, only it can see the job, empty when no clients are configured
+Status - queued, running, done, failed or cancelled
+Error - reason a job failed
+Created - time the job was submitted
+Updated - time the job last made progress
+Fields - fields parameter the queries are looked up with
+Lang - lang parameter the queries are looked up with
+Total - number of queries
+Done - number of queries with a result
+Cached - queries answered from cache
+Fetched - queries looked up with IP-API
+Failed - queries which failed
+ETA - estimated time left under the current rate limit, only set in status responses
+ */
+type Job struct {
+	ID      string    `json:"id"`
+	Client  string    `json:"client,omitempty"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Fields  string    `json:"fields,omitempty"`
+	Lang    string    `json:"lang,omitempty"`
+	Total   int       `json:"total"`
+	Done    int       `json:"done"`
+	Cached  int       `json:"cached"`
+	Fetched int       `json:"fetched"`
+	Failed  int       `json:"failed"`
+	ETA     string    `json:"eta,omitempty"`
+}
+
+var (
+	jobs        = map[string]*Job{}
+	jobCancels  = map[string]context.CancelFunc{}
+	jobsMutex   sync.Mutex
+	jobQueue    = make(chan string, jobQueueSize)
+	jobsStarted time.Time
+	jobsStopped bool
+)
+
+/*
+startJobs - loads the persisted jobs and starts the job worker
+jobs which were queued or running when the proxy stopped are resumed in the order they were submitted
+ */
+func startJobs() {
+	jobsStarted = time.Now()
+
+	err := os.MkdirAll(jobsDirectory(), 0755)
+	if err != nil {
+		log.Println("error: creating jobs directory: " + err.Error())
+	}
+
+	fileNames, _ := filepath.Glob(jobsDirectory() + "*.json")
+	var resumed []*Job
+	for _, fileName := range fileNames {
+		job, err := readJob(fileName)
+		if err != nil {
+			log.Println("error: reading job " + fileName + ": " + err.Error())
+			continue
+		}
+		jobs[job.ID] = job
+		if job.Status == jobQueued || job.Status == jobRunning {
+			resumed = append(resumed, job)
+		}
+	}
+
+	sort.Slice(resumed, func(i, j int) bool {
+		return resumed[i].Created.Before(resumed[j].Created)
+	})
+	for _, job := range resumed {
+		log.Println("Resuming job " + job.ID + " at " + strconv.Itoa(job.Done) + " of " + strconv.Itoa(job.Total) + " queries")
+		job.Status = jobQueued
+		select {
+		case jobQueue <- job.ID:
+		default:
+			log.Println("error: job queue is full, job " + job.ID + " will resume after the next restart")
+		}
+	}
+
+	go func() {
+		for id := range jobQueue {
+			runJob(id)
+		}
+	}()
+}
+
+//jobsDirectory - directory jobs are persisted in
+func jobsDirectory() string {
+	return LoadedConfig.Cache.WriteLocation + "jobs" + utils.DirPath
+}
+
+//jobFileName - file name of one of a job's files, ext is json, queries or results
+func jobFileName(id string, ext string) string {
+	return jobsDirectory() + id + "." + ext
+}
+
+/*
+jobsHandler - handles the bulk lookup job endpoints
+POST /jobs - submits a job, the body is a json array of batch queries, or one query per line (ex: an uploaded file)
+GET /jobs - lists the jobs
+GET /jobs/{id} - gets the status of a job
+GET /jobs/{id}/results - downloads the results of a job in any of the negotiated formats
+DELETE /jobs/{id} - cancels a job and deletes its files
+when clients are configured, requests must authenticate with HTTP basic auth as one of them, and only see the jobs they submitted
+ */
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	promMetrics.IncrementRequestsProcessed()
+
+	//authenticate client if any are configured
+	var clientID string
+	if len(LoadedConfig.Clients) > 0 {
+		client, _ := authenticateClient(r)
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ip-api-proxy"`)
+			writeJSONError(w, http.StatusUnauthorized, "invalid or missing client credentials")
+			return
+		}
+		clientID = client.ID
+	}
+
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, jobsPath), "/"), "/")
+	switch {
+	case pathParts[0] == "" && r.Method == "POST":
+		submitJob(w, r, clientID)
+	case pathParts[0] == "" && r.Method == "GET":
+		listJobs(w, clientID)
+	case len(pathParts) == 1 && r.Method == "GET":
+		writeJobStatus(w, pathParts[0], clientID)
+	case len(pathParts) == 1 && r.Method == "DELETE":
+		deleteJob(w, pathParts[0], clientID)
+	case len(pathParts) == 2 && pathParts[1] == "results" && r.Method == "GET":
+		writeJobResults(w, r, pathParts[0], clientID)
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown jobs endpoint, expected POST /jobs, GET /jobs, GET or DELETE /jobs/{id}, or GET /jobs/{id}/results")
+	}
+}
+
+/*
+submitJob - validates and persists a job, then queues it
+clientID - id of the client submitting the job, empty when no clients are configured
+ */
+func submitJob(w http.ResponseWriter, r *http.Request, clientID string) {
+	query := r.URL.Query()
+
+	fields, err := cache.ParseFields(query.Get("fields"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var lang string
+	if query.Get("lang") != "" {
+		lang, err = ip_api.ValidateLang(query.Get("lang"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	//uploaded files are read from the file form field
+	body := r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "error reading uploaded file: "+err.Error())
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	job := &Job{
+		ID:      newJobID(),
+		Client:  clientID,
+		Status:  jobQueued,
+		Created: time.Now().UTC(),
+		Fields:  query.Get("fields"),
+		Lang:    lang,
+	}
+	job.Updated = job.Created
+
+	job.Total, err = writeJobQueries(job.ID, body, r.Header.Get("Content-Type"), fields, lang)
+	if err == nil && job.Total == 0 {
+		err = errors.New("no queries passed")
+	}
+	if err != nil {
+		_ = os.Remove(jobFileName(job.ID, "queries"))
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	jobsMutex.Lock()
+	err = saveJob(job)
+	if err == nil {
+		select {
+		case jobQueue <- job.ID:
+			jobs[job.ID] = job
+		default:
+			err = errors.New("too many jobs are queued, try again later")
+		}
+	}
+	jobsMutex.Unlock()
+
+	if err != nil {
+		removeJobFiles(job.ID)
+		log.Println("Failed job submission: " + err.Error())
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	log.Println("Queued job " + job.ID + " with " + strconv.Itoa(job.Total) + " queries")
+	w.Header().Set("Location", jobsPath+"/"+job.ID)
+	writeJob(w, http.StatusAccepted, job)
+}
+
+/*
+writeJobQueries - validates the submitted queries and persists them as query lines
+id - job id
+body - json array of batch queries, or one query per line
+contentType - content type of the body
+fields - fields of queries without their own
+lang - lang of queries without their own
+
+returns
+number of queries
+error for an invalid query
+ */
+func writeJobQueries(id string, body io.Reader, contentType string, fields cache.Fields, lang string) (int, error) {
+	file, err := os.Create(jobFileName(id, "queries"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	total := 0
+	writeQuery := func(line string) error {
+		query, err := parseStreamLine(line, fields, lang)
+		if query == nil && err == nil {
+			return nil
+		}
+		if err != nil {
+			return errors.New("query " + strconv.Itoa(total+1) + ": " + err.Error())
+		}
+		total++
+		if total > LoadedConfig.Jobs.MaxQueries {
+			return errors.New("jobs are limited to " + strconv.Itoa(LoadedConfig.Jobs.MaxQueries) + " queries")
+		}
+		queryLine, _ := json.Marshal(&query.query)
+		_, err = writer.Write(append(queryLine, '\n'))
+		return err
+	}
+
+	if strings.HasPrefix(contentType, "application/json") {
+		var queries []json.RawMessage
+		err = json.NewDecoder(body).Decode(&queries)
+		if err != nil {
+			return 0, err
+		}
+		for _, query := range queries {
+			//plain query strings are allowed as well as batch query objects
+			var queryString string
+			if json.Unmarshal(query, &queryString) == nil {
+				query = []byte(queryString)
+			}
+			err = writeQuery(string(query))
+			if err != nil {
+				return 0, err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 4096), maxStreamLineLength)
+		for scanner.Scan() {
+			err = writeQuery(scanner.Text())
+			if err != nil {
+				return 0, err
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return total, writer.Flush()
+}
+
+/*
+runJob - looks up the queries of a job, in batches of up to maxBatchQueries, from the query after the last result
+ */
+func runJob(id string) {
+	jobsMutex.Lock()
+	job, ok := jobs[id]
+	if !ok || job.Status != jobQueued || jobsStopped {
+		jobsMutex.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	jobCancels[id] = cancel
+	job.Status = jobRunning
+	_ = saveJob(job)
+	done := job.Done
+	fields, _ := cache.ParseFields(job.Fields)
+	lang := job.Lang
+	jobsMutex.Unlock()
+
+	defer func() {
+		jobsMutex.Lock()
+		delete(jobCancels, id)
+		jobsMutex.Unlock()
+		cancel()
+	}()
+
+	err := runJobQueries(ctx, job, done, fields, lang)
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if ctx.Err() != nil || jobs[id] != job {
+		//cancelled jobs are deleted by deleteJob
+		return
+	}
+	job.Updated = time.Now().UTC()
+	if err != nil {
+		log.Println("Failed job " + id + ": " + err.Error())
+		job.Status = jobFailed
+		job.Error = err.Error()
+	} else {
+		log.Println("Finished job " + id)
+		job.Status = jobDone
+	}
+	_ = saveJob(job)
+}
+
+/*
+stopJobs - stops the running jobs after their current batch, and keeps queued jobs from starting
+stopped jobs keep their saved progress, so they are resumed on the next start
+ctx - context, waiting for the running jobs stops when it is done
+ */
+func stopJobs(ctx context.Context) {
+	jobsMutex.Lock()
+	jobsStopped = true
+	for _, cancel := range jobCancels {
+		cancel()
+	}
+	jobsMutex.Unlock()
+
+	stopped := waitUntil(ctx, func() bool {
+		jobsMutex.Lock()
+		defer jobsMutex.Unlock()
+		return len(jobCancels) == 0
+	})
+	if !stopped {
+		log.Println("error: shutdown timed out before the running jobs stopped")
+	}
+}
+
+/*
+runJobQueries - looks up the queries of a job after the first done queries and appends their results
+results which were written after the last saved progress (ex: before a crash) are dropped first
+it stops once the job is deleted, without writing any of its files again
+ */
+func runJobQueries(ctx context.Context, job *Job, done int, fields cache.Fields, lang string) error {
+	err := truncateLines(jobFileName(job.ID, "results"), done)
+	if err != nil {
+		return err
+	}
+
+	queriesFile, err := os.Open(jobFileName(job.ID, "queries"))
+	if err != nil {
+		return err
+	}
+	defer queriesFile.Close()
+
+	resultsFile, err := os.OpenFile(jobFileName(job.ID, "results"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer resultsFile.Close()
+
+	scanner := bufio.NewScanner(queriesFile)
+	scanner.Buffer(make([]byte, 4096), maxStreamLineLength)
+	for skipped := 0; skipped < done && scanner.Scan(); skipped++ {
+	}
+
+	for ctx.Err() == nil {
+		var batch []streamQuery
+		for len(batch) < maxBatchQueries && scanner.Scan() {
+			query, err := parseStreamLine(scanner.Text(), fields, lang)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, *query)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		results, cached, fetched, err := lookupStreamQueries(ctx, batch, LoadedConfig.APIKey, jobRetries)
+		if err != nil {
+			return err
+		}
+
+		var lines []byte
+		failed := 0
+		for i := range results {
+			if results[i].Status == "fail" {
+				failed++
+			}
+			line, _ := json.Marshal(&results[i])
+			lines = append(append(lines, line...), '\n')
+		}
+
+		//results are appended under jobsMutex, so a job deleted during the batch stays deleted
+		jobsMutex.Lock()
+		if jobs[job.ID] != job {
+			removeJobFiles(job.ID)
+			jobsMutex.Unlock()
+			return nil
+		}
+		_, err = resultsFile.Write(lines)
+		if err != nil {
+			jobsMutex.Unlock()
+			return err
+		}
+		job.Done += len(results)
+		job.Cached += cached
+		job.Fetched += fetched
+		job.Failed += failed
+		job.Updated = time.Now().UTC()
+		err = saveJob(job)
+		jobsMutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//listJobs - writes the status of every job of a client
+func listJobs(w http.ResponseWriter, clientID string) {
+	jobsMutex.Lock()
+	jobList := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if job.Client == clientID {
+			jobList = append(jobList, jobStatus(job))
+		}
+	}
+	jobsMutex.Unlock()
+
+	sort.Slice(jobList, func(i, j int) bool {
+		return jobList[i].Created.Before(jobList[j].Created)
+	})
+
+	promMetrics.IncrementHandlerRequests("200")
+	body, _ := json.Marshal(jobList)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+//writeJobStatus - writes a job's status, with its ETA
+func writeJobStatus(w http.ResponseWriter, id string, clientID string) {
+	jobsMutex.Lock()
+	job, ok := clientJob(id, clientID)
+	var status Job
+	if ok {
+		status = jobStatus(job)
+	}
+	jobsMutex.Unlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job "+id+" not found")
+		return
+	}
+	writeJob(w, http.StatusOK, &status)
+}
+
+/*
+jobStatus - copies a job and estimates its time left, must be called with jobsMutex held
+the estimate assumes every remaining query misses the cache, so it is an upper bound under a batch rate limit
+ */
+func jobStatus(job *Job) Job {
+	status := *job
+	if job.Status != jobQueued && job.Status != jobRunning {
+		return status
+	}
+
+	remaining := job.Total - job.Done
+	var eta time.Duration
+	if batchLimiter != nil && LoadedConfig.RateLimit.BatchRequests != nil && *LoadedConfig.RateLimit.BatchRequests > 0 {
+		batches := math.Ceil(float64(remaining) / maxBatchQueries)
+		eta = time.Duration(batches) * (*LoadedConfig.RateLimit.IntervalDuration / time.Duration(*LoadedConfig.RateLimit.BatchRequests))
+	} else if elapsed := time.Since(maxTime(job.Created, jobsStarted)); job.Done > 0 && elapsed > 0 {
+		//without a rate limit, go by how fast the job has been so far
+		eta = time.Duration(float64(elapsed) / float64(job.Done) * float64(remaining))
+	}
+	status.ETA = eta.Round(time.Second).String()
+	return status
+}
+
+/*
+clientJob - gets a job submitted by a client, must be called with jobsMutex held
+other clients' jobs aren't found, so that job ids can't be probed
+id - job id
+clientID - id of the client, empty when no clients are configured
+ */
+func clientJob(id string, clientID string) (*Job, bool) {
+	job, ok := jobs[id]
+	if !ok || job.Client != clientID {
+		return nil, false
+	}
+	return job, true
+}
+
+//maxTime - gets the later of two times
+func maxTime(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+/*
+writeJobResults - writes the results of a job in the negotiated format, streamed from its results file
+the results of a job that hasn't finished yet are the results so far, X-Job-Status tells them apart
+ */
+func writeJobResults(w http.ResponseWriter, r *http.Request, id string, clientID string) {
+	jobsMutex.Lock()
+	job, ok := clientJob(id, clientID)
+	var status Job
+	if ok {
+		status = *job
+	}
+	jobsMutex.Unlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job "+id+" not found")
+		return
+	}
+
+	options, err := negotiatedOptions(r)
+	if err == nil {
+		err = parseOutputParams(r.URL.Query(), &options)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	options.fields, _ = cache.ParseFields(status.Fields)
+	if status.Fields != "" {
+		options.fieldOrder = requestedFieldOrder(status.Fields, options.fields)
+	}
+
+	//results are counted first, as msgpack arrays start with their length
+	total, err := countLines(jobFileName(id, "results"), status.Done)
+	if err != nil {
+		log.Println("error: reading job " + id + " results: " + err.Error())
+		writeJSONError(w, http.StatusInternalServerError, "error reading job results: "+err.Error())
+		return
+	}
+
+	promMetrics.IncrementHandlerRequests("200")
+	w.Header().Set("X-Job-Status", status.Status)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.`+options.format+`"`)
+	err = streamLocations(w, http.StatusOK, total, func(write func(location *ip_api.Location) error) error {
+		return readJobResults(id, total, write)
+	}, options)
+	if err != nil {
+		log.Println("error: writing job " + id + " results: " + err.Error())
+	}
+}
+
+/*
+readJobResults - decodes the first results of a job one at a time
+id - job id
+total - number of results to read
+write - called with each result
+ */
+func readJobResults(id string, total int, write func(location *ip_api.Location) error) error {
+	file, err := os.Open(jobFileName(id, "results"))
+	if os.IsNotExist(err) && total == 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for read := 0; read < total; read++ {
+		var location ip_api.Location
+		err = decoder.Decode(&location)
+		if err == io.EOF {
+			return errors.New("results file has " + strconv.Itoa(read) + " of " + strconv.Itoa(total) + " results")
+		} else if err != nil {
+			return err
+		}
+		err = write(&location)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//deleteJob - cancels a job and deletes its files
+func deleteJob(w http.ResponseWriter, id string, clientID string) {
+	jobsMutex.Lock()
+	job, ok := clientJob(id, clientID)
+	if ok {
+		if cancel, running := jobCancels[id]; running {
+			cancel()
+		}
+		job.Status = jobCancelled
+		delete(jobs, id)
+		removeJobFiles(id)
+	}
+	jobsMutex.Unlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job "+id+" not found")
+		return
+	}
+
+	log.Println("Deleted job " + id)
+	writeJob(w, http.StatusOK, job)
+}
+
+//writeJob - writes a job as json
+func writeJob(w http.ResponseWriter, statusCode int, job *Job) {
+	promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+	body, _ := json.Marshal(job)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+//newJobID - creates a random job id
+func newJobID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/*
+saveJob - persists a job, must be called with jobsMutex held
+the job is written to a temporary file first, so a crash can't leave a half written job behind
+ */
+func saveJob(job *Job) error {
+	jobBytes, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	fileName := jobFileName(job.ID, "json")
+	err = ioutil.WriteFile(fileName+".tmp", jobBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+//readJob - reads a persisted job
+func readJob(fileName string) (*Job, error) {
+	jobBytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	err = json.Unmarshal(jobBytes, &job)
+	if err != nil {
+		return nil, err
+	}
+	if job.ID == "" {
+		return nil, errors.New("job has no id")
+	}
+	return &job, nil
+}
+
+//removeJobFiles - deletes every file of a job
+func removeJobFiles(id string) {
+	for _, ext := range []string{"json", "queries", "results"} {
+		_ = os.Remove(jobFileName(id, ext))
+	}
+}
+
+/*
+countLines - counts the lines of a file, up to max lines, a missing file has none
+fileName - file to count the lines of
+max - most lines to count
+ */
+func countLines(fileName string, max int) (int, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	lines := 0
+	for lines < max {
+		_, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		lines++
+	}
+	return lines, nil
+}
+
+/*
+truncateLines - cuts a file down to its first lines lines, a missing file is left missing
+fileName - file to truncate
+lines - number of lines to keep
+ */
+func truncateLines(fileName string, lines int) error {
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for i := 0; i < lines; i++ {
+		line, err := reader.ReadBytes('\n')
+		offset += int64(len(line))
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return file.Truncate(offset)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//setupJobs - persists jobs in a temporary directory, with cached locations only so IP-API is never called
+func setupJobs(t *testing.T) {
+	LoadedConfig.Cache.WriteLocation = t.TempDir() + "/"
+	LoadedConfig.Jobs.MaxQueries = 100
+	jobsMutex.Lock()
+	jobs = map[string]*Job{}
+	jobsStopped = false
+	jobsMutex.Unlock()
+
+	for _, ip := range []string{"8.8.8.8", "1.1.1.1"} {
+		if _, err := cache.AddLocation(ip, ip_api.Location{Status: "success", Country: "United States", Query: ip}, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		cache.DeleteLocation("8.8.8.8")
+		cache.DeleteLocation("1.1.1.1")
+		LoadedConfig.Cache.WriteLocation = ""
+		LoadedConfig.Clients = nil
+	})
+}
+
+//jobRequest - sends a request to jobsHandler, as a client when clientID isn't empty
+func jobRequest(method string, path string, body string, clientID string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if clientID != "" {
+		r.SetBasicAuth(clientID, clientID+"-key")
+	}
+	w := httptest.NewRecorder()
+	jobsHandler(w, r)
+	return w
+}
+
+//waitForJob - waits until a job has a status, and gets a copy of it
+func waitForJob(t *testing.T, id string, status string) Job {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobsMutex.Lock()
+		job, ok := jobs[id]
+		var current Job
+		if ok {
+			current = *job
+		}
+		jobsMutex.Unlock()
+		if ok && current.Status == status {
+			return current
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got job %+v, expected status %s", current, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmitJob(t *testing.T) {
+	setupJobs(t)
+	startJobs()
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+		total      int
+	}{
+		{"query lines", "8.8.8.8\n\n1.1.1.1\n", http.StatusAccepted, 2},
+		{"batch query objects", `{"query":"8.8.8.8"}` + "\n" + `{"query":"1.1.1.1","fields":"status,country,query"}`, http.StatusAccepted, 2},
+		{"no queries", "\n\n", http.StatusBadRequest, 0},
+		{"blank query", "8.8.8.8\n" + `{"query":""}`, http.StatusBadRequest, 0},
+		{"invalid lang", `{"query":"8.8.8.8","lang":"xx"}`, http.StatusBadRequest, 0},
+		{"invalid query object", `{"query":`, http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := jobRequest("POST", "/jobs", test.body, "")
+			if w.Code != test.statusCode {
+				t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+			if test.statusCode != http.StatusAccepted {
+				return
+			}
+
+			var job Job
+			if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+				t.Fatal(err)
+			}
+			if w.Header().Get("Location") != "/jobs/"+job.ID {
+				t.Errorf("got Location %s, expected /jobs/%s", w.Header().Get("Location"), job.ID)
+			}
+			if job.Total != test.total {
+				t.Errorf("got %d queries, expected %d", job.Total, test.total)
+			}
+
+			done := waitForJob(t, job.ID, jobDone)
+			if done.Done != test.total || done.Cached != test.total || done.Fetched != 0 || done.Failed != 0 {
+				t.Errorf("got done %d, cached %d, fetched %d and failed %d, expected %d cached", done.Done, done.Cached, done.Fetched, done.Failed, test.total)
+			}
+
+			w = jobRequest("GET", "/jobs/"+job.ID+"/results", "", "")
+			var results []ip_api.Location
+			if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+				t.Fatalf("got results %q: %v", w.Body.String(), err)
+			}
+			if len(results) != test.total || results[0].Query != "8.8.8.8" || results[1].Query != "1.1.1.1" {
+				t.Errorf("got results %+v, expected 8.8.8.8 and 1.1.1.1 in query order", results)
+			}
+			if w.Header().Get("X-Job-Status") != jobDone {
+				t.Errorf("got X-Job-Status %s, expected %s", w.Header().Get("X-Job-Status"), jobDone)
+			}
+		})
+	}
+}
+
+func TestResumeJobs(t *testing.T) {
+	setupJobs(t)
+	if err := os.MkdirAll(jobsDirectory(), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	//a job which was running when the proxy stopped, after its first result was saved and while its second was written
+	job := &Job{ID: "resumed", Status: jobRunning, Created: time.Now().UTC(), Total: 2, Done: 1, Cached: 1}
+	if err := saveJob(job); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"queries": `{"query":"8.8.8.8"}` + "\n" + `{"query":"1.1.1.1"}` + "\n",
+		"results": `{"status":"success","query":"8.8.8.8"}` + "\n" + `{"status":"succ`,
+	}
+	for ext, content := range files {
+		if err := ioutil.WriteFile(jobFileName(job.ID, ext), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//a finished job is loaded but not run again
+	finished := &Job{ID: "finished", Status: jobDone, Created: time.Now().UTC(), Total: 1, Done: 1}
+	if err := saveJob(finished); err != nil {
+		t.Fatal(err)
+	}
+
+	startJobs()
+
+	resumed := waitForJob(t, job.ID, jobDone)
+	if resumed.Done != 2 || resumed.Cached != 2 {
+		t.Errorf("got done %d and cached %d, expected 2 and 2", resumed.Done, resumed.Cached)
+	}
+	results, err := ioutil.ReadFile(jobFileName(job.ID, "results"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(results), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"query":"1.1.1.1"`) {
+		t.Errorf("got results %q, expected the half written result replaced by 1.1.1.1", results)
+	}
+
+	if loaded := waitForJob(t, finished.ID, jobDone); loaded.Done != 1 {
+		t.Errorf("got finished job %+v, expected it loaded as is", loaded)
+	}
+}
+
+func TestJobsClientScoping(t *testing.T) {
+	setupJobs(t)
+	LoadedConfig.Clients = []config.Client{{ID: "a", Key: "a-key"}, {ID: "b", Key: "b-key"}}
+	startJobs()
+
+	w := jobRequest("POST", "/jobs", "8.8.8.8\n", "a")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), http.StatusAccepted)
+	}
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Client != "a" {
+		t.Errorf("got client %s, expected a", job.Client)
+	}
+	waitForJob(t, job.ID, jobDone)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		clientID   string
+		statusCode int
+	}{
+		{"no credentials", "GET", "/jobs", "", http.StatusUnauthorized},
+		{"unknown client", "GET", "/jobs", "c", http.StatusUnauthorized},
+		{"owner status", "GET", "/jobs/" + job.ID, "a", http.StatusOK},
+		{"owner results", "GET", "/jobs/" + job.ID + "/results", "a", http.StatusOK},
+		{"other client status", "GET", "/jobs/" + job.ID, "b", http.StatusNotFound},
+		{"other client results", "GET", "/jobs/" + job.ID + "/results", "b", http.StatusNotFound},
+		{"other client delete", "DELETE", "/jobs/" + job.ID, "b", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := jobRequest(test.method, test.path, "", test.clientID)
+			if w.Code != test.statusCode {
+				t.Errorf("got status %d %s, expected %d", w.Code, w.Body.String(), test.statusCode)
+			}
+		})
+	}
+
+	for clientID, expected := range map[string]int{"a": 1, "b": 0} {
+		var jobList []Job
+		w := jobRequest("GET", "/jobs", "", clientID)
+		if err := json.Unmarshal(w.Body.Bytes(), &jobList); err != nil {
+			t.Fatal(err)
+		}
+		if len(jobList) != expected {
+			t.Errorf("got %d jobs listed for client %s, expected %d", len(jobList), clientID, expected)
+		}
+	}
+}
+
+func TestDeleteJob(t *testing.T) {
+	setupJobs(t)
+	startJobs()
+
+	w := jobRequest("POST", "/jobs", "8.8.8.8\n1.1.1.1\n", "")
+	var job Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	waitForJob(t, job.ID, jobDone)
+
+	w = jobRequest("DELETE", "/jobs/"+job.ID, "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+	var deleted Job
+	if err := json.Unmarshal(w.Body.Bytes(), &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Status != jobCancelled {
+		t.Errorf("got status %s, expected %s", deleted.Status, jobCancelled)
+	}
+
+	for _, ext := range []string{"json", "queries", "results"} {
+		if _, err := os.Stat(jobFileName(job.ID, ext)); !os.IsNotExist(err) {
+			t.Errorf("got %s file still there (%v), expected it deleted", ext, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"status", "GET", "/jobs/" + job.ID},
+		{"results", "GET", "/jobs/" + job.ID + "/results"},
+		{"delete again", "DELETE", "/jobs/" + job.ID},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if w := jobRequest(test.method, test.path, "", ""); w.Code != http.StatusNotFound {
+				t.Errorf("got status %d, expected %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+//enrichCommand - name of the log enrichment subcommand
+const enrichCommand = "enrich"
+
+//enrichChunkLines - log lines read before their ips are looked up, so a chunk's cache misses share batch requests
+const enrichChunkLines = 1000
+
+//maxEnrichLineLength - longest log line accepted
+const maxEnrichLineLength = 1024 * 1024
+
+//defaultEnrichKey - key the lookup of an ip path is written under, {path} is replaced with the path (dots become underscores)
+const defaultEnrichKey = "{path}_geo"
+
+//Shapes of the enrichment written into a log line
+const (
+	shapeIPAPI = "ip-api"
+	shapeECS   = "ecs"
+)
+
+/*
+enrichPath - an ip path of a log line and where its lookup is written
+path - dotted json path of the ip (ex: src_ip, id.orig_h)
+key - dotted json path the lookup is written under (ex: source, src_ip_geo)
+ */
+type enrichPath struct {
+	path string
+	key  string
+}
+
+/*
+logEnrichOptions - options of a log enrichment
+paths - ip paths
+fields - fields of the lookups
+lang - lang the ips are looked up in
+key - IP-API key
+output - shape of the lookups, ecs, profile or ip-api
+ */
+type logEnrichOptions struct {
+	paths  []enrichPath
+	fields cache.Fields
+	lang   string
+	key    string
+	output outputOptions
+}
+
+/*
+runEnrichCommand - runs the enrich subcommand, which adds lookups of the ips in NDJSON logs (ex: Zeek, Suricata EVE, nginx json) to every line
+ip-api-proxy enrich --paths=src_ip,dest_ip [--config=config.json] [file ...]
+the persisted cache is read first and written back afterwards (if persist is enabled), so the proxy and enrich runs share their lookups
+args - arguments after the subcommand
+ */
+func runEnrichCommand(args []string) error {
+	flags := flag.NewFlagSet(enrichCommand, flag.ContinueOnError)
+	configLocation := flags.String("config", "", "Configuration file location. Defaults to working directory.")
+	paths := flags.String("paths", "", "Comma separated json paths of the ips to look up (ex: src_ip,dest_ip or id.orig_h). A path can name the key its lookup is written under (ex: src_ip=source).")
+	key := flags.String("key", defaultEnrichKey, "Key the lookups are written under, {path} is replaced with the ip path (dots become underscores). Dots nest the key.")
+	shape := flags.String("shape", shapeIPAPI, "Shape of the lookups, ip-api or ecs.")
+	profile := flags.String("profile", "", "Output profile of the lookups, instead of a shape.")
+	fields := flags.String("fields", "", "Fields of the lookups. Defaults to the default fields.")
+	lang := flags.String("lang", "", "Lang the ips are looked up in.")
+	output := flags.String("output", "", "File the enriched NDJSON is written to. Defaults to stdout.")
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = loadConfig(*configLocation)
+	if err != nil {
+		return err
+	}
+
+	options, err := parseLogEnrichOptions(*paths, *key, *shape, *profile, *fields, *lang)
+	if err != nil {
+		return err
+	}
+
+	writer := os.Stdout
+	if *output != "" && *output != "-" {
+		writer, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer writer.Close()
+	}
+
+	initRateLimiters()
+	cache.ReadCache(&LoadedConfig.Cache.WriteLocation)
+
+	var stats EnrichStats
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	for _, input := range inputs {
+		reader := os.Stdin
+		if input != "-" {
+			reader, err = os.Open(input)
+			if err != nil {
+				return err
+			}
+		}
+		err = enrichLogs(context.Background(), reader, writer, options, &stats)
+		_ = reader.Close()
+		if err != nil {
+			return errors.New(input + ": " + err.Error())
+		}
+	}
+	logEnrichStats("log lines", stats)
+
+	//share the lookups with the proxy and later runs
+	if LoadedConfig.Cache.Persist {
+		if err := cache.WriteCache(&LoadedConfig.Cache.WriteLocation); err != nil {
+			log.Println("error: writing cache: " + err.Error())
+		}
+	}
+	return nil
+}
+
+/*
+parseLogEnrichOptions - validates the options of the enrich subcommand
+paths - comma separated ip paths, each optionally followed by =key
+key - default key template of the paths
+shape - ip-api or ecs
+profile - output profile name, empty for the shape
+fields - fields parameter
+lang - lang parameter
+ */
+func parseLogEnrichOptions(paths string, key string, shape string, profile string, fields string, lang string) (logEnrichOptions, error) {
+	options := logEnrichOptions{key: LoadedConfig.APIKey, output: outputOptions{format: formatJSON}}
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		enrichPath := enrichPath{path: path, key: strings.ReplaceAll(key, "{path}", strings.ReplaceAll(path, ".", "_"))}
+		if pathParts := strings.SplitN(path, "=", 2); len(pathParts) == 2 {
+			enrichPath.path = strings.TrimSpace(pathParts[0])
+			enrichPath.key = strings.TrimSpace(pathParts[1])
+		}
+		if enrichPath.path == "" || enrichPath.key == "" {
+			return options, errors.New("invalid path provided: " + path)
+		}
+		options.paths = append(options.paths, enrichPath)
+	}
+	if len(options.paths) == 0 {
+		return options, errors.New("no paths provided, expected the json paths of the ips (ex: src_ip,dest_ip)")
+	}
+
+	//the shape maps onto the ecs and profile parameters of the http endpoints
+	outputParams := url.Values{}
+	switch shape {
+	case shapeIPAPI:
+	case shapeECS:
+		//the ECS fields go straight under the key (ex: source.geo, source.as)
+		outputParams.Set("ecs", ecsNested)
+	default:
+		return options, errors.New("invalid shape provided, expected one of: " + shapeIPAPI + "," + shapeECS)
+	}
+	outputParams.Set("profile", profile)
+	err := parseOutputParams(outputParams, &options.output)
+	if err != nil {
+		return options, err
+	}
+
+	options.fields, err = cache.ParseFields(fields)
+	if err != nil {
+		return options, err
+	}
+	if options.fields == 0 && options.output.profile != nil {
+		options.fields = options.output.profile.RequiredFields()
+	}
+	options.output.fields = options.fields
+
+	if lang != "" {
+		options.lang, err = ip_api.ValidateLang(lang)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+/*
+enrichLogs - adds the lookups of the ips in NDJSON log lines to every line
+lines which aren't json objects, or have none of the ip paths, are written unchanged
+ctx - cancels the lookups
+reader - NDJSON input
+writer - NDJSON output, in input order
+options - logEnrichOptions
+stats - EnrichStats to count the lines and lookups in
+ */
+func enrichLogs(ctx context.Context, reader io.Reader, writer io.Writer, options logEnrichOptions, stats *EnrichStats) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 4096), maxEnrichLineLength)
+	bufferedWriter := bufio.NewWriter(writer)
+
+	for {
+		//read a chunk of lines
+		var lines [][]byte
+		for len(lines) < enrichChunkLines && scanner.Scan() {
+			lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return bufferedWriter.Flush()
+		}
+		stats.Rows += len(lines)
+
+		//parse the lines and collect their unique ips
+		records := make([]map[string]interface{}, len(lines))
+		var queries []string
+		seen := map[string]bool{}
+		for i, line := range lines {
+			records[i] = parseLogLine(line)
+			if records[i] == nil {
+				continue
+			}
+			for _, path := range options.paths {
+				if query := jsonPathString(records[i], path.path); query != "" && !seen[query] {
+					seen[query] = true
+					queries = append(queries, query)
+				}
+			}
+		}
+		stats.Queries += len(queries)
+
+		locations, err := lookupQueries(ctx, queries, options.fields, options.lang, options.key, stats)
+		if err != nil {
+			return err
+		}
+
+		for i, line := range lines {
+			if records[i] != nil {
+				enriched := false
+				for _, path := range options.paths {
+					location := locations[jsonPathString(records[i], path.path)]
+					if location == nil {
+						continue
+					}
+					setJSONPath(records[i], path.key, logOutput(location, options.output))
+					enriched = true
+				}
+				if enriched {
+					line, err = marshalLogLine(records[i])
+					if err != nil {
+						return err
+					}
+				}
+			}
+			_, _ = bufferedWriter.Write(line)
+			err = bufferedWriter.WriteByte('\n')
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//parseLogLine - parses a log line as a json object, numbers are kept as written, nil if it isn't one
+func parseLogLine(line []byte) map[string]interface{} {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	var record map[string]interface{}
+	if decoder.Decode(&record) != nil {
+		return nil
+	}
+	return record
+}
+
+//marshalLogLine - writes a log line back as json, without escaping html characters like json.Marshal
+func marshalLogLine(record map[string]interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(record)
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), err
+}
+
+/*
+logOutput - converts a location into the generic json value written into a log line
+location - ip_api location, projected to the requested fields
+options - outputOptions of the enrichment
+ */
+func logOutput(location *ip_api.Location, options outputOptions) interface{} {
+	outputBytes, _ := json.Marshal(toOutput(location, options))
+	decoder := json.NewDecoder(bytes.NewReader(outputBytes))
+	decoder.UseNumber()
+	var output interface{}
+	_ = decoder.Decode(&output)
+	return output
+}
+
+/*
+jsonPathString - gets the string value at a dotted json path
+keys containing dots are matched as is first, so both Zeek's flat "id.orig_h" and nested {"id": {"orig_h": ...}} work
+record - json object
+path - dotted path
+
+returns
+trimmed string value, empty if the path doesn't exist or isn't a string
+ */
+func jsonPathString(record map[string]interface{}, path string) string {
+	if value, ok := record[path]; ok {
+		stringValue, _ := value.(string)
+		return strings.TrimSpace(stringValue)
+	}
+	for i := strings.Index(path, "."); i != -1; i = nextDot(path, i) {
+		if child, ok := record[path[:i]].(map[string]interface{}); ok {
+			if value := jsonPathString(child, path[i+1:]); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+//nextDot - index of the next dot of a path after index i, -1 if there is none
+func nextDot(path string, i int) int {
+	next := strings.Index(path[i+1:], ".")
+	if next == -1 {
+		return -1
+	}
+	return i + 1 + next
+}
+
+/*
+setJSONPath - sets the value at a dotted json path, creating objects along the way
+objects already at the path are merged with the value, so an enrichment under source keeps source.ip
+record - json object
+path - dotted path
+value - generic json value
+ */
+func setJSONPath(record map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	object := record
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := object[part].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			object[part] = child
+		}
+		object = child
+	}
+
+	last := parts[len(parts)-1]
+	existing, existingOk := object[last].(map[string]interface{})
+	valueObject, valueOk := value.(map[string]interface{})
+	if existingOk && valueOk {
+		mergeJSONObjects(existing, valueObject)
+		return
+	}
+	object[last] = value
+}
+
+//mergeJSONObjects - merges src into dst, nested objects are merged and other values of src replace those of dst
+func mergeJSONObjects(dst map[string]interface{}, src map[string]interface{}) {
+	for key, value := range src {
+		dstObject, dstOk := dst[key].(map[string]interface{})
+		srcObject, srcOk := value.(map[string]interface{})
+		if dstOk && srcOk {
+			mergeJSONObjects(dstObject, srcObject)
+		} else {
+			dst[key] = value
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogEnrichPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		paths    string
+		key      string
+		expected []enrichPath
+		err      string
+	}{
+		{"default key", "src_ip", defaultEnrichKey, []enrichPath{{path: "src_ip", key: "src_ip_geo"}}, ""},
+		{"dots become underscores in the key", "id.orig_h", defaultEnrichKey, []enrichPath{{path: "id.orig_h", key: "id_orig_h_geo"}}, ""},
+		{"key template", "src_ip,dest_ip", "geo.{path}", []enrichPath{{path: "src_ip", key: "geo.src_ip"}, {path: "dest_ip", key: "geo.dest_ip"}}, ""},
+		{"explicit key", "src_ip=source, dest_ip", defaultEnrichKey, []enrichPath{{path: "src_ip", key: "source"}, {path: "dest_ip", key: "dest_ip_geo"}}, ""},
+		{"empty paths are skipped", "src_ip,,", defaultEnrichKey, []enrichPath{{path: "src_ip", key: "src_ip_geo"}}, ""},
+		{"no paths", " , ", defaultEnrichKey, nil, "no paths provided, expected the json paths of the ips (ex: src_ip,dest_ip)"},
+		{"empty explicit key", "src_ip=", defaultEnrichKey, nil, "invalid path provided: src_ip="},
+		{"empty path", "=source", defaultEnrichKey, nil, "invalid path provided: =source"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := parseLogEnrichOptions(test.paths, test.key, shapeIPAPI, "", "", "")
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(options.paths, test.expected) {
+				t.Errorf("got %+v, expected %+v", options.paths, test.expected)
+			}
+		})
+	}
+}
+
+func TestJSONPathString(t *testing.T) {
+	record := parseLogLine([]byte(`{"src_ip":" 8.8.8.8 ","id.orig_h":"1.1.1.1","id":{"resp_h":"9.9.9.9"},"source":{"address":{"ip":"8.8.4.4"}},"port":53,"a.b":{"c":"2.2.2.2"}}`))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"src_ip", "8.8.8.8"},
+		{"id.orig_h", "1.1.1.1"},
+		{"id.resp_h", "9.9.9.9"},
+		{"source.address.ip", "8.8.4.4"},
+		{"a.b.c", "2.2.2.2"},
+		{"port", ""},
+		{"source.address", ""},
+		{"missing", ""},
+		{"id.missing", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if value := jsonPathString(record, test.path); value != test.expected {
+				t.Errorf("got %q, expected %q", value, test.expected)
+			}
+		})
+	}
+}
+
+func TestEnrichLogs(t *testing.T) {
+	//only cached ips are enriched, so IP-API is never called
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Country: "United States", CountryCode: "US", AS: "AS15169 Google LLC", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddLocation("10.0.0.1", ip_api.Location{Status: "fail", Message: "private range", Query: "10.0.0.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cache.DeleteLocation("8.8.8.8")
+		cache.DeleteLocation("10.0.0.1")
+	}()
+
+	tests := []struct {
+		name     string
+		paths    string
+		shape    string
+		fields   string
+		input    string
+		expected string
+	}{
+		{
+			"ip-api shape keeps numbers as written",
+			"src_ip", shapeIPAPI, "countryCode,as",
+			`{"src_ip":"8.8.8.8","bytes":12345678901234567890}`,
+			`{"bytes":12345678901234567890,"src_ip":"8.8.8.8","src_ip_geo":{"as":"AS15169 Google LLC","countryCode":"US"}}`,
+		},
+		{
+			"ecs shape merges into an existing object",
+			"source.ip=source", shapeECS, "countryCode,as",
+			`{"source":{"ip":"8.8.8.8","port":443}}`,
+			`{"source":{"as":{"number":15169,"organization":{"name":"Google LLC"}},"geo":{"country_iso_code":"US"},"ip":"8.8.8.8","port":443}}`,
+		},
+		{
+			"failed lookup",
+			"src_ip", shapeIPAPI, "status,message,countryCode",
+			`{"src_ip":"10.0.0.1"}`,
+			`{"src_ip":"10.0.0.1","src_ip_geo":{"message":"private range","query":"10.0.0.1","status":"fail"}}`,
+		},
+		{
+			"lines without the path are unchanged",
+			"src_ip", shapeIPAPI, "countryCode",
+			`{"dest_ip": "8.8.8.8", "msg": "<b>"}`,
+			`{"dest_ip": "8.8.8.8", "msg": "<b>"}`,
+		},
+		{
+			"lines which aren't json objects are unchanged",
+			"src_ip", shapeIPAPI, "countryCode",
+			"#fields\tsrc_ip\n[\"8.8.8.8\"]",
+			"#fields\tsrc_ip\n[\"8.8.8.8\"]",
+		},
+		{
+			"html characters aren't escaped",
+			"src_ip", shapeIPAPI, "countryCode",
+			`{"src_ip":"8.8.8.8","msg":"<b>&"}`,
+			`{"msg":"<b>&","src_ip":"8.8.8.8","src_ip_geo":{"countryCode":"US"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options, err := parseLogEnrichOptions(test.paths, defaultEnrichKey, test.shape, "", test.fields, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var output bytes.Buffer
+			var stats EnrichStats
+			if err = enrichLogs(context.Background(), strings.NewReader(test.input), &output, options, &stats); err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.expected+"\n" {
+				t.Errorf("got %s, expected %s", output.String(), test.expected)
+			}
+		})
+	}
+}
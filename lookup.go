@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//maxBatchQueries - most queries IP-API accepts in a single batch request
+const maxBatchQueries = 100
+
+//maxPendingLookups - most lookups run in the background by lookupWithinBudget, further misses aren't looked up
+const maxPendingLookups = 1000
+
+//Results of lookupWithinBudget
+const (
+	budgetCached  = "cached"
+	budgetFetched = "fetched"
+	budgetTimeout = "timeout"
+	budgetSkipped = "skipped"
+	budgetFailed  = "failed"
+)
+
+//Lookups of lookupWithinBudget still running, so concurrent misses of an ip share a single lookup
+var (
+	pendingLookups      = map[string]chan struct{}{}
+	pendingLookupsMutex sync.Mutex
+)
+
+//Providers locations are looked up with, the free IP-API or IP-API Pro when a key is used
+const (
+	providerIPAPI    = "ip-api"
+	providerIPAPIPro = "ip-api-pro"
+)
+
+//apiProvider - gets the provider queries are looked up with, IP-API Pro if a key is used
+func apiProvider(key string) string {
+	if key != "" {
+		return providerIPAPIPro
+	}
+	return providerIPAPI
+}
+
+/*
+cacheLocation - caches a location looked up with IP-API under query + lang, failed queries go stale sooner
+query - IP/DNS entry
+lang - lang the location was looked up in, "" for IP-API's default
+location - ip_api location, with all fields
+key - IP-API key the location was looked up with
+ */
+func cacheLocation(query string, lang string, location ip_api.Location, key string) error {
+	expirationDuration := *LoadedConfig.Cache.SuccessAgeDuration
+	if location.Status != "success" {
+		expirationDuration = *LoadedConfig.Cache.FailedAgeDuration
+	}
+
+	_, err := cache.AddProvidedLocation(query, lang, location, expirationDuration, apiProvider(key))
+	return err
+}
+
+/*
+lookupLocation - gets the location of a query from cache, or from IP-API if it isn't cached and then caches it
+ctx - context of the request, waiting for the rate limit stops when it is done
+query - IP/DNS entry
+lang - validated lang, "" for IP-API's default
+fields - Fields to return, cache.DefaultFields if 0
+key - IP-API key, "" for the free API
+
+returns
+ip_api Location
+true if the location was found in cache
+error
+ */
+func lookupLocation(ctx context.Context, query string, lang string, fields cache.Fields, key string) (*ip_api.Location, bool, error) {
+	return lookupControlledLocation(ctx, query, lang, fields, key, cacheControl{})
+}
+
+/*
+lookupWithinBudget - gets the location of an ip from cache, or looks it up with IP-API and waits for it up to a budget
+a lookup that takes longer than the budget keeps running in the background, so the next request for the ip finds it in cache
+ip - ip to look up, in IP-API's default lang
+fields - Fields to return
+budget - how long to wait for a lookup
+
+returns
+ip_api location, nil if it isn't known within the budget
+result - cached, fetched, timeout (still looking it up), skipped (too many lookups running) or failed
+ */
+func lookupWithinBudget(ip string, fields cache.Fields, budget time.Duration) (*ip_api.Location, string) {
+	location, found, err := cache.GetLocation(ip, fields)
+	if err != nil {
+		return nil, budgetFailed
+	} else if found {
+		promMetrics.IncrementCacheHits()
+		return location, budgetCached
+	}
+
+	//share a lookup still running for the ip, or start one
+	pendingLookupsMutex.Lock()
+	done, pending := pendingLookups[ip]
+	if !pending {
+		if len(pendingLookups) >= maxPendingLookups {
+			pendingLookupsMutex.Unlock()
+			return nil, budgetSkipped
+		}
+		done = make(chan struct{})
+		pendingLookups[ip] = done
+		go func() {
+			promMetrics.IncrementQueriesProcessed()
+			if _, _, err := lookupLocation(context.Background(), ip, "", cache.AllFields, LoadedConfig.APIKey); err != nil {
+				log.Println("Failed lookup of " + ip + ": " + err.Error())
+			}
+			pendingLookupsMutex.Lock()
+			delete(pendingLookups, ip)
+			pendingLookupsMutex.Unlock()
+			close(done)
+		}()
+	}
+	pendingLookupsMutex.Unlock()
+
+	timer := time.NewTimer(budget)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		return nil, budgetTimeout
+	}
+
+	location, found, err = cache.GetLocation(ip, fields)
+	if err != nil || !found {
+		return nil, budgetFailed
+	}
+	return location, budgetFetched
+}
+
+/*
+lookupControlledLocation - lookupLocation, with the query's cache control
+control - cacheControl of the query, nocache locations are looked up with IP-API but not cached
+ */
+func lookupControlledLocation(ctx context.Context, query string, lang string, fields cache.Fields, key string, control cacheControl) (*ip_api.Location, bool, error) {
+	//Check cache for query
+	location, found, err := cachedLocation(query + lang, fields, control)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	//If query found in cache return cached value
+	if found {
+		if LoadedConfig.Debugging {
+			log.Println("Found: " + query + " in cache.")
+		}
+		promMetrics.IncrementCacheHits()
+		return location, true, nil
+	}
+
+	//Build query
+	apiQuery := ip_api.Query{
+		Queries: []ip_api.QueryIP{
+			{Query: query},
+		},
+		Fields: strings.Join(ip_api.AllowedAPIFields, ","), //Execute query to IP API for all fields, handle field selection later
+		Lang:   lang,
+	}
+
+	//count the lookup until it is cached, so a shutdown waits for it
+	defer trackUpstreamLookup()()
+
+	//wait for the rate limit
+	err = waitLimiter(ctx, singleLimiter)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	//execute query
+	promMetrics.IncrementRequestsForwarded()
+	promMetrics.IncrementQueriesForwarded()
+	newLocation, err := ip_api.SingleQuery(apiQuery, key, "", LoadedConfig.Debugging)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	//nocache locations are returned without replacing the cached location
+	if control.noCache {
+		projected := cache.ProjectLocation(newLocation, fields)
+		return &projected, false, nil
+	}
+
+	//Add to cache, failed queries go stale sooner
+	err = cacheLocation(query, lang, *newLocation, key)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if LoadedConfig.Debugging {
+		log.Println("Added: " + query + lang + " to cache.")
+	}
+
+	//Re-get request with specified fields
+	location, found, err = cache.GetLocation(query + lang, fields)
+	if err != nil || !found {
+		if err != nil {
+			log.Println(err)
+		}
+		//fall back to the unprojected location if it couldn't be read back from cache
+		return newLocation, false, nil
+	}
+
+	return location, false, nil
+}
+
+/*
+fetchLocations - looks up a batch of queries with IP-API and caches the results, reverse DNS entries are added to successful IP queries
+queries - up to maxBatchQueries queries, query entries with a lang are cached under it
+lang - validated lang of queries without their own, "" for IP-API's default
+key - IP-API key, "" for the free API
+ctx - context, waiting for the rate limit stops when it is done
+
+returns
+ip_api locations with all fields, in the order of queries
+error
+ */
+func fetchLocations(ctx context.Context, queries []ip_api.QueryIP, lang string, key string) ([]ip_api.Location, error) {
+	//count the batch until it is cached, so a shutdown waits for it
+	defer trackUpstreamLookup()()
+
+	//wait for the rate limit
+	err := waitLimiter(ctx, batchLimiter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	//Build batch request, for all fields so that everything is stored in cache
+	batchQuery := ip_api.Query{
+		Queries: make([]ip_api.QueryIP, len(queries)),
+		Fields:  strings.Join(ip_api.AllowedAPIFields, ","),
+		Lang:    lang,
+	}
+	for i, query := range queries {
+		batchQuery.Queries[i] = ip_api.QueryIP{Query: query.Query, Lang: query.Lang}
+	}
+
+	promMetrics.IncrementRequestsForwarded()
+	for range queries {
+		promMetrics.IncrementQueriesForwarded()
+	}
+	locations, err := ip_api.BatchQuery(batchQuery, key, "", LoadedConfig.Debugging)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(locations) != len(queries) {
+		return nil, errors.New("error: IP-API returned " + strconv.Itoa(len(locations)) + " locations for " + strconv.Itoa(len(queries)) + " queries")
+	}
+
+	for i := range locations {
+		queryLang := queries[i].Lang
+		if queryLang == "" {
+			queryLang = lang
+		}
+
+		if locations[i].Status == "success" {
+			names, err := net.LookupAddr(locations[i].Query)
+			if len(names) > 0 && err == nil {
+				locations[i].Reverse = names[0]
+			}
+		}
+
+		//IP-API echoes the query back, so cache under the query that was asked for, failed queries go stale sooner
+		err = cacheLocation(queries[i].Query, queryLang, locations[i], key)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if LoadedConfig.Debugging {
+			log.Println("Added: " + queries[i].Query + queryLang + " to cache.")
+		}
+	}
+
+	return locations, nil
+}
+
+/*
+EnrichStats - counts of a csv or log enrichment
+Rows - csv rows or log lines read
+Queries - unique queries looked up
+Cached - unique queries answered from cache
+Fetched - unique queries looked up with IP-API
+Failed - unique queries which failed
+ */
+type EnrichStats struct {
+	Rows    int
+	Queries int
+	Cached  int
+	Fetched int
+	Failed  int
+}
+
+//logEnrichStats - logs the counts of an enrichment, rows names what was read (ex: csv rows)
+func logEnrichStats(rows string, stats EnrichStats) {
+	log.Println("Enriched " + strconv.Itoa(stats.Rows) + " " + rows + " with " + strconv.Itoa(stats.Queries) + " unique queries, " + strconv.Itoa(stats.Cached) + " cached, " + strconv.Itoa(stats.Fetched) + " fetched, " + strconv.Itoa(stats.Failed) + " failed")
+}
+
+/*
+lookupQueries - looks up unique queries from cache, and the misses from IP-API in batches of up to maxBatchQueries
+an IP-API error fails every query of its batch
+queries - unique queries
+fields - fields to project the locations to
+lang - lang the queries are looked up in
+key - IP-API key
+stats - EnrichStats to count the lookups in
+
+returns
+locations by query, projected to the requested fields
+error if ctx is done
+ */
+func lookupQueries(ctx context.Context, queries []string, fields cache.Fields, lang string, key string, stats *EnrichStats) (map[string]*ip_api.Location, error) {
+	locations := make(map[string]*ip_api.Location, len(queries))
+	var misses []ip_api.QueryIP
+	for _, query := range queries {
+		promMetrics.IncrementQueriesProcessed()
+		location, found, err := cache.GetLocation(query+lang, fields)
+		if err != nil {
+			log.Println(err)
+		}
+		if found {
+			promMetrics.IncrementCacheHits()
+			stats.Cached++
+			locations[query] = location
+			if location.Status == "fail" {
+				stats.Failed++
+			}
+		} else {
+			misses = append(misses, ip_api.QueryIP{Query: query})
+		}
+	}
+
+	for start := 0; start < len(misses); start += maxBatchQueries {
+		end := start + maxBatchQueries
+		if end > len(misses) {
+			end = len(misses)
+		}
+		batch := misses[start:end]
+
+		fetched, err := fetchLocations(ctx, batch, lang, key)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Println("Failed enrichment batch: " + err.Error())
+		}
+
+		for i, query := range batch {
+			var location ip_api.Location
+			if err != nil {
+				location = ip_api.Location{Status: "fail", Message: err.Error(), Query: query.Query}
+			} else if projected, found, _ := cache.GetLocation(query.Query+lang, fields); found {
+				location = *projected
+				stats.Fetched++
+			} else {
+				location = fetched[i]
+				stats.Fetched++
+			}
+
+			if location.Status == "fail" {
+				stats.Failed++
+				promMetrics.IncrementFailedQueries()
+			} else {
+				promMetrics.IncrementSuccessfulQueries()
+			}
+			locations[query.Query] = &location
+		}
+	}
+
+	return locations, nil
+}
+
+/*
+lookupStreamQueries - looks up queries with their own fields and lang from cache, and the misses from IP-API in batches of up to maxBatchQueries
+IP-API errors are retried, after which the misses of the batch are failed with the error
+batch - queries
+key - IP-API key
+retries - times a batch is retried after an IP-API error, 0 for none
+
+returns
+locations projected to each query's fields, in query order
+number of queries answered from cache
+number of queries looked up with IP-API
+error if ctx is done
+ */
+func lookupStreamQueries(ctx context.Context, batch []streamQuery, key string, retries int) ([]ip_api.Location, int, int, error) {
+	results := make([]ip_api.Location, len(batch))
+	var misses []int
+	for i, query := range batch {
+		promMetrics.IncrementQueriesProcessed()
+		location, found, err := cache.GetLocation(query.query.Query+query.lang, query.fields)
+		if err != nil {
+			log.Println(err)
+		}
+		if found {
+			promMetrics.IncrementCacheHits()
+			results[i] = *location
+		} else {
+			misses = append(misses, i)
+		}
+	}
+
+	for start := 0; start < len(misses); start += maxBatchQueries {
+		end := start + maxBatchQueries
+		if end > len(misses) {
+			end = len(misses)
+		}
+		batchMisses := misses[start:end]
+
+		queries := make([]ip_api.QueryIP, len(batchMisses))
+		for i, miss := range batchMisses {
+			queries[i] = batch[miss].query
+		}
+
+		var locations []ip_api.Location
+		var err error
+		for attempt := 0; attempt <= retries; attempt++ {
+			if attempt > 0 {
+				log.Println("Retrying batch after error: " + err.Error())
+				select {
+				case <-time.After(time.Duration(attempt) * 10 * time.Second):
+				case <-ctx.Done():
+					return nil, 0, 0, ctx.Err()
+				}
+			}
+			locations, err = fetchLocations(ctx, queries, "", key)
+			if err == nil || ctx.Err() != nil {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, 0, 0, ctx.Err()
+		}
+
+		for i, miss := range batchMisses {
+			query := batch[miss]
+			if err != nil {
+				results[miss] = ip_api.Location{Status: "fail", Message: err.Error(), Query: query.query.Query}
+			} else if location, found, _ := cache.GetLocation(query.query.Query+query.lang, query.fields); found {
+				results[miss] = *location
+			} else {
+				results[miss] = locations[i]
+			}
+
+			if results[miss].Status == "fail" {
+				promMetrics.IncrementFailedQueries()
+			} else {
+				promMetrics.IncrementSuccessfulQueries()
+			}
+		}
+	}
+
+	return results, len(batch) - len(misses), len(misses), nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"github.com/BenB196/ip-api-go-pkg"
 	"github.com/BenB196/ip-api-proxy/cache"
@@ -12,6 +13,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -27,25 +29,91 @@ var IPDNSRegexp = regexp.MustCompile(`(((25[0-5]|(2[0-4]|1?[0-9])?[0-9])\.){3}(2
 func main()  {
 	var err error
 
+	//run the log enrichment subcommand instead of the proxy
+	if len(os.Args) > 1 && os.Args[1] == enrichCommand {
+		if err := runEnrichCommand(os.Args[2:]); err != nil {
+			log.Fatalln("error: enrich: " + err.Error())
+		}
+		return
+	}
+
+	//run the csv enrichment subcommand instead of the proxy
+	if len(os.Args) > 1 && os.Args[1] == csvEnrichCommand {
+		if err := runCSVEnrichCommand(os.Args[2:]); err != nil {
+			log.Fatalln("error: enriching csv: " + err.Error())
+		}
+		return
+	}
+
 	//get config location flag
 	var configLocation string
 	flag.StringVar(&configLocation,"config","","Configuration file location. Defaults to working directory.")
 
+	//get mmdb export flags
+	var exportMMDBLocation string
+	var exportAggregate bool
+	flag.StringVar(&exportMMDBLocation,"exportMMDB","","Export the persisted cache to this MMDB file and exit.")
+	flag.BoolVar(&exportAggregate,"aggregate",false,"Aggregate identical hosts into the configured export prefixes when exporting.")
+
 	//Parse flags
 	flag.Parse()
 
 	//Read config
-	LoadedConfig, err = config.ReadConfig(configLocation)
+	err = loadConfig(configLocation)
 
 	if err != nil {
 		panic(err)
 	}
 
+	//export the persisted cache and exit
+	if exportMMDBLocation != "" {
+		cache.ReadCache(&LoadedConfig.Cache.WriteLocation)
+		if err := exportMMDBFile(exportMMDBLocation, exportAggregate); err != nil {
+			log.Fatalln("error: exporting mmdb: " + err.Error())
+		}
+		return
+	}
+
 	//handle single requests
-	http.HandleFunc("/json/",ipAPIJson)
+	http.HandleFunc("/json/",corsHandler(ipAPIJson))
+	http.HandleFunc("/xml/",corsHandler(ipAPIXml))
+	http.HandleFunc("/csv/",corsHandler(ipAPICsv))
+	http.HandleFunc("/line/",corsHandler(ipAPILine))
+	http.HandleFunc("/php/",corsHandler(ipAPIPhp))
 
 	//handle batch requests
-	http.HandleFunc("/batch",ipAPIBatch)
+	http.HandleFunc("/batch",corsHandler(ipAPIBatch))
+
+	//handle streaming batch requests
+	http.HandleFunc(streamPath,corsHandler(ipAPIStream))
+
+	//handle csv enrichment requests
+	http.HandleFunc(enrichCSVPath,corsHandler(ipAPIEnrichCSV))
+
+	//handle GeoIP2 web service requests
+	http.HandleFunc(geoIP2Path,geoIP2)
+
+	if LoadedConfig.Export.Enabled {
+		//handle MMDB export requests
+		http.HandleFunc(exportMMDBPath,exportMMDB)
+	}
+
+	if LoadedConfig.Jobs.Enabled {
+		//handle bulk lookup job requests
+		http.HandleFunc(jobsPath,jobsHandler)
+		http.HandleFunc(jobsPath + "/",jobsHandler)
+	}
+
+	if LoadedConfig.ForwardAuth.Enabled {
+		//handle forward auth requests, with rules reloading when the config changes
+		startForwardAuth(configLocation)
+		http.HandleFunc(forwardAuthPath,forwardAuth)
+	}
+
+	if LoadedConfig.Admin.Enabled {
+		//handle cache admin requests
+		http.HandleFunc(adminCachePath + "/",cacheAdminHandler)
+	}
 
 	if LoadedConfig.Prometheus.Enabled {
 		//Start prometheus metrics end point
@@ -55,35 +123,112 @@ func main()  {
 	//404 everything else
 	http.HandleFunc("/",ipAIPProxy)
 
+	//Limit the requests forwarded to IP-API
+	initRateLimiters()
+
+	//servers stopped on shutdown
+	var servers shutdownServers
+
 	//Write cache if persist is true
 	if LoadedConfig.Cache.Persist {
 		//read cache file on startup
 		cache.ReadCache(&LoadedConfig.Cache.WriteLocation)
 
-		var writeCacheWg sync.WaitGroup
 		writeCacheDuration, _ := time.ParseDuration(LoadedConfig.Cache.WriteInterval)
-		writeCacheTimeTicker := time.NewTicker(writeCacheDuration)
-		writeCacheWg.Add(1)
+		servers.stopCacheWriter = startCacheWriter(writeCacheDuration)
+	}
 
-		go func() {
-			for {
-				select {
-				case <-writeCacheTimeTicker.C:
-					cache.WriteCache(&LoadedConfig.Cache.WriteLocation)
-				}
-				defer writeCacheWg.Done()
-			}
-		}()
+	//Resume bulk lookup jobs once the cache has been read
+	if LoadedConfig.Jobs.Enabled {
+		startJobs()
+	}
+
+	//Start gRPC server next to the HTTP endpoints
+	if LoadedConfig.GRPC.Enabled {
+		if servers.grpc, err = startGRPC(); err != nil {
+			log.Fatalln("error: starting gRPC server: " + err.Error())
+		}
+	}
+
+	//Start the DNS front-end, on its own port
+	if LoadedConfig.DNS.Enabled {
+		if servers.dns, err = startDNS(); err != nil {
+			log.Fatalln("error: starting DNS server: " + err.Error())
+		}
+	}
+
+	//Start the geo-enriching reverse proxy, on its own port in front of the backend
+	if LoadedConfig.ReverseProxy.Enabled {
+		if servers.reverseProxy, err = startReverseProxy(); err != nil {
+			log.Fatalln("error: starting reverse proxy: " + err.Error())
+		}
 	}
 
 	//Listen on port
 	log.Println("Starting server on port " + strconv.Itoa(LoadedConfig.Port) + "...")
-	if err := http.ListenAndServe(":" + strconv.Itoa(LoadedConfig.Port),nil); err != nil {
+	listener, err := net.Listen("tcp", ":" + strconv.Itoa(LoadedConfig.Port))
+	if err != nil {
 		log.Println(err)
+		return
 	}
+	servers.http = &http.Server{}
+	go func() {
+		if err := servers.http.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatalln(err)
+		}
+	}()
+
+	//Drain connections and write the cache on SIGINT or SIGTERM
+	waitForShutdown(servers)
+}
+
+/*
+loadConfig - reads the config into LoadedConfig
+configLocation - config file location, empty for the working directory
+ */
+func loadConfig(configLocation string) error {
+	var err error
+	LoadedConfig, err = config.ReadConfig(configLocation)
+
+	if err != nil {
+		return err
+	}
+
+	//Set fields returned when a request doesn't pass any
+	cache.DefaultFields = *LoadedConfig.DefaultFieldsSet
+
+	//Set snapshot generations kept when the cache is written
+	if LoadedConfig.Cache.Snapshots > 0 {
+		cache.SnapshotGenerations = LoadedConfig.Cache.Snapshots
+	}
+	return nil
 }
 
 func ipAPIJson(w http.ResponseWriter, r *http.Request) {
+	ipAPISingle(w, r, formatJSON)
+}
+
+func ipAPIXml(w http.ResponseWriter, r *http.Request) {
+	ipAPISingle(w, r, formatXML)
+}
+
+func ipAPICsv(w http.ResponseWriter, r *http.Request) {
+	ipAPISingle(w, r, formatCSV)
+}
+
+func ipAPILine(w http.ResponseWriter, r *http.Request) {
+	ipAPISingle(w, r, formatLine)
+}
+
+func ipAPIPhp(w http.ResponseWriter, r *http.Request) {
+	ipAPISingle(w, r, formatPHP)
+}
+
+/*
+ipAPISingle - handles a single query for any of the IP-API response formats
+format - one of the format constants, matches the endpoint name (json, xml, csv, line, php)
+ */
+func ipAPISingle(w http.ResponseWriter, r *http.Request, format string) {
 	//increment requests processed
 	promMetrics.IncrementRequestsProcessed()
 	promMetrics.IncrementSingleRequestsProcessed()
@@ -91,16 +236,35 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 	promMetrics.IncrementSingleQueriesProcessed()
 	promMetrics.IncrementQueriesProcessed()
 
-	//set content type
-	w.Header().Set("Content-Type","application/json")
-
 	//init location variable
 	location := ip_api.Location{}
 
+	//init output options, json can also be returned in any of the negotiated formats
+	options := outputOptions{format: format}
+
 	//init error
 	var err error
 
-	if r.Method == "GET" {
+	if format == formatJSON {
+		options, err = negotiatedOptions(r)
+		w.Header().Add("Vary", "Accept")
+	}
+
+	//set content type
+	setContentType(w, options)
+
+	if err != nil {
+		location.Status = "fail"
+		location.Message = err.Error()
+		log.Println("Failed single request: " + err.Error())
+		promMetrics.IncrementHandlerRequests("400")
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedSingleRequests()
+		writeLocation(w, http.StatusBadRequest, &location, options)
+		return
+	}
+
+	if r.Method == "GET" || r.Method == "HEAD" {
 		//check to make sure that there are only 2 or less / in URL
 		if strings.Count(r.URL.Path,"/") > 2 {
 			location.Status = "fail"
@@ -109,9 +273,7 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 			promMetrics.IncrementHandlerRequests("400")
 			promMetrics.IncrementFailedRequests()
 			promMetrics.IncrementFailedSingleRequests()
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusBadRequest, &location, options)
 			return
 		}
 
@@ -124,9 +286,7 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 			promMetrics.IncrementHandlerRequests("400")
 			promMetrics.IncrementFailedRequests()
 			promMetrics.IncrementFailedSingleRequests()
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusBadRequest, &location, options)
 			return
 		}
 
@@ -139,23 +299,85 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 			promMetrics.IncrementHandlerRequests("400")
 			promMetrics.IncrementFailedRequests()
 			promMetrics.IncrementFailedSingleRequests()
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusBadRequest, &location, options)
 			return
 		}
 
 		//get ecs value
 		ecs, ok := r.URL.Query()["ecs"]
-		var ecsBool = false
 		if len(ecs) > 0 {
-			ecsBool, _ = strconv.ParseBool(ecs[0])
+			options.ecs = parseEcs(ecs[0])
+		}
+
+		//get ecs prefix value
+		ecsPrefix, ok := r.URL.Query()["ecsPrefix"]
+		if len(ecsPrefix) > 0 && ecsPrefix[0] != "" {
+			if !validEcsPrefix(ecsPrefix[0]) {
+				location.Status = "fail"
+				location.Message = "invalid ecsPrefix provided, expected one of: " + strings.Join(ecsPrefixes, ",")
+				log.Println("Failed single request: invalid ecsPrefix provided")
+				promMetrics.IncrementHandlerRequests("400")
+				promMetrics.IncrementFailedRequests()
+				promMetrics.IncrementFailedSingleRequests()
+				writeLocation(w, http.StatusBadRequest, &location, options)
+				return
+			}
+			options.ecsPrefix = ecsPrefix[0]
+		}
+
+		//get profile value, profiles replace the IP-API and ECS output
+		profile, ok := r.URL.Query()["profile"]
+		if len(profile) > 0 && profile[0] != "" {
+			options.profile, err = getProfile(profile[0])
+			if err == nil && options.ecs != "" {
+				err = errors.New("profile and ecs cannot be combined")
+			}
+			if err != nil {
+				location.Status = "fail"
+				location.Message = err.Error()
+				log.Println("Failed single request: " + err.Error())
+				promMetrics.IncrementHandlerRequests("400")
+				promMetrics.IncrementFailedRequests()
+				promMetrics.IncrementFailedSingleRequests()
+				options.profile = nil
+				writeLocation(w, http.StatusBadRequest, &location, options)
+				return
+			}
+		}
+
+		//get callback value, only json responses can be wrapped in a JSONP callback
+		callback, ok := r.URL.Query()["callback"]
+		if len(callback) > 0 && callback[0] != "" && format == formatJSON {
+			if !validCallback(callback[0]) {
+				location.Status = "fail"
+				location.Message = "invalid callback provided"
+				log.Println("Failed single request: invalid callback provided")
+				promMetrics.IncrementHandlerRequests("400")
+				promMetrics.IncrementFailedRequests()
+				promMetrics.IncrementFailedSingleRequests()
+				writeLocation(w, http.StatusBadRequest, &location, options)
+				return
+			}
+			options.callback = callback[0]
+		}
+
+		//get meta value, json locations can carry how fresh they are and where they came from
+		options.meta, err = requestMeta(r, options)
+		if err != nil {
+			location.Status = "fail"
+			location.Message = err.Error()
+			log.Println("Failed single request: " + err.Error())
+			promMetrics.IncrementHandlerRequests("400")
+			promMetrics.IncrementFailedRequests()
+			promMetrics.IncrementFailedSingleRequests()
+			writeLocation(w, http.StatusBadRequest, &location, options)
+			return
 		}
 
 		//validate fields
-		var validatedFields string
+		var validatedFields cache.Fields
 		if len(fields) > 0 {
-			validatedFields, err = ip_api.ValidateFields(fields[0])
+			validatedFields, err = cache.ParseFields(fields[0])
 
 			if err != nil {
 				location.Status = "fail"
@@ -164,13 +386,22 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 				promMetrics.IncrementHandlerRequests("400")
 				promMetrics.IncrementFailedRequests()
 				promMetrics.IncrementFailedSingleRequests()
-				jsonLocation, _ := json.Marshal(&location)
-				w.WriteHeader(http.StatusBadRequest)
-				_, _ = w.Write(jsonLocation)
+				writeLocation(w, http.StatusBadRequest, &location, options)
 				return
 			}
 		}
 
+		if len(fields) > 0 {
+			options.fieldOrder = requestedFieldOrder(fields[0], validatedFields)
+		}
+
+		//profiles look up the fields they output unless fields are requested
+		if validatedFields == 0 && options.profile != nil {
+			validatedFields = options.profile.RequiredFields()
+		}
+
+		options.fields = validatedFields
+
 		//validate lang
 		var validatedLang string
 		if len(lang) > 0 {
@@ -183,9 +414,7 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 				promMetrics.IncrementHandlerRequests("400")
 				promMetrics.IncrementFailedRequests()
 				promMetrics.IncrementFailedSingleRequests()
-				jsonLocation, _ := json.Marshal(&location)
-				w.WriteHeader(http.StatusBadRequest)
-				_, _ = w.Write(jsonLocation)
+				writeLocation(w, http.StatusBadRequest, &location, options)
 				return
 			}
 		}
@@ -198,9 +427,33 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 			key = keys[0]
 		}
 
+		//get cache control, only allowed clients can skip the cache so that it can't burn quota
+		control, allowed, err := requestCacheControl(r)
+		if err != nil {
+			statusCode := http.StatusBadRequest
+			if !allowed {
+				statusCode = http.StatusForbidden
+			}
+			location.Status = "fail"
+			location.Message = err.Error()
+			log.Println("Failed single request: " + err.Error())
+			promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+			promMetrics.IncrementFailedRequests()
+			promMetrics.IncrementFailedSingleRequests()
+			writeLocation(w, statusCode, &location, options)
+			return
+		}
+
 		//Get ip address
 		ip := IPDNSRegexp.FindString(r.URL.Path)
 
+		//Like IP-API, a blank query looks up the client making the request, so only the client may cache it
+		httpCache := httpCacheState{xCache: cacheMiss, provider: apiProvider(key)}
+		if ip == "" && strings.Trim(r.URL.Path, "/") == format {
+			ip = clientIP(r)
+			httpCache.private = true
+		}
+
 		if ip == "" {
 			location.Status = "fail"
 			location.Message = "request is blank"
@@ -208,177 +461,82 @@ func ipAPIJson(w http.ResponseWriter, r *http.Request) {
 			promMetrics.IncrementHandlerRequests("400")
 			promMetrics.IncrementFailedRequests()
 			promMetrics.IncrementFailedSingleRequests()
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusBadRequest, &location, options)
 			return
 		}
 
-		//Check cache for ip
-		location, found, err := cache.GetLocation(ip + validatedLang,validatedFields)
-
-		if err != nil {
-			panic(err)
+		//keep the cached location when skipping the cache, so it can still be served if refreshing it fails
+		if control.bypass() || control.maxAge > 0 {
+			httpCache.record, _, _ = cache.GetRecord(ip+validatedLang, validatedFields)
 		}
 
-		//If ip found in cache return cached value
-		if found {
-			if LoadedConfig.Debugging {
-				log.Println("Found: " + ip + " in cache.")
+		//Get location from cache, or from IP-API if it isn't cached
+		location, found, err := lookupControlledLocation(r.Context(), ip, validatedLang, validatedFields, key, control)
+
+		if err != nil && httpCache.record != nil {
+			log.Println("Serving stale " + ip + " after failed refresh: " + err.Error())
+			location, found, err = &httpCache.record.Location, true, nil
+			httpCache.xCache = cacheStale
+		} else if err == nil {
+			if found {
+				httpCache.xCache = cacheHit
 			}
-			promMetrics.IncrementHandlerRequests("200")
-			promMetrics.IncrementCacheHits()
-			promMetrics.IncrementSuccessfulQueries()
-			promMetrics.IncrementSuccessfulSingeQueries()
-			if !ecsBool {
-				jsonLocation, _ := json.Marshal(&location)
-				w.WriteHeader(http.StatusOK)
-				_, _ = w.Write(jsonLocation)
-			} else {
-				ecsLocation := EcsLocation{
-					Status:        location.Status,
-					Message:       location.Message,
-					Continent:     location.Continent,
-					ContinentCode: location.ContinentCode,
-					Country:       location.Country,
-					CountryCode:   location.CountryCode,
-					Region:        location.Region,
-					RegionName:    location.RegionName,
-					City:          location.City,
-					District:      location.District,
-					ZIP:           location.ZIP,
-					Lat:           location.Lat,
-					Lon:           location.Lon,
-					Timezone:      location.Timezone,
-					Currency:      location.Currency,
-					ISP:           location.ISP,
-					Org:           location.Org,
-					AS:            location.AS,
-					ASName:        location.ASName,
-					Reverse:       location.Reverse,
-					Mobile:        location.Mobile,
-					Proxy:         location.Proxy,
-					Hosting:       location.Hosting,
-					Query:         location.Query,
-				}
-				jsonLocation, _ := json.Marshal(&ecsLocation)
-				w.WriteHeader(http.StatusOK)
-				_, _ = w.Write(jsonLocation)
+			httpCache.record = nil
+			if !control.noCache {
+				httpCache.record, _, _ = cache.GetRecord(ip+validatedLang, validatedFields)
 			}
-			return
-		}
-
-		//Build query
-		query := ip_api.Query{
-			Queries:[]ip_api.QueryIP{
-				{Query:ip},
-			},
-			Fields:strings.Join(ip_api.AllowedAPIFields,","), //Execute query to IP API for all fields, handle field selection later
-			Lang:validatedLang,
 		}
 
-		//execute query
-		promMetrics.IncrementRequestsForwarded()
-		promMetrics.IncrementQueriesForwarded()
-		var newLocation *ip_api.Location
-		newLocation, err = ip_api.SingleQuery(query,key,"",LoadedConfig.Debugging)
-
 		if err != nil {
+			location = &ip_api.Location{}
 			location.Status = "fail"
 			location.Message = err.Error()
 			log.Println("Failed single request: " + err.Error())
 			promMetrics.IncrementHandlerRequests("400")
 			promMetrics.IncrementFailedRequests()
 			promMetrics.IncrementFailedSingleRequests()
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusBadRequest, location, options)
 			return
 		}
 
-		//Add to cache if successful request
-		if newLocation.Status == "success" {
-			if LoadedConfig.Debugging {
-				log.Println("Added: " + ip + validatedLang + " to cache.")
-			}
-			promMetrics.IncrementHandlerRequests("200")
-			_, err = cache.AddLocation(ip + validatedLang,*newLocation,*LoadedConfig.Cache.SuccessAgeDuration)
-			if err != nil {
-				log.Println(err)
-			}
-			//Re-get request with specified fields
-			newLocation, _, err = cache.GetLocation(ip + validatedLang,validatedFields)
-			if err != nil {
-				log.Println(err)
-			}
+		//If ip found in cache return cached value, or 304 if the client already has it
+		if found {
+			statusCode := writeCachedLocation(w, r, location, options, httpCache)
+			promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
 			promMetrics.IncrementSuccessfulQueries()
 			promMetrics.IncrementSuccessfulSingeQueries()
+			return
 		}
 
-		//if request failed, increment 400 and fail request counter
-		if newLocation.Status == "fail" {
-			log.Println("Failed single query: " + ip)
+		//return query, or 304 if the client already has it
+		statusCode := writeCachedLocation(w, r, location, options, httpCache)
+
+		//failed queries count as 400, unless the client already had them
+		if location.Status == "fail" && statusCode != http.StatusNotModified {
 			promMetrics.IncrementHandlerRequests("400")
-			_, err = cache.AddLocation(ip + validatedLang,*newLocation,*LoadedConfig.Cache.FailedAgeDuration)
-			if err != nil {
-				log.Println(err)
-			}
-			//Re-get request with specified fields
-			newLocation, _, err = cache.GetLocation(ip + validatedLang,validatedFields)
-			if err != nil {
-				log.Println(err)
-			}
-			promMetrics.IncrementFailedQueries()
-			promMetrics.IncrementFailedSingleQueries()
+		} else if location.Status == "success" || statusCode == http.StatusNotModified {
+			promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+		}
 
+		if location.Status == "success" {
+			promMetrics.IncrementSuccessfulQueries()
+			promMetrics.IncrementSuccessfulSingeQueries()
 		}
 
-		//return query
-		if !ecsBool {
-			jsonLocation, _ := json.Marshal(&newLocation)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(jsonLocation)
-		} else {
-			ecsLocation := EcsLocation{
-				Status:        newLocation.Status,
-				Message:       newLocation.Message,
-				Continent:     newLocation.Continent,
-				ContinentCode: newLocation.ContinentCode,
-				Country:       newLocation.Country,
-				CountryCode:   newLocation.CountryCode,
-				Region:        newLocation.Region,
-				RegionName:    newLocation.RegionName,
-				City:          newLocation.City,
-				District:      newLocation.District,
-				ZIP:           newLocation.ZIP,
-				Lat:           newLocation.Lat,
-				Lon:           newLocation.Lon,
-				Timezone:      newLocation.Timezone,
-				Currency:      newLocation.Currency,
-				ISP:           newLocation.ISP,
-				Org:           newLocation.Org,
-				AS:            newLocation.AS,
-				ASName:        newLocation.ASName,
-				Reverse:       newLocation.Reverse,
-				Mobile:        newLocation.Mobile,
-				Proxy:         newLocation.Proxy,
-				Hosting:       newLocation.Hosting,
-				Query:         newLocation.Query,
-			}
-			jsonLocation, _ := json.Marshal(&ecsLocation)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(jsonLocation)
+		//if request failed, increment fail request counter
+		if location.Status == "fail" {
+			log.Println("Failed single query: " + ip)
+			promMetrics.IncrementFailedQueries()
+			promMetrics.IncrementFailedSingleQueries()
 		}
 		return
 	} else {
-		if r.URL.Path != "/json/" && r.URL.Path != "/batch" && r.URL.Path != "/metrics" {
+		if r.URL.Path != "/" + format + "/" {
 			location.Status = "fail"
-			location.Message = "/json/ endpoint only supports GET requests."
-			log.Println("Failed single request: /json/ endpoint only supports GET requests.")
+			location.Message = "/" + format + "/ endpoint only supports GET and HEAD requests."
+			log.Println("Failed single request: /" + format + "/ endpoint only supports GET and HEAD requests.")
 			promMetrics.IncrementHandlerRequests("404")
-			jsonLocation, _ := json.Marshal(&location)
-			w.WriteHeader(http.StatusNotFound)
-			_, _ = w.Write(jsonLocation)
+			writeLocation(w, http.StatusNotFound, &location, options)
 			return
 		}
 	}
@@ -395,8 +553,21 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 	//init location variable
 	location := ip_api.Location{}
 
-	//init error
-	var err error
+	//init output options, batches can also be returned as a GeoJSON FeatureCollection or any of the negotiated formats
+	options, err := negotiatedOptions(r)
+
+	if err != nil {
+		location.Status = "fail"
+		location.Message = err.Error()
+		log.Println("Failed batch request: " + err.Error())
+		promMetrics.IncrementHandlerRequests("400")
+		promMetrics.IncrementFailedRequests()
+		promMetrics.IncrementFailedBatchRequests()
+		jsonLocation, _ := json.Marshal(&location)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(jsonLocation)
+		return
+	}
 
 	if r.Method == "POST" {
 		//check to make sure that there are only 1 or less / in URL
@@ -445,15 +616,68 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 
 		//get ecs value
 		ecs, ok := r.URL.Query()["ecs"]
-		var ecsBool = false
 		if len(ecs) > 0 {
-			ecsBool, _ = strconv.ParseBool(ecs[0])
+			options.ecs = parseEcs(ecs[0])
+		}
+
+		//get ecs prefix value
+		ecsPrefix, ok := r.URL.Query()["ecsPrefix"]
+		if len(ecsPrefix) > 0 && ecsPrefix[0] != "" {
+			if !validEcsPrefix(ecsPrefix[0]) {
+				location.Status = "fail"
+				location.Message = "invalid ecsPrefix provided, expected one of: " + strings.Join(ecsPrefixes, ",")
+				log.Println("Failed batch request: invalid ecsPrefix provided")
+				promMetrics.IncrementHandlerRequests("400")
+				promMetrics.IncrementFailedRequests()
+				promMetrics.IncrementFailedBatchRequests()
+				jsonLocation, _ := json.Marshal(&location)
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write(jsonLocation)
+				return
+			}
+			options.ecsPrefix = ecsPrefix[0]
+		}
+
+		//get profile value, profiles replace the IP-API and ECS output
+		profile, ok := r.URL.Query()["profile"]
+		if len(profile) > 0 && profile[0] != "" {
+			options.profile, err = getProfile(profile[0])
+			if err == nil && options.ecs != "" {
+				err = errors.New("profile and ecs cannot be combined")
+			}
+			if err != nil {
+				location.Status = "fail"
+				location.Message = err.Error()
+				log.Println("Failed batch request: " + err.Error())
+				promMetrics.IncrementHandlerRequests("400")
+				promMetrics.IncrementFailedRequests()
+				promMetrics.IncrementFailedBatchRequests()
+				jsonLocation, _ := json.Marshal(&location)
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write(jsonLocation)
+				return
+			}
+		}
+
+		//get meta value, json locations can carry how fresh they are and where they came from
+		options.meta, err = requestMeta(r, options)
+		if err != nil {
+			location.Status = "fail"
+			location.Message = err.Error()
+			log.Println("Failed batch request: " + err.Error())
+			promMetrics.IncrementHandlerRequests("400")
+			promMetrics.IncrementFailedRequests()
+			promMetrics.IncrementFailedBatchRequests()
+			jsonLocation, _ := json.Marshal(&location)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(jsonLocation)
+			return
 		}
 
 		//validate fields
-		var validatedFields string
+		var validatedFields cache.Fields
 		if len(fields) > 0 {
-			validatedFields, err = ip_api.ValidateFields(fields[0])
+			validatedFields, err = cache.ParseFields(fields[0])
 
 			if err != nil {
 				location.Status = "fail"
@@ -469,6 +693,17 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if len(fields) > 0 {
+			options.fieldOrder = requestedFieldOrder(fields[0], validatedFields)
+		}
+
+		//profiles look up the fields they output unless fields are requested
+		if validatedFields == 0 && options.profile != nil {
+			validatedFields = options.profile.RequiredFields()
+		}
+
+		options.fields = validatedFields
+
 		//validate lang
 		var validatedLang string
 		if len(lang) > 0 {
@@ -496,6 +731,25 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 			key = keys[0]
 		}
 
+		//get cache control of the batch, entries can add their own
+		control, allowed, err := requestCacheControl(r)
+		if err != nil {
+			statusCode := http.StatusBadRequest
+			if !allowed {
+				statusCode = http.StatusForbidden
+			}
+			location.Status = "fail"
+			location.Message = err.Error()
+			log.Println("Failed batch request: " + err.Error())
+			promMetrics.IncrementHandlerRequests(strconv.Itoa(statusCode))
+			promMetrics.IncrementFailedRequests()
+			promMetrics.IncrementFailedBatchRequests()
+			jsonLocation, _ := json.Marshal(&location)
+			w.WriteHeader(statusCode)
+			_, _ = w.Write(jsonLocation)
+			return
+		}
+
 		//Read body data
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
@@ -512,7 +766,7 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 		}
 
 		//unmarshal request into slice
-		var requests []ip_api.QueryIP
+		var requests []batchRequest
 		err = json.Unmarshal(body,&requests)
 		if err != nil {
 			location.Status = "fail"
@@ -543,11 +797,13 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 
 		//init slices
 		var cachedLocations []ip_api.Location
-		var cachedEcsLocations []EcsLocation
 		var notCachedRequests []ip_api.QueryIP
-		var notCachedRequestsMap = map[string]ip_api.QueryIP{}
+		var notCachedRequestsMap = map[string]batchRequest{}
+		var noCacheQueries = map[string]bool{}
 		var cachedNewLocations []ip_api.Location
-		var cachedNewEcsLocations []EcsLocation
+		//metadata of cachedLocations and cachedNewLocations, nil for locations which weren't looked up
+		var cachedMetas []*locationMeta
+		var cachedNewMetas []*locationMeta
 		//First check for any requests that are in cache. Only want to forward non-cached requests
 		var wg sync.WaitGroup
 		wg.Add(len(requests))
@@ -557,27 +813,37 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 				promMetrics.IncrementBatchQueriesProcessed()
 				promMetrics.IncrementQueriesProcessed()
 
-				var validatedSubFields string
+				//each entry has its own error, so one entry's failure doesn't carry over to the next
+				var err error
+
+				var validatedSubFields cache.Fields
 				//validate sub fields
 				if request.Fields != "" {
-					validatedSubFields, err = ip_api.ValidateFields(request.Fields)
+					validatedSubFields, err = cache.ParseFields(request.Fields)
 				}
 
 				//validate sub lang
 				var validatedSubLang string
-				if request.Lang != "" {
+				if err == nil && request.Lang != "" {
 					validatedSubLang, err = ip_api.ValidateLang(request.Lang)
 				}
 
+				//get cache control of the entry
+				var entryControl cacheControl
+				if err == nil {
+					entryControl, err = request.batchCacheControl.merge(control, allowed)
+				}
+
 				//init location
 				var location ip_api.Location
 
-				//If err on sub fields or sub lang set as failed query status with err in message
+				//If err on sub fields, sub lang or cache control set as failed query status with err in message
 				if err != nil {
 					location.Status = "fail"
 					location.Message = err.Error()
 					location.Query = request.Query
 					cachedLocations = append(cachedLocations, location) //Even though they aren't cached, we don't want to execute these as they are bad
+					cachedMetas = append(cachedMetas, nil)
 					log.Println("Failed batch query: " + request.Query + " " + err.Error())
 					promMetrics.IncrementHandlerRequests("400")
 					promMetrics.IncrementFailedQueries()
@@ -587,35 +853,27 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 					location.Message = "request is blank"
 					location.Query = request.Query
 					cachedLocations = append(cachedLocations, location) //Even though they aren't cached, we don't want to execute these as they are bad
+					cachedMetas = append(cachedMetas, nil)
 					log.Println("Failed batch query: " + request.Query + " request is blank")
 					promMetrics.IncrementHandlerRequests("400")
 					promMetrics.IncrementFailedQueries()
 					promMetrics.IncrementFailedBatchQueries()
 				} else {
-					//Check cache for ip
-					var location *ip_api.Location
+					//Check cache for ip, with the entry's own fields and lang if it has them
+					var record *cache.Record
 					var found bool
 
-					if validatedSubFields != "" && validatedSubLang != "" {
-						location, found, err = cache.GetLocation(request.Query + validatedSubLang,validatedSubFields)
-						if err != nil {
-							log.Println(err)
-						}
-					} else if validatedSubFields != "" {
-						location, found, err = cache.GetLocation(request.Query + validatedLang,validatedSubFields)
-						if err != nil {
-							log.Println(err)
-						}
-					} else if validatedSubLang != "" {
-						location, found, err = cache.GetLocation(request.Query + validatedSubLang,validatedFields)
-						if err != nil {
-							log.Println(err)
-						}
-					} else {
-						location, found, err = cache.GetLocation(request.Query + validatedLang,validatedFields)
-						if err != nil {
-							log.Println(err)
-						}
+					entryFields := validatedFields
+					if validatedSubFields != 0 {
+						entryFields = validatedSubFields
+					}
+					entryLang := validatedLang
+					if validatedSubLang != "" {
+						entryLang = validatedSubLang
+					}
+					record, found, err = cachedRecord(request.Query + entryLang,entryFields,entryControl)
+					if err != nil {
+						log.Println(err)
 					}
 
 
@@ -627,48 +885,22 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 						if LoadedConfig.Debugging {
 							log.Println("Found: " + request.Query + " in cache.")
 						}
-						if !ecsBool {
-							cachedLocations = append(cachedLocations, *location)
-						} else {
-							ecsLocation := EcsLocation{
-								Status:        location.Status,
-								Message:       location.Message,
-								Continent:     location.Continent,
-								ContinentCode: location.ContinentCode,
-								Country:       location.Country,
-								CountryCode:   location.CountryCode,
-								Region:        location.Region,
-								RegionName:    location.RegionName,
-								City:          location.City,
-								District:      location.District,
-								ZIP:           location.ZIP,
-								Lat:           location.Lat,
-								Lon:           location.Lon,
-								Timezone:      location.Timezone,
-								Currency:      location.Currency,
-								ISP:           location.ISP,
-								Org:           location.Org,
-								AS:            location.AS,
-								ASName:        location.ASName,
-								Reverse:       location.Reverse,
-								Mobile:        location.Mobile,
-								Proxy:         location.Proxy,
-								Hosting:       location.Hosting,
-								Query:         location.Query,
-							}
-							cachedEcsLocations = append(cachedEcsLocations, ecsLocation)
-						}
+						cachedLocations = append(cachedLocations, record.Location)
+						cachedMetas = append(cachedMetas, recordMeta(record, cache.SourceCache))
 					} else {
 						//if not found in cache add to not cache request list
 						promMetrics.IncrementQueriesForwarded()
 						//add request to map for later lookups
 						notCachedRequestsMap[request.Query] = request
+						if entryControl.noCache {
+							noCacheQueries[request.Query] = true
+						}
 
 						//set fields to all so that everything is stored in cache
 						if request.Fields != "" {
 							request.Fields = strings.Join(ip_api.AllowedAPIFields,",")
 						}
-						notCachedRequests = append(notCachedRequests, request)
+						notCachedRequests = append(notCachedRequests, request.QueryIP)
 
 					}
 				}
@@ -686,10 +918,13 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 				Lang:    validatedLang,
 			}
 
-			//Execute batch request
+			//Execute batch request once the rate limit allows it
 			var notCachedLocations []ip_api.Location
-			promMetrics.IncrementRequestsForwarded()
-			notCachedLocations, err = ip_api.BatchQuery(batchQuery,key,"",LoadedConfig.Debugging)
+			err = waitLimiter(r.Context(), batchLimiter)
+			if err == nil {
+				promMetrics.IncrementRequestsForwarded()
+				notCachedLocations, err = ip_api.BatchQuery(batchQuery,key,"",LoadedConfig.Debugging)
+			}
 
 			if err != nil {
 				location.Status = "fail"
@@ -728,8 +963,26 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 								lang = validatedLang
 							}
 
+							//set fields value
+							var fields cache.Fields
+							if requestMap, ok := notCachedRequestsMap[location.Query]; ok && requestMap.Fields != "" {
+								fields, _ = cache.ParseFields(requestMap.Fields)
+							} else {
+								fields = validatedFields
+							}
+
+							//nocache locations are returned without replacing the cached location
+							if noCacheQueries[location.Query] {
+								cachedNewLocations = append(cachedNewLocations, cache.ProjectLocation(&location, fields))
+								cachedNewMetas = append(cachedNewMetas, upstreamMeta(location.Query+lang, key, false))
+								promMetrics.IncrementSuccessfulQueries()
+								promMetrics.IncrementSuccessfulBatchQueries()
+								wg.Done()
+								continue
+							}
+
 							//Store non-cached location in cache and get back proper fields location
-							_, err = cache.AddLocation(location.Query+lang, location, *LoadedConfig.Cache.SuccessAgeDuration)
+							err = cacheLocation(location.Query, lang, location, key)
 							if err != nil {
 								log.Println(err)
 							}
@@ -738,48 +991,15 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 								log.Println("Added Success: " + location.Query + lang + " in cache.")
 							}
 
-							//set fields value
-							var fields string
-							if requestMap, ok := notCachedRequestsMap[location.Query]; ok {
-								fields = requestMap.Fields
-							} else {
-								fields = validatedFields
-							}
-
 							cachedLocation, _, err := cache.GetLocation(location.Query+lang, fields)
 							if err != nil {
 								log.Println(err)
 							}
-							if !ecsBool {
-								cachedNewLocations = append(cachedNewLocations, *cachedLocation)
+							cachedNewLocations = append(cachedNewLocations, *cachedLocation)
+							if options.meta {
+								cachedNewMetas = append(cachedNewMetas, upstreamMeta(location.Query+lang, key, true))
 							} else {
-								ecsLocation := EcsLocation{
-									Status:        cachedLocation.Status,
-									Message:       cachedLocation.Message,
-									Continent:     cachedLocation.Continent,
-									ContinentCode: cachedLocation.ContinentCode,
-									Country:       cachedLocation.Country,
-									CountryCode:   cachedLocation.CountryCode,
-									Region:        cachedLocation.Region,
-									RegionName:    cachedLocation.RegionName,
-									City:          cachedLocation.City,
-									District:      cachedLocation.District,
-									ZIP:           cachedLocation.ZIP,
-									Lat:           cachedLocation.Lat,
-									Lon:           cachedLocation.Lon,
-									Timezone:      cachedLocation.Timezone,
-									Currency:      cachedLocation.Currency,
-									ISP:           cachedLocation.ISP,
-									Org:           cachedLocation.Org,
-									AS:            cachedLocation.AS,
-									ASName:        cachedLocation.ASName,
-									Reverse:       cachedLocation.Reverse,
-									Mobile:        cachedLocation.Mobile,
-									Proxy:         cachedLocation.Proxy,
-									Hosting:       cachedLocation.Hosting,
-									Query:         cachedLocation.Query,
-								}
-								cachedNewEcsLocations = append(cachedNewEcsLocations, ecsLocation)
+								cachedNewMetas = append(cachedNewMetas, nil)
 							}
 							promMetrics.IncrementSuccessfulQueries()
 							promMetrics.IncrementSuccessfulBatchQueries()
@@ -797,46 +1017,23 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 								lang = validatedLang
 							}
 
-							//Store non-cached location in cache and get back proper fields location
-							_, err = cache.AddLocation(location.Query+lang, location, *LoadedConfig.Cache.FailedAgeDuration)
-							if err != nil {
-								log.Println(err)
-							}
+							//Store non-cached location in cache, unless it is a nocache location
+							if !noCacheQueries[location.Query] {
+								err = cacheLocation(location.Query, lang, location, key)
+								if err != nil {
+									log.Println(err)
+								}
 
-							if LoadedConfig.Debugging {
-								log.Println("Added Failed: " + location.Query + lang + " in cache.")
+								if LoadedConfig.Debugging {
+									log.Println("Added Failed: " + location.Query + lang + " in cache.")
+								}
 							}
 
-							if !ecsBool {
-								cachedNewLocations = append(cachedNewLocations, location)
+							cachedNewLocations = append(cachedNewLocations, location)
+							if options.meta {
+								cachedNewMetas = append(cachedNewMetas, upstreamMeta(location.Query+lang, key, !noCacheQueries[location.Query]))
 							} else {
-								ecsLocation := EcsLocation{
-									Status:        location.Status,
-									Message:       location.Message,
-									Continent:     location.Continent,
-									ContinentCode: location.ContinentCode,
-									Country:       location.Country,
-									CountryCode:   location.CountryCode,
-									Region:        location.Region,
-									RegionName:    location.RegionName,
-									City:          location.City,
-									District:      location.District,
-									ZIP:           location.ZIP,
-									Lat:           location.Lat,
-									Lon:           location.Lon,
-									Timezone:      location.Timezone,
-									Currency:      location.Currency,
-									ISP:           location.ISP,
-									Org:           location.Org,
-									AS:            location.AS,
-									ASName:        location.ASName,
-									Reverse:       location.Reverse,
-									Mobile:        location.Mobile,
-									Proxy:         location.Proxy,
-									Hosting:       location.Hosting,
-									Query:         location.Query,
-								}
-								cachedNewEcsLocations = append(cachedNewEcsLocations, ecsLocation)
+								cachedNewMetas = append(cachedNewMetas, nil)
 							}
 							log.Println("Failed query: " + location.Query)
 							promMetrics.IncrementFailedQueries()
@@ -850,29 +1047,17 @@ func ipAPIBatch(w http.ResponseWriter, r *http.Request) {
 		}
 
 		//Merge new requests with cached requests and return all
-		if !ecsBool {
-			if len(cachedNewLocations) > 0 {
-				cachedLocations = append(cachedLocations, cachedNewLocations...)
-			}
-		} else {
-			if len(cachedNewEcsLocations) > 0 {
-				cachedEcsLocations = append(cachedEcsLocations, cachedNewEcsLocations...)
-			}
+		if len(cachedNewLocations) > 0 {
+			cachedLocations = append(cachedLocations, cachedNewLocations...)
+			cachedMetas = append(cachedMetas, cachedNewMetas...)
 		}
 
-
 		//return query
-		if !ecsBool {
-			jsonLocation, _ := json.Marshal(cachedLocations)
-			promMetrics.IncrementHandlerRequests("200")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(jsonLocation)
-		} else {
-			jsonLocation, _ := json.Marshal(cachedEcsLocations)
-			promMetrics.IncrementHandlerRequests("200")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(jsonLocation)
+		promMetrics.IncrementHandlerRequests("200")
+		if !options.meta {
+			cachedMetas = nil
 		}
+		writeLocations(w, http.StatusOK, cachedLocations, cachedMetas, options)
 		return
 	} else {
 		if r.URL.Path != "/json/" && r.URL.Path != "/batch" && r.URL.Path != "/metrics" {
@@ -892,8 +1077,8 @@ func ipAIPProxy(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/json/" && r.URL.Path != "/batch" && r.URL.Path != "/metrics" {
 		var location ip_api.Location
 		location.Status = "fail"
-		location.Message = "server only supports GET (/json/ endpoint) and POST (/batch endpoint) requests."
-		log.Println("404, server only supports GET (/json/ endpoint) and POST (/batch endpoint) requests.")
+		location.Message = "server only supports GET (/json/, /xml/, /csv/, /line/, /php/ endpoints) and POST (/batch endpoint) requests."
+		log.Println("404, server only supports GET (/json/, /xml/, /csv/, /line/, /php/ endpoints) and POST (/batch endpoint) requests.")
 		promMetrics.IncrementHandlerRequests("404")
 		jsonLocation, _ := json.Marshal(&location)
 		w.Header().Add("Content-Type","application/json")
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+/*
+locationMeta - how fresh a location is and where it came from, added to json locations with meta=true
+CachedAt - time the location was cached, nil if it wasn't cached (nocache)
+ExpiresAt - time the cached location goes stale, nil if it wasn't cached
+Source - how the location was answered: cache, upstream (looked up with IP-API) or stale (cached location served after a failed refresh)
+Provider - provider which looked the location up (ip-api, ip-api-pro), empty if unknown
+ */
+type locationMeta struct {
+	CachedAt  *time.Time `json:"cachedAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Source    string     `json:"source"`
+	Provider  string     `json:"provider,omitempty"`
+}
+
+/*
+requestMeta - checks if a request asks for metadata with meta=true
+r - http request
+options - outputOptions of the request, metadata is only added to json output
+
+returns
+true if metadata is requested
+error for an invalid value, or a format other than json
+ */
+func requestMeta(r *http.Request, options outputOptions) (bool, error) {
+	value := r.URL.Query().Get("meta")
+	if value == "" {
+		return false, nil
+	}
+
+	meta, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid meta provided, expected true or false")
+	}
+	if meta && options.format != formatJSON {
+		return false, errors.New("meta is only supported for json output")
+	}
+	return meta, nil
+}
+
+/*
+recordMeta - gets the metadata of a cached location
+record - cache record of the location
+source - how the location was answered, the record's own source if empty
+ */
+func recordMeta(record *cache.Record, source string) *locationMeta {
+	if source == "" {
+		source = record.Source
+		if source == "" {
+			source = cache.SourceUpstream
+		}
+	}
+	cachedAt := recordInsertionTime(record).UTC()
+	expiresAt := record.ExpirationTime.UTC()
+	return &locationMeta{CachedAt: &cachedAt, ExpiresAt: &expiresAt, Source: source, Provider: record.Provider}
+}
+
+/*
+upstreamMeta - gets the metadata of a location just looked up with IP-API
+cacheKey - query + lang the location was cached under
+key - IP-API key it was looked up with
+cached - false for nocache locations, which have no cache times
+ */
+func upstreamMeta(cacheKey string, key string, cached bool) *locationMeta {
+	if cached {
+		if record, found, _ := cache.GetRecord(cacheKey, cache.FieldStatus); found {
+			return recordMeta(record, "")
+		}
+	}
+	return &locationMeta{Source: cache.SourceUpstream, Provider: apiProvider(key)}
+}
+
+/*
+toMetaOutput - toOutput, with the location's metadata in a meta object
+the metadata is nested so that it can't collide with the location's fields, like ECS's source
+meta - locationMeta, nil for none
+ */
+func toMetaOutput(location *ip_api.Location, meta *locationMeta, options outputOptions) interface{} {
+	output := toOutput(location, options)
+	if meta == nil {
+		return output
+	}
+
+	outputBytes, err := json.Marshal(output)
+	metaBytes, metaErr := json.Marshal(meta)
+	if err != nil || metaErr != nil || !bytes.HasSuffix(outputBytes, []byte("}")) {
+		return output
+	}
+
+	//append to the object rather than re-encoding it, so the location's fields keep their order
+	outputBytes = outputBytes[:len(outputBytes)-1]
+	if len(outputBytes) > 1 {
+		outputBytes = append(outputBytes, ',')
+	}
+	outputBytes = append(outputBytes, `"meta":`...)
+	outputBytes = append(outputBytes, metaBytes...)
+	return json.RawMessage(append(outputBytes, '}'))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/outputProfile"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestToMetaOutput(t *testing.T) {
+	cachedAt := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	expiresAt := cachedAt.Add(24 * time.Hour)
+	meta := &locationMeta{CachedAt: &cachedAt, ExpiresAt: &expiresAt, Source: cache.SourceCache, Provider: "ip-api"}
+	metaJSON := `"meta":{"cachedAt":"2026-10-18T12:00:00Z","expiresAt":"2026-10-19T12:00:00Z","source":"cache","provider":"ip-api"}`
+	profile := outputProfile.Profile{Flat: true, Fields: []outputProfile.Field{{Name: "ip", Field: "query"}}}
+	location := ip_api.Location{Status: "success", Country: "United States", CountryCode: "US", Query: "8.8.8.8"}
+
+	tests := []struct {
+		name     string
+		location ip_api.Location
+		meta     *locationMeta
+		options  outputOptions
+		expected string
+	}{
+		{"no meta", location, nil, outputOptions{format: formatJSON}, `{"status":"success","country":"United States","countryCode":"US","query":"8.8.8.8"}`},
+		{"fields keep their order", location, meta, outputOptions{format: formatJSON}, `{"status":"success","country":"United States","countryCode":"US","query":"8.8.8.8",` + metaJSON + `}`},
+		{"empty location", ip_api.Location{}, meta, outputOptions{format: formatJSON}, `{` + metaJSON + `}`},
+		{"upstream without cache times", location, &locationMeta{Source: cache.SourceUpstream}, outputOptions{format: formatJSON}, `{"status":"success","country":"United States","countryCode":"US","query":"8.8.8.8","meta":{"source":"upstream"}}`},
+		{"ecs", location, meta, outputOptions{format: formatJSON, ecs: ecsFlat}, `{"status":"success","country_name":"United States","country_iso_code":"US","query":"8.8.8.8",` + metaJSON + `}`},
+		{"profile", location, meta, outputOptions{format: formatJSON, profile: &profile}, `{"ip":"8.8.8.8",` + metaJSON + `}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := test.location
+			body, err := json.Marshal(toMetaOutput(&location, test.meta, test.options))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.expected {
+				t.Errorf("got %s, expected %s", body, test.expected)
+			}
+		})
+	}
+}
+
+func TestRequestMeta(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		format   string
+		expected bool
+		err      string
+	}{
+		{"not requested", "/json/8.8.8.8", formatJSON, false, ""},
+		{"true", "/json/8.8.8.8?meta=true", formatJSON, true, ""},
+		{"1", "/json/8.8.8.8?meta=1", formatJSON, true, ""},
+		{"false", "/json/8.8.8.8?meta=false", formatJSON, false, ""},
+		{"false with another format", "/json/8.8.8.8?meta=false", formatCSV, false, ""},
+		{"invalid", "/json/8.8.8.8?meta=yes", formatJSON, false, "invalid meta provided, expected true or false"},
+		{"another format", "/json/8.8.8.8?meta=true", formatGeoJSON, false, "meta is only supported for json output"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta, err := requestMeta(httptest.NewRequest("GET", test.url, nil), outputOptions{format: test.format})
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if meta != test.expected {
+				t.Errorf("got %t, expected %t", meta, test.expected)
+			}
+		})
+	}
+}
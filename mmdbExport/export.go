@@ -0,0 +1,413 @@
+package mmdbExport
+
+import (
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+/*
+Options - MMDB export options
+Aggregate - collapse hosts into a single IPv4Prefix/IPv6Prefix network when every cached host in that network has identical data
+IPv4Prefix - prefix length IPv4 hosts are aggregated to (ex: 24)
+IPv6Prefix - prefix length IPv6 hosts are aggregated to (ex: 48)
+ */
+type Options struct {
+	Aggregate  bool
+	IPv4Prefix int
+	IPv6Prefix int
+}
+
+//cityRecord - GeoLite2 City record layout, names are keyed by language
+type cityRecord struct {
+	City         *namedRecord  `json:"city,omitempty"`
+	Continent    *namedRecord  `json:"continent,omitempty"`
+	Country      *namedRecord  `json:"country,omitempty"`
+	Location     *geoLocation  `json:"location,omitempty"`
+	Postal       *postal       `json:"postal,omitempty"`
+	Subdivisions []namedRecord `json:"subdivisions,omitempty"`
+	Traits       *traits       `json:"traits,omitempty"`
+}
+
+type namedRecord struct {
+	Code    string            `json:"code,omitempty"`
+	ISOCode string            `json:"iso_code,omitempty"`
+	Names   map[string]string `json:"names,omitempty"`
+}
+
+type geoLocation struct {
+	Latitude  *float32 `json:"latitude,omitempty"`
+	Longitude *float32 `json:"longitude,omitempty"`
+	TimeZone  string   `json:"time_zone,omitempty"`
+}
+
+type postal struct {
+	Code string `json:"code,omitempty"`
+}
+
+type traits struct {
+	AutonomousSystemNumber       uint32 `json:"autonomous_system_number,omitempty"`
+	AutonomousSystemOrganization string `json:"autonomous_system_organization,omitempty"`
+	ISP                          string `json:"isp,omitempty"`
+	Organization                 string `json:"organization,omitempty"`
+}
+
+//host - a cached IP address and its record
+type host struct {
+	ip     net.IP
+	record *cityRecord
+	//key - serialized record, hosts with the same key have identical data
+	key string
+}
+
+/*
+Export - writes every non-expired, successful cached IP address to w as a GeoLite2 City compatible MMDB database
+cached locations in different languages are merged into the names of a single record
+w - writer for the database
+options - export Options
+
+returns
+number of networks written
+error
+ */
+func Export(w io.Writer, options Options) (int, error) {
+	hosts, languages := cachedHosts()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: "GeoLite2-City",
+		Description:  map[string]string{"en": "ip-api-proxy cache export"},
+		Languages:    languages,
+		RecordSize:   28,
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	var networks int
+	if options.Aggregate {
+		networks = insertAggregated(tree, hosts, options)
+	} else {
+		for _, host := range hosts {
+			if insertHost(tree, host) {
+				networks++
+			}
+		}
+	}
+
+	_, err = tree.WriteTo(w)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return networks, nil
+}
+
+/*
+cachedHosts - reads the successful IP address records from the cache
+
+returns
+hosts sorted by IP address
+languages found in the cache
+ */
+func cachedHosts() ([]*host, []string) {
+	hostMap := map[string]*host{}
+	languageSet := map[string]bool{}
+
+	cache.Records(func(key string, record cache.Record) {
+		if record.Location.Status != "success" {
+			return
+		}
+
+		ip := net.ParseIP(record.Location.Query)
+		if ip == nil {
+			//DNS queries have no address to key the record on
+			return
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+
+		//cache keys are query + lang, no lang is IP-API's default (en)
+		lang := strings.TrimPrefix(key, record.Location.Query)
+		if lang == "" || lang == key {
+			lang = "en"
+		}
+		languageSet[lang] = true
+
+		cachedHost, ok := hostMap[ip.String()]
+		if !ok {
+			cachedHost = &host{ip: ip, record: &cityRecord{}}
+			hostMap[ip.String()] = cachedHost
+		}
+		addLocation(cachedHost.record, &record.Location, lang)
+	})
+
+	hosts := make([]*host, 0, len(hostMap))
+	for _, cachedHost := range hostMap {
+		keyBytes, _ := json.Marshal(cachedHost.record)
+		cachedHost.key = string(keyBytes)
+		hosts = append(hosts, cachedHost)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return compareIPs(hosts[i].ip, hosts[j].ip) < 0
+	})
+
+	var languages []string
+	for lang := range languageSet {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+
+	return hosts, languages
+}
+
+/*
+addLocation - adds a location to a record, names are added under lang
+the non name fields are language independent, so the english location is preferred for them
+record - cityRecord
+location - ip_api location
+lang - language of the location's names
+ */
+func addLocation(record *cityRecord, location *ip_api.Location, lang string) {
+	record.City = addName(record.City, lang, location.City)
+	record.Continent = addName(record.Continent, lang, location.Continent)
+	if record.Continent != nil && location.ContinentCode != "" {
+		record.Continent.Code = location.ContinentCode
+	}
+	record.Country = addName(record.Country, lang, location.Country)
+	if record.Country != nil && location.CountryCode != "" {
+		record.Country.ISOCode = location.CountryCode
+	}
+
+	if location.Region != "" || location.RegionName != "" {
+		var subdivision *namedRecord
+		if len(record.Subdivisions) > 0 {
+			subdivision = &record.Subdivisions[0]
+		}
+		subdivision = addName(subdivision, lang, location.RegionName)
+		if subdivision == nil {
+			subdivision = &namedRecord{}
+		}
+		subdivision.ISOCode = location.Region
+		record.Subdivisions = []namedRecord{*subdivision}
+	}
+
+	if record.Location != nil && lang != "en" {
+		return
+	}
+
+	if location.Lat != nil || location.Lon != nil || location.Timezone != "" {
+		record.Location = &geoLocation{Latitude: location.Lat, Longitude: location.Lon, TimeZone: location.Timezone}
+	}
+	if location.ZIP != "" {
+		record.Postal = &postal{Code: location.ZIP}
+	}
+
+	asNumber, asOrganization := utils.ParseAS(location.AS)
+	if asNumber != 0 || asOrganization != "" || location.ISP != "" || location.Org != "" {
+		record.Traits = &traits{
+			AutonomousSystemNumber:       uint32(asNumber),
+			AutonomousSystemOrganization: asOrganization,
+			ISP:                          location.ISP,
+			Organization:                 location.Org,
+		}
+	}
+}
+
+/*
+addName - adds a name in lang to a namedRecord, creating the namedRecord if it is nil
+namedRecord - existing record, may be nil
+lang - language of the name
+name - name, nothing is added for an empty name
+ */
+func addName(record *namedRecord, lang string, name string) *namedRecord {
+	if name == "" {
+		return record
+	}
+	if record == nil {
+		record = &namedRecord{}
+	}
+	if record.Names == nil {
+		record.Names = map[string]string{}
+	}
+	record.Names[lang] = name
+	return record
+}
+
+/*
+insertAggregated - inserts hosts, collapsing every prefix whose hosts all have identical data into a single network
+tree - mmdbwriter tree
+hosts - hosts sorted by IP address
+options - export Options
+
+returns
+number of networks inserted
+ */
+func insertAggregated(tree *mmdbwriter.Tree, hosts []*host, options Options) int {
+	var networks int
+	for start := 0; start < len(hosts); {
+		network := aggregateNetwork(hosts[start].ip, options)
+
+		//hosts are sorted, so every host in the network follows the first one
+		end := start + 1
+		identical := true
+		for end < len(hosts) && network.Contains(hosts[end].ip) {
+			if hosts[end].key != hosts[start].key {
+				identical = false
+			}
+			end++
+		}
+
+		if identical {
+			if err := tree.Insert(network, toMMDBType(hosts[start].record)); err == nil {
+				networks++
+				start = end
+				continue
+			} else {
+				log.Println("error: inserting aggregated network " + network.String() + ", inserting hosts instead: " + err.Error())
+			}
+		}
+
+		for _, aggregatedHost := range hosts[start:end] {
+			if insertHost(tree, aggregatedHost) {
+				networks++
+			}
+		}
+		start = end
+	}
+	return networks
+}
+
+/*
+aggregateNetwork - gets the network an IP address is aggregated into
+ip - IP address
+options - export Options
+ */
+func aggregateNetwork(ip net.IP, options Options) *net.IPNet {
+	if ip.To4() != nil {
+		mask := net.CIDRMask(options.IPv4Prefix, 32)
+		return &net.IPNet{IP: ip.To4().Mask(mask), Mask: mask}
+	}
+	mask := net.CIDRMask(options.IPv6Prefix, 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+/*
+insertHost - inserts a single host as a /32 or /128 network
+tree - mmdbwriter tree
+host - host to insert
+
+returns
+true if the host was inserted
+ */
+func insertHost(tree *mmdbwriter.Tree, host *host) bool {
+	bits := 128
+	if host.ip.To4() != nil {
+		bits = 32
+	}
+	network := &net.IPNet{IP: host.ip, Mask: net.CIDRMask(bits, bits)}
+	if err := tree.Insert(network, toMMDBType(host.record)); err != nil {
+		log.Println("error: inserting " + network.String() + ": " + err.Error())
+		return false
+	}
+	return true
+}
+
+//compareIPs - compares two IP addresses, IPv4 addresses sort before IPv6 addresses
+func compareIPs(a net.IP, b net.IP) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return int(a[i]) - int(b[i])
+		}
+	}
+	return 0
+}
+
+/*
+toMMDBType - converts a cityRecord into the mmdbtype map written to the database
+record - cityRecord
+ */
+func toMMDBType(record *cityRecord) mmdbtype.Map {
+	recordMap := mmdbtype.Map{}
+	if record.City != nil {
+		recordMap["city"] = namedRecordType(record.City)
+	}
+	if record.Continent != nil {
+		recordMap["continent"] = namedRecordType(record.Continent)
+	}
+	if record.Country != nil {
+		recordMap["country"] = namedRecordType(record.Country)
+	}
+	if record.Location != nil {
+		locationMap := mmdbtype.Map{}
+		if record.Location.Latitude != nil {
+			locationMap["latitude"] = mmdbtype.Float64(*record.Location.Latitude)
+		}
+		if record.Location.Longitude != nil {
+			locationMap["longitude"] = mmdbtype.Float64(*record.Location.Longitude)
+		}
+		if record.Location.TimeZone != "" {
+			locationMap["time_zone"] = mmdbtype.String(record.Location.TimeZone)
+		}
+		recordMap["location"] = locationMap
+	}
+	if record.Postal != nil {
+		recordMap["postal"] = mmdbtype.Map{"code": mmdbtype.String(record.Postal.Code)}
+	}
+	if len(record.Subdivisions) > 0 {
+		subdivisions := mmdbtype.Slice{}
+		for i := range record.Subdivisions {
+			subdivisions = append(subdivisions, namedRecordType(&record.Subdivisions[i]))
+		}
+		recordMap["subdivisions"] = subdivisions
+	}
+	if record.Traits != nil {
+		traitsMap := mmdbtype.Map{}
+		if record.Traits.AutonomousSystemNumber != 0 {
+			traitsMap["autonomous_system_number"] = mmdbtype.Uint32(record.Traits.AutonomousSystemNumber)
+		}
+		if record.Traits.AutonomousSystemOrganization != "" {
+			traitsMap["autonomous_system_organization"] = mmdbtype.String(record.Traits.AutonomousSystemOrganization)
+		}
+		if record.Traits.ISP != "" {
+			traitsMap["isp"] = mmdbtype.String(record.Traits.ISP)
+		}
+		if record.Traits.Organization != "" {
+			traitsMap["organization"] = mmdbtype.String(record.Traits.Organization)
+		}
+		recordMap["traits"] = traitsMap
+	}
+	return recordMap
+}
+
+//namedRecordType - converts a namedRecord into an mmdbtype map
+func namedRecordType(record *namedRecord) mmdbtype.Map {
+	recordMap := mmdbtype.Map{}
+	if record.Code != "" {
+		recordMap["code"] = mmdbtype.String(record.Code)
+	}
+	if record.ISOCode != "" {
+		recordMap["iso_code"] = mmdbtype.String(record.ISOCode)
+	}
+	if len(record.Names) > 0 {
+		names := mmdbtype.Map{}
+		for lang, name := range record.Names {
+			names[mmdbtype.String(lang)] = mmdbtype.String(name)
+		}
+		recordMap["names"] = names
+	}
+	return recordMap
+}
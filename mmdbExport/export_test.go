@@ -0,0 +1,160 @@
+package mmdbExport
+
+import (
+	"bytes"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//cityResult - the GeoLite2 City fields the tests read back, like a GeoLite2 City reader would
+type cityResult struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+		TimeZone  string  `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+	Traits struct {
+		AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+		AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+		ISP                          string `maxminddb:"isp"`
+	} `maxminddb:"traits"`
+}
+
+//seedCache - caches the locations the tests export
+func seedCache(t *testing.T) {
+	var lat float32 = 37.5
+	var lon float32 = -122.25
+	us := ip_api.Location{Status: "success", Continent: "North America", ContinentCode: "NA", Country: "United States", CountryCode: "US", Region: "CA", City: "Mountain View", ZIP: "94043", Lat: &lat, Lon: &lon, Timezone: "America/Los_Angeles", ISP: "Google LLC", AS: "AS15169 Google LLC"}
+	usDE := us
+	usDE.Country = "Vereinigte Staaten"
+	au := ip_api.Location{Status: "success", ContinentCode: "OC", Country: "Australia", CountryCode: "AU", City: "Sydney", AS: "AS13335 Cloudflare, Inc."}
+	nz := au
+	nz.Country = "New Zealand"
+	nz.CountryCode = "NZ"
+
+	locations := map[string]ip_api.Location{
+		//identical hosts of 8.8.8.0/24
+		"8.8.8.1":   withQuery(us, "8.8.8.1"),
+		"8.8.8.1de": withQuery(usDE, "8.8.8.1"),
+		"8.8.8.2":   withQuery(us, "8.8.8.2"),
+		"8.8.8.2de": withQuery(usDE, "8.8.8.2"),
+		//different hosts of 1.1.1.0/24
+		"1.1.1.1": withQuery(au, "1.1.1.1"),
+		"1.1.1.2": withQuery(nz, "1.1.1.2"),
+		"2606:4700::1": withQuery(au, "2606:4700::1"),
+		//left out of the export
+		"9.9.9.9":  {Status: "fail", Message: "invalid query", Query: "9.9.9.9"},
+		"dns.google": withQuery(us, "dns.google"),
+	}
+	cache.FastCacheCache.Reset()
+	for key, location := range locations {
+		if _, err := cache.AddLocation(key, location, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func withQuery(location ip_api.Location, query string) ip_api.Location {
+	location.Query = query
+	return location
+}
+
+func TestExport(t *testing.T) {
+	seedCache(t)
+	defer cache.FastCacheCache.Reset()
+
+	tests := []struct {
+		name     string
+		options  Options
+		networks int
+		expected map[string]string
+	}{
+		{"hosts", Options{}, 5, map[string]string{"8.8.8.1": "8.8.8.1/32", "8.8.8.2": "8.8.8.2/32", "1.1.1.1": "1.1.1.1/32", "1.1.1.2": "1.1.1.2/32", "2606:4700::1": "2606:4700::1/128"}},
+		{"aggregated", Options{Aggregate: true, IPv4Prefix: 24, IPv6Prefix: 48}, 4, map[string]string{"8.8.8.1": "8.8.8.0/24", "8.8.8.200": "8.8.8.0/24", "1.1.1.1": "1.1.1.1/32", "1.1.1.2": "1.1.1.2/32", "2606:4700::1": "2606:4700::/48", "2606:4700::ffff": "2606:4700::/48"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var database bytes.Buffer
+			networks, err := Export(&database, test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if networks != test.networks {
+				t.Errorf("got %d networks, expected %d", networks, test.networks)
+			}
+
+			reader, err := maxminddb.FromBytes(database.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reader.Metadata.DatabaseType != "GeoLite2-City" || !reflect.DeepEqual(reader.Metadata.Languages, []string{"de", "en"}) {
+				t.Errorf("got database type %s and languages %v, expected GeoLite2-City and [de en]", reader.Metadata.DatabaseType, reader.Metadata.Languages)
+			}
+
+			for ip, expectedNetwork := range test.expected {
+				var record cityResult
+				network, ok, err := reader.LookupNetwork(net.ParseIP(ip), &record)
+				if err != nil || !ok {
+					t.Fatalf("got %v looking up %s, expected a record", err, ip)
+				}
+				if network.String() != expectedNetwork {
+					t.Errorf("got network %s for %s, expected %s", network, ip, expectedNetwork)
+				}
+			}
+
+			for _, ip := range []string{"9.9.9.9", "8.8.9.1"} {
+				var record cityResult
+				if _, ok, _ := reader.LookupNetwork(net.ParseIP(ip), &record); ok {
+					t.Errorf("got a record for %s, expected none", ip)
+				}
+			}
+
+			var record cityResult
+			if err = reader.Lookup(net.ParseIP("8.8.8.1"), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Country.ISOCode != "US" || !reflect.DeepEqual(record.Country.Names, map[string]string{"en": "United States", "de": "Vereinigte Staaten"}) {
+				t.Errorf("got country %s %v, expected US with en and de names", record.Country.ISOCode, record.Country.Names)
+			}
+			if record.City.Names["en"] != "Mountain View" || record.Continent.Code != "NA" || record.Postal.Code != "94043" || len(record.Subdivisions) != 1 || record.Subdivisions[0].ISOCode != "CA" {
+				t.Errorf("got city %v, continent %s, postal %s and subdivisions %v", record.City.Names, record.Continent.Code, record.Postal.Code, record.Subdivisions)
+			}
+			if record.Location.Latitude != 37.5 || record.Location.Longitude != -122.25 || record.Location.TimeZone != "America/Los_Angeles" {
+				t.Errorf("got location %+v", record.Location)
+			}
+			if record.Traits.AutonomousSystemNumber != 15169 || record.Traits.AutonomousSystemOrganization != "Google LLC" || record.Traits.ISP != "Google LLC" {
+				t.Errorf("got traits %+v", record.Traits)
+			}
+
+			record = cityResult{}
+			if err = reader.Lookup(net.ParseIP("1.1.1.2"), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Country.ISOCode != "NZ" || record.Traits.AutonomousSystemNumber != 13335 || record.Traits.AutonomousSystemOrganization != "Cloudflare, Inc." {
+				t.Errorf("got country %s and traits %+v, expected NZ and AS13335", record.Country.ISOCode, record.Traits)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/vmihailenco/msgpack/v5"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//Negotiated encodings of the json and batch endpoints, on top of json, geojson and csv
+const (
+	formatNDJSON  = "ndjson"
+	formatMsgPack = "msgpack"
+)
+
+//csvHeaderContentType - content type of csv output with a header row, the csv endpoint has none like IP-API
+const csvHeaderContentType = "text/csv; charset=utf-8; header=present"
+
+//negotiableFormats - formats the json and batch endpoints can be asked for with format=
+var negotiableFormats = []string{formatJSON, formatGeoJSON, formatNDJSON, formatCSV, formatMsgPack}
+
+//acceptFormats - media types of the Accept header and the format they select
+var acceptFormats = map[string]string{
+	"application/json":        formatJSON,
+	"application/*":           formatJSON,
+	"*/*":                     formatJSON,
+	"application/geo+json":    formatGeoJSON,
+	"application/x-ndjson":    formatNDJSON,
+	"application/ndjson":      formatNDJSON,
+	"application/jsonlines":   formatNDJSON,
+	"text/csv":                formatCSV,
+	"application/msgpack":     formatMsgPack,
+	"application/x-msgpack":   formatMsgPack,
+	"application/vnd.msgpack": formatMsgPack,
+}
+
+/*
+negotiateFormat - gets the format a json or batch request asked for
+the format parameter takes precedence over the Accept header, an Accept header without any supported type falls back to json
+
+returns
+one of negotiableFormats
+error if the format parameter isn't supported
+ */
+func negotiateFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, negotiableFormat := range negotiableFormats {
+			if strings.EqualFold(format, negotiableFormat) {
+				return negotiableFormat, nil
+			}
+		}
+		return formatJSON, errors.New("invalid format provided, expected one of: " + strings.Join(negotiableFormats, ","))
+	}
+
+	//pick the supported media type with the highest quality, the first one wins a tie
+	format := formatJSON
+	bestQuality := -1.0
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		acceptFormat, ok := acceptFormats[mediaType]
+		if !ok {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > bestQuality && quality > 0 {
+			format = acceptFormat
+			bestQuality = quality
+		}
+	}
+	return format, nil
+}
+
+/*
+requestedFieldOrder - gets the requested field names in the order they were requested, used for the csv header row
+numeric and empty fields values have no order, so IP-API's output order is used
+fields - fields parameter
+parsedFields - fields parsed from the parameter, cache.DefaultFields if 0
+ */
+func requestedFieldOrder(fields string, parsedFields cache.Fields) []string {
+	if parsedFields == 0 {
+		return cache.DefaultFields.Names()
+	}
+	if _, err := strconv.ParseUint(fields, 10, 32); err == nil || fields == "" {
+		return parsedFields.Names()
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(fields, ",") {
+		name = strings.TrimSpace(name)
+		if !seen[name] && parsedFields&cache.FieldByName(name) != 0 {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	return names
+}
+
+/*
+encodeOutputs - encodes locations in one of the negotiated ndjson, msgpack or csv formats
+locations - ip_api locations, already projected to the requested fields
+options - outputOptions of the request
+batch - encode a batch response, msgpack batches are an array while a single location is a map
+
+returns
+encoded locations
+ */
+func encodeOutputs(locations []ip_api.Location, options outputOptions, batch bool) []byte {
+	var buffer bytes.Buffer
+	switch options.format {
+	case formatNDJSON:
+		for i := range locations {
+			line, _ := json.Marshal(toOutput(&locations[i], options))
+			buffer.Write(line)
+			buffer.WriteByte('\n')
+		}
+	case formatMsgPack:
+		encoder := msgpack.NewEncoder(&buffer)
+		//use the json names, so msgpack output has the same keys as the json output
+		encoder.SetCustomStructTag("json")
+		encoder.SetOmitEmpty(true)
+		if batch {
+			outputs := make([]interface{}, len(locations))
+			for i := range locations {
+				outputs[i] = toOutput(&locations[i], options)
+			}
+			_ = encoder.Encode(outputs)
+		} else {
+			_ = encoder.Encode(toOutput(&locations[0], options))
+		}
+	case formatCSV:
+		csvWriter := csv.NewWriter(&buffer)
+		columns := tableColumns(options)
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		_ = csvWriter.Write(header)
+		for i := range locations {
+			_ = csvWriter.Write(tableRow(&locations[i], columns, options))
+		}
+		csvWriter.Flush()
+	}
+	return buffer.Bytes()
+}
+
+/*
+streamLocations - writes a batch of locations to the response one at a time, as writeLocations does without metas, so a large batch is never held in memory
+statusCode - HTTP status code
+total - number of locations each passes on, msgpack arrays start with their length
+each - passes every location to write in order, it is called twice for GeoJSON, whose failed locations follow the features
+options - outputOptions of the request
+
+returns
+error of each or of the response writer, the response is cut short
+ */
+func streamLocations(w http.ResponseWriter, statusCode int, total int, each func(write func(location *ip_api.Location) error) error, options outputOptions) error {
+	setContentType(w, options)
+	w.WriteHeader(statusCode)
+	writer := bufio.NewWriter(w)
+
+	var err error
+	switch options.format {
+	case formatGeoJSON:
+		//failed locations are listed in the failed foreign member, after every feature
+		_, _ = writer.WriteString(`{"type":"FeatureCollection","features":[`)
+		count := 0
+		err = each(func(location *ip_api.Location) error {
+			if location.Status == "fail" {
+				return nil
+			}
+			return writeJSONElement(writer, &count, toGeoJSONFeature(location, options))
+		})
+		_, _ = writer.WriteString("]")
+		count = 0
+		if err == nil {
+			err = each(func(location *ip_api.Location) error {
+				if location.Status != "fail" {
+					return nil
+				}
+				if count == 0 {
+					_, _ = writer.WriteString(`,"failed":[`)
+				}
+				return writeJSONElement(writer, &count, toOutput(location, options))
+			})
+		}
+		if count > 0 {
+			_, _ = writer.WriteString("]")
+		}
+		_, _ = writer.WriteString("}")
+	case formatNDJSON:
+		err = each(func(location *ip_api.Location) error {
+			line, _ := json.Marshal(toOutput(location, options))
+			_, err := writer.Write(append(line, '\n'))
+			return err
+		})
+	case formatMsgPack:
+		encoder := msgpack.NewEncoder(writer)
+		encoder.SetCustomStructTag("json")
+		encoder.SetOmitEmpty(true)
+		err = encoder.EncodeArrayLen(total)
+		if err == nil {
+			err = each(func(location *ip_api.Location) error {
+				return encoder.Encode(toOutput(location, options))
+			})
+		}
+	case formatCSV:
+		csvWriter := csv.NewWriter(writer)
+		columns := tableColumns(options)
+		header := make([]string, len(columns))
+		for i, column := range columns {
+			header[i] = column.name
+		}
+		err = csvWriter.Write(header)
+		if err == nil {
+			err = each(func(location *ip_api.Location) error {
+				return csvWriter.Write(tableRow(location, columns, options))
+			})
+		}
+		csvWriter.Flush()
+	default:
+		_, _ = writer.WriteString("[")
+		count := 0
+		err = each(func(location *ip_api.Location) error {
+			return writeJSONElement(writer, &count, toOutput(location, options))
+		})
+		_, _ = writer.WriteString("]")
+	}
+
+	if flushErr := writer.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+/*
+writeJSONElement - writes a value as the next element of a json array
+count - elements written so far, incremented
+ */
+func writeJSONElement(writer *bufio.Writer, count *int, value interface{}) error {
+	element, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if *count > 0 {
+		_ = writer.WriteByte(',')
+	}
+	*count++
+	_, err = writer.Write(element)
+	return err
+}
+
+/*
+tableColumn - a single column of csv output
+name - header name (IP-API, flat ECS or profile name)
+field - IP-API field the column is read from, empty for profile columns
+ */
+type tableColumn struct {
+	name  string
+	field string
+}
+
+/*
+tableColumns - gets the csv columns of a request
+profiles output their fields in profile order, otherwise the requested fields are output in the requested order
+options - outputOptions of the request
+ */
+func tableColumns(options outputOptions) []tableColumn {
+	var columns []tableColumn
+	if options.profile != nil {
+		for _, field := range options.profile.Fields {
+			columns = append(columns, tableColumn{name: field.Name})
+		}
+		return columns
+	}
+
+	fieldOrder := options.fieldOrder
+	if len(fieldOrder) == 0 {
+		fieldOrder = requestedFieldOrder("", options.fields)
+	}
+	for _, name := range fieldOrder {
+		column := tableColumn{name: name, field: name}
+		//csv is flat, so ECS output uses the flat ECS names
+		if options.ecs != "" {
+			column.name = ecsFieldNames[name]
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+/*
+tableRow - gets the csv values of a location, missing fields are empty
+location - ip_api location
+columns - tableColumns of the request
+options - outputOptions of the request
+ */
+func tableRow(location *ip_api.Location, columns []tableColumn, options outputOptions) []string {
+	row := make([]string, len(columns))
+	if options.profile != nil {
+		values := map[string]string{}
+		for _, field := range profileFormatFields(location, options.profile) {
+			values[field.name] = field.value
+		}
+		for i, column := range columns {
+			row[i] = values[column.name]
+		}
+		return row
+	}
+
+	for i, column := range columns {
+		row[i], _ = cache.LocationFieldValue(location, column.field)
+	}
+	return row
+}
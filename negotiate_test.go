@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiatedOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		accept string
+		format string
+		header bool
+		err    string
+	}{
+		{"no accept header", "/json/8.8.8.8", "", formatJSON, false, ""},
+		{"json", "/json/8.8.8.8", "application/json", formatJSON, false, ""},
+		{"any type", "/json/8.8.8.8", "*/*", formatJSON, false, ""},
+		{"geojson", "/json/8.8.8.8", "application/geo+json", formatGeoJSON, false, ""},
+		{"ndjson", "/json/8.8.8.8", "application/x-ndjson", formatNDJSON, false, ""},
+		{"msgpack", "/json/8.8.8.8", "application/vnd.msgpack", formatMsgPack, false, ""},
+		{"csv has a header row", "/json/8.8.8.8", "text/csv", formatCSV, true, ""},
+		{"media type parameters", "/json/8.8.8.8", "text/csv; charset=utf-8", formatCSV, true, ""},
+		{"unsupported type falls back to json", "/json/8.8.8.8", "text/html", formatJSON, false, ""},
+		{"first supported type", "/json/8.8.8.8", "text/html, text/csv, application/json", formatCSV, true, ""},
+		{"highest quality wins", "/json/8.8.8.8", "application/json;q=0.5, text/csv;q=0.9", formatCSV, true, ""},
+		{"default quality is 1", "/json/8.8.8.8", "text/csv;q=0.8, application/geo+json", formatGeoJSON, false, ""},
+		{"first wins a tie", "/json/8.8.8.8", "application/x-ndjson;q=0.5, text/csv;q=0.5", formatNDJSON, false, ""},
+		{"q=0 is not acceptable", "/json/8.8.8.8", "text/csv;q=0", formatJSON, false, ""},
+		{"q=0 doesn't beat a lower quality", "/json/8.8.8.8", "text/csv;q=0, application/msgpack;q=0.1", formatMsgPack, false, ""},
+		{"invalid quality is skipped", "/json/8.8.8.8", "text/csv;q=high, application/geo+json;q=0.2", formatGeoJSON, false, ""},
+		{"browser accept", "/json/8.8.8.8", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", formatJSON, false, ""},
+		{"format parameter", "/json/8.8.8.8?format=ndjson", "", formatNDJSON, false, ""},
+		{"format parameter over accept", "/json/8.8.8.8?format=geojson", "text/csv", formatGeoJSON, false, ""},
+		{"format parameter is case insensitive", "/json/8.8.8.8?format=CSV", "", formatCSV, true, ""},
+		{"invalid format parameter", "/json/8.8.8.8?format=xml", "", formatJSON, false, "invalid format provided, expected one of: json,geojson,ndjson,csv,msgpack"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.url, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept", test.accept)
+			}
+			options, err := negotiatedOptions(r)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if options.format != test.format || options.header != test.header {
+				t.Errorf("got format %s with header %t, expected %s with header %t", options.format, options.header, test.format, test.header)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package outputProfile
+
+//Builtin - profiles available without any config, a config profile of the same name replaces them
+var Builtin = map[string]Profile{
+	//ecs - Elastic Common Schema geo and as fields, see https://www.elastic.co/guide/en/ecs/current/ecs-geo.html
+	"ecs": {
+		Fields: []Field{
+			{Name: "ip", Field: "query", Transform: "ip"},
+			{Name: "domain", Field: "query", Transform: "domain"},
+			{Name: "geo.location.lat", Field: "lat"},
+			{Name: "geo.location.lon", Field: "lon"},
+			{Name: "geo.continent_name", Field: "continent"},
+			{Name: "geo.continent_code", Field: "continentCode"},
+			{Name: "geo.country_name", Field: "country"},
+			{Name: "geo.country_iso_code", Field: "countryCode"},
+			{Name: "geo.region_name", Field: "regionName"},
+			{Name: "geo.region_iso_code", Template: "{countryCode}-{region}"},
+			{Name: "geo.city_name", Field: "city"},
+			{Name: "geo.postal_code", Field: "zip"},
+			{Name: "geo.timezone", Field: "timezone"},
+			{Name: "as.number", Field: "as", Transform: "asNumber"},
+			{Name: "as.organization.name", Field: "as", Transform: "asOrganization"},
+		},
+	},
+	//otel - OpenTelemetry client and geo semantic convention attributes, see https://opentelemetry.io/docs/specs/semconv/attributes-registry/geo/
+	"otel": {
+		Flat: true,
+		Fields: []Field{
+			{Name: "client.address", Field: "query"},
+			{Name: "client.geo.continent.code", Field: "continentCode"},
+			{Name: "client.geo.country.iso_code", Field: "countryCode"},
+			{Name: "client.geo.region.iso_code", Template: "{countryCode}-{region}"},
+			{Name: "client.geo.locality.name", Field: "city"},
+			{Name: "client.geo.postal_code", Field: "zip"},
+			{Name: "client.geo.location.lat", Field: "lat"},
+			{Name: "client.geo.location.lon", Field: "lon"},
+		},
+	},
+}
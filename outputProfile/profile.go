@@ -0,0 +1,367 @@
+package outputProfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"github.com/vmihailenco/msgpack/v5"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+Profile - named output layout, maps IP-API fields onto the keys a consumer expects
+Flat - keep dotted names as single keys (ex: "client.geo.country.iso_code"), instead of nesting them into objects
+Fields - output fields, in output order, IP-API fields which aren't listed are dropped
+ */
+type Profile struct {
+	Flat   bool    `json:"flat,omitempty"`
+	Fields []Field `json:"fields,omitempty"`
+}
+
+/*
+Field - a single output field of a Profile
+Name - output key, dots nest the key into objects unless the profile is flat (ex: geo.country_iso_code)
+Field - IP-API field the value is taken from (ex: countryCode)
+Template - derives a string value from IP-API fields instead (ex: "{countryCode}-{region}"), used when Field is empty
+Transform - optional conversion of the value, one of Transforms
+ */
+type Field struct {
+	Name      string `json:"name,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Template  string `json:"template,omitempty"`
+	Transform string `json:"transform,omitempty"`
+}
+
+/*
+Value - a single output value of a profile
+Name - output key
+Value - string, float32, bool or int value
+ */
+type Value struct {
+	Name  string
+	Value interface{}
+}
+
+//Transforms - conversions a Field's value can go through
+var Transforms = []string{"asNumber", "asOrganization", "lowercase", "uppercase", "ip", "domain"}
+
+//failedFields - fields output for a failed query regardless of the profile
+const failedFields = cache.FieldStatus | cache.FieldMessage | cache.FieldQuery
+
+//templateRegexp - matches the {field} placeholders of a template
+var templateRegexp = regexp.MustCompile(`{([a-zA-Z]+)}`)
+
+/*
+Validate - checks that a profile only references known fields and transforms, and that its names don't collide
+ */
+func (p *Profile) Validate() error {
+	if len(p.Fields) == 0 {
+		return errors.New("error: profile has no fields")
+	}
+
+	names := map[string]bool{}
+	for _, field := range p.Fields {
+		if field.Name == "" {
+			return errors.New("error: profile field name cannot be empty")
+		}
+		if names[field.Name] {
+			return errors.New("error: duplicate profile field name: " + field.Name)
+		}
+		names[field.Name] = true
+
+		if field.Field != "" && field.Template != "" {
+			return errors.New("error: profile field " + field.Name + " cannot have both a field and a template")
+		} else if field.Field != "" {
+			if cache.FieldByName(field.Field) == 0 {
+				return errors.New("error: profile field " + field.Name + " has an unknown field: " + field.Field)
+			}
+		} else if field.Template != "" {
+			for _, match := range templateRegexp.FindAllStringSubmatch(field.Template, -1) {
+				if cache.FieldByName(match[1]) == 0 {
+					return errors.New("error: profile field " + field.Name + " template has an unknown field: " + match[1])
+				}
+			}
+		} else {
+			return errors.New("error: profile field " + field.Name + " needs a field or a template")
+		}
+
+		if field.Transform != "" && !validTransform(field.Transform) {
+			return errors.New("error: profile field " + field.Name + " has an unknown transform: " + field.Transform + ", expected one of: " + strings.Join(Transforms, ","))
+		}
+	}
+
+	//a nested name can't also be the object of another name (ex: geo and geo.city_name)
+	if !p.Flat {
+		for name := range names {
+			parts := strings.Split(name, ".")
+			for i := 1; i < len(parts); i++ {
+				if names[strings.Join(parts[:i], ".")] {
+					return errors.New("error: profile field " + name + " is nested under another profile field: " + strings.Join(parts[:i], "."))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+RequiredFields - gets the IP-API Fields a profile needs to be looked up with
+ */
+func (p *Profile) RequiredFields() cache.Fields {
+	fields := failedFields
+	for _, field := range p.Fields {
+		if field.Field != "" {
+			fields |= cache.FieldByName(field.Field)
+		}
+		for _, match := range templateRegexp.FindAllStringSubmatch(field.Template, -1) {
+			fields |= cache.FieldByName(match[1])
+		}
+	}
+	return fields
+}
+
+/*
+Values - gets the profile's values of a location in output order, empty values are left out
+location - ip_api location
+ */
+func (p *Profile) Values(location *ip_api.Location) []Value {
+	var values []Value
+	for _, field := range p.Fields {
+		var value interface{}
+		if field.Field != "" {
+			value = fieldValue(location, field.Field)
+		} else {
+			value = templateValue(location, field.Template)
+		}
+		if value != nil && field.Transform != "" {
+			value = transform(value, field.Transform)
+		}
+		if value != nil {
+			values = append(values, Value{Name: field.Name, Value: value})
+		}
+	}
+	return values
+}
+
+/*
+Output - converts a location into the profile's json output, with its keys in profile order
+failed queries are output as IP-API's status, message and query, so that failures look the same for every profile
+location - ip_api location
+ */
+func (p *Profile) Output(location *ip_api.Location) *Object {
+	output := &Object{}
+
+	if location.Status == "fail" {
+		for _, name := range failedFields.Names() {
+			if value := fieldValue(location, name); value != nil {
+				output.Set(name, value)
+			}
+		}
+		return output
+	}
+
+	for _, value := range p.Values(location) {
+		if p.Flat {
+			output.Set(value.Name, value.Value)
+			continue
+		}
+
+		//walk down the dotted name, creating objects along the way
+		object := output
+		parts := strings.Split(value.Name, ".")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := object.Get(part).(*Object)
+			if !ok {
+				child = &Object{}
+				object.Set(part, child)
+			}
+			object = child
+		}
+		object.Set(parts[len(parts)-1], value.Value)
+	}
+	return output
+}
+
+/*
+Object - a json object which keeps its keys in the order they were set
+keys - keys in order
+values - values by key
+ */
+type Object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+//Set - sets the value of a key, a new key goes last
+func (o *Object) Set(key string, value interface{}) {
+	if o.values == nil {
+		o.values = map[string]interface{}{}
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+//Get - gets the value of a key, nil if it isn't set
+func (o *Object) Get(key string) interface{} {
+	return o.values[key]
+}
+
+//Keys - gets the keys in order
+func (o *Object) Keys() []string {
+	return o.keys
+}
+
+//MarshalJSON - encodes the object with its keys in order
+func (o *Object) MarshalJSON() ([]byte, error) {
+	buffer := bytes.NewBufferString("{")
+	for i, key := range o.keys {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		keyBytes, _ := json.Marshal(key)
+		valueBytes, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(keyBytes)
+		buffer.WriteByte(':')
+		buffer.Write(valueBytes)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+//EncodeMsgpack - encodes the object as a msgpack map with its keys in order
+func (o *Object) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	err := encoder.EncodeMapLen(len(o.keys))
+	for _, key := range o.keys {
+		if err == nil {
+			err = encoder.EncodeString(key)
+		}
+		if err == nil {
+			err = encoder.Encode(o.values[key])
+		}
+	}
+	return err
+}
+
+//validTransform - checks if a transform is one of Transforms
+func validTransform(name string) bool {
+	for _, transform := range Transforms {
+		if transform == name {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+fieldValue - gets the typed value of a single location field
+location - ip_api location
+field - IP-API field name
+
+returns
+string, float32 or bool value, nil if the field is empty
+ */
+func fieldValue(location *ip_api.Location, field string) interface{} {
+	value, kind := cache.LocationFieldValue(location, field)
+	if value == "" {
+		return nil
+	}
+	switch kind {
+	case "d":
+		number, _ := strconv.ParseFloat(value, 32)
+		return float32(number)
+	case "b":
+		return value == "true"
+	}
+	return value
+}
+
+/*
+templateValue - fills in a template's {field} placeholders
+location - ip_api location
+template - template string (ex: "{countryCode}-{region}")
+
+returns
+string value, nil if any of the placeholders is empty
+ */
+func templateValue(location *ip_api.Location, template string) interface{} {
+	empty := false
+	value := templateRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		fieldValue := fieldValue(location, placeholder[1:len(placeholder)-1])
+		if fieldValue == nil {
+			empty = true
+			return ""
+		}
+		return valueString(fieldValue)
+	})
+	if empty {
+		return nil
+	}
+	return value
+}
+
+/*
+transform - converts a value with one of Transforms
+value - non nil value
+name - transform name
+
+returns
+converted value, nil if nothing is left
+ */
+func transform(value interface{}, name string) interface{} {
+	stringValue := valueString(value)
+	switch name {
+	case "asNumber":
+		if number, _ := utils.ParseAS(stringValue); number != 0 {
+			return number
+		}
+		return nil
+	case "asOrganization":
+		//without a number, as isn't an organization IP-API knows the AS of
+		if number, organization := utils.ParseAS(stringValue); number != 0 && organization != "" {
+			return organization
+		}
+		return nil
+	case "lowercase":
+		return strings.ToLower(stringValue)
+	case "uppercase":
+		return strings.ToUpper(stringValue)
+	case "ip":
+		if net.ParseIP(stringValue) != nil {
+			return stringValue
+		}
+		return nil
+	case "domain":
+		if net.ParseIP(stringValue) == nil {
+			return stringValue
+		}
+		return nil
+	}
+	return value
+}
+
+//valueString - gets the string form of a profile value
+func valueString(value interface{}) string {
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue
+	case float32:
+		return strconv.FormatFloat(float64(typedValue), 'f', -1, 32)
+	case bool:
+		return strconv.FormatBool(typedValue)
+	case int:
+		return strconv.Itoa(typedValue)
+	}
+	return ""
+}
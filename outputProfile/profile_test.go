@@ -0,0 +1,157 @@
+package outputProfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/vmihailenco/msgpack/v5"
+	"strings"
+	"testing"
+)
+
+func getLocation() ip_api.Location {
+	var lat float32 = 37.751
+	var lon float32 = -97.822
+	var mobile = false
+
+	return ip_api.Location{
+		Status:        "success",
+		ContinentCode: "NA",
+		Country:       "United States",
+		CountryCode:   "US",
+		Region:        "CA",
+		City:          "Mountain View",
+		Lat:           &lat,
+		Lon:           &lon,
+		AS:            "AS15169 Google LLC",
+		Mobile:        &mobile,
+		Query:         "8.8.8.8",
+	}
+}
+
+func TestOutput(t *testing.T) {
+	custom := Profile{
+		Fields: []Field{
+			{Name: "country", Field: "countryCode", Transform: "lowercase"},
+			{Name: "network.asn", Field: "as", Transform: "asNumber"},
+			{Name: "network.org", Field: "as", Transform: "asOrganization"},
+			{Name: "network.mobile", Field: "mobile"},
+			{Name: "host", Field: "query", Transform: "domain"},
+			{Name: "district", Field: "district"},
+			{Name: "region", Template: "{countryCode}/{region}/{district}"},
+		},
+	}
+
+	domainLocation := getLocation()
+	domainLocation.Query = "dns.google"
+	noASLocation := getLocation()
+	noASLocation.AS = "Google LLC"
+
+	tests := []struct {
+		name     string
+		profile  Profile
+		location ip_api.Location
+		expected string
+	}{
+		{
+			"ecs builtin",
+			Builtin["ecs"],
+			getLocation(),
+			`{"ip":"8.8.8.8","geo":{"location":{"lat":37.751,"lon":-97.822},"continent_code":"NA","country_name":"United States","country_iso_code":"US","region_iso_code":"US-CA","city_name":"Mountain View"},"as":{"number":15169,"organization":{"name":"Google LLC"}}}`,
+		},
+		{
+			"otel builtin is flat",
+			Builtin["otel"],
+			getLocation(),
+			`{"client.address":"8.8.8.8","client.geo.continent.code":"NA","client.geo.country.iso_code":"US","client.geo.region.iso_code":"US-CA","client.geo.locality.name":"Mountain View","client.geo.location.lat":37.751,"client.geo.location.lon":-97.822}`,
+		},
+		{
+			"custom transforms, empty fields and templates are left out",
+			custom,
+			getLocation(),
+			`{"country":"us","network":{"asn":15169,"org":"Google LLC","mobile":false}}`,
+		},
+		{
+			"domain query",
+			custom,
+			domainLocation,
+			`{"country":"us","network":{"asn":15169,"org":"Google LLC","mobile":false},"host":"dns.google"}`,
+		},
+		{
+			"as without number",
+			custom,
+			noASLocation,
+			`{"country":"us","network":{"mobile":false}}`,
+		},
+		{
+			"failed query",
+			Builtin["ecs"],
+			ip_api.Location{Status: "fail", Message: "private range", Query: "10.0.0.1"},
+			`{"status":"fail","message":"private range","query":"10.0.0.1"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := test.location
+			body, err := json.Marshal(test.profile.Output(&location))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.expected {
+				t.Errorf("got %s, expected %s", body, test.expected)
+			}
+
+			//msgpack keeps the profile order too
+			packed, err := msgpack.Marshal(test.profile.Output(&location))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			decoder := msgpack.NewDecoder(bytes.NewReader(packed))
+			length, err := decoder.DecodeMapLen()
+			for i := 0; i < length && err == nil; i++ {
+				var key string
+				if key, err = decoder.DecodeString(); err == nil {
+					keys = append(keys, key)
+					err = decoder.Skip()
+				}
+			}
+			if expected := test.profile.Output(&location).Keys(); err != nil || strings.Join(keys, ",") != strings.Join(expected, ",") {
+				t.Errorf("got msgpack keys %v (%v), expected %v", keys, err, expected)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		err     string
+	}{
+		{"ecs builtin", Builtin["ecs"], ""},
+		{"otel builtin", Builtin["otel"], ""},
+		{"no fields", Profile{}, "error: profile has no fields"},
+		{"empty name", Profile{Fields: []Field{{Field: "country"}}}, "error: profile field name cannot be empty"},
+		{"duplicate name", Profile{Fields: []Field{{Name: "country", Field: "country"}, {Name: "country", Field: "countryCode"}}}, "error: duplicate profile field name: country"},
+		{"field and template", Profile{Fields: []Field{{Name: "country", Field: "country", Template: "{country}"}}}, "error: profile field country cannot have both a field and a template"},
+		{"no field or template", Profile{Fields: []Field{{Name: "country"}}}, "error: profile field country needs a field or a template"},
+		{"unknown field", Profile{Fields: []Field{{Name: "country", Field: "nation"}}}, "error: profile field country has an unknown field: nation"},
+		{"unknown template field", Profile{Fields: []Field{{Name: "region", Template: "{nation}-{region}"}}}, "error: profile field region template has an unknown field: nation"},
+		{"unknown transform", Profile{Fields: []Field{{Name: "country", Field: "country", Transform: "reverse"}}}, "error: profile field country has an unknown transform: reverse, expected one of: asNumber,asOrganization,lowercase,uppercase,ip,domain"},
+		{"nested under another field", Profile{Fields: []Field{{Name: "geo", Field: "country"}, {Name: "geo.city", Field: "city"}}}, "error: profile field geo.city is nested under another profile field: geo"},
+		{"flat names can overlap", Profile{Flat: true, Fields: []Field{{Name: "geo", Field: "country"}, {Name: "geo.city", Field: "city"}}}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.profile.Validate()
+			if test.err == "" && err != nil {
+				t.Errorf("got error %s, expected none", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("got error %v, expected %s", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"github.com/BenB196/ip-api-proxy/outputProfile"
+	"sort"
+	"strings"
+)
+
+/*
+getProfile - gets a configured output profile by name
+name - profile name passed with profile=name
+ */
+func getProfile(name string) (*outputProfile.Profile, error) {
+	profile, ok := LoadedConfig.Profiles[name]
+	if !ok {
+		var names []string
+		for profileName := range LoadedConfig.Profiles {
+			names = append(names, profileName)
+		}
+		sort.Strings(names)
+		return nil, errors.New("invalid profile provided, expected one of: " + strings.Join(names, ","))
+	}
+	return &profile, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-proxy/outputProfile"
+	"testing"
+)
+
+func TestGetProfile(t *testing.T) {
+	custom := outputProfile.Profile{Flat: true, Fields: []outputProfile.Field{{Name: "ip", Field: "query"}}}
+	LoadedConfig.Profiles = map[string]outputProfile.Profile{
+		"ecs":    custom,
+		"otel":   outputProfile.Builtin["otel"],
+		"custom": custom,
+	}
+	defer func() {
+		LoadedConfig.Profiles = nil
+	}()
+
+	tests := []struct {
+		name     string
+		profile  string
+		expected string
+		err      string
+	}{
+		{"custom", "custom", "ip", ""},
+		{"configured over a builtin", "ecs", "ip", ""},
+		{"builtin", "otel", "client.address", ""},
+		{"unknown", "geo", "", "invalid profile provided, expected one of: custom,ecs,otel"},
+		{"names are case sensitive", "ECS", "", "invalid profile provided, expected one of: custom,ecs,otel"},
+		{"empty", "", "", "invalid profile provided, expected one of: custom,ecs,otel"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			profile, err := getProfile(test.profile)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if profile.Fields[0].Name != test.expected {
+				t.Errorf("got first field %s, expected %s", profile.Fields[0].Name, test.expected)
+			}
+		})
+	}
+}
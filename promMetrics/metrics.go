@@ -86,12 +86,36 @@ var (
 		Name: "ip_api_proxy_failed_single_queries_total",
 		Help: "The total number of failed single queries",
 	})
+	streamRequestsProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ip_api_proxy_stream_requests_processed_total",
+		Help: "The total number of stream requests processed",
+	})
+	streamQueriesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ip_api_proxy_stream_queries_processed_total",
+		Help: "The total number of stream queries processed",
+	})
+	streamsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ip_api_proxy_streams_active",
+		Help: "The current number of open stream requests",
+	})
 	handlerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "ip_api_proxy_handler_requests_total",
 		Help: "Total number of requests by HTTP status code",
 	},
 	[]string{"code"},
 	)
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ip_api_proxy_grpc_requests_total",
+		Help: "Total number of gRPC requests by method and status code",
+	},
+	[]string{"method","code"},
+	)
+	reverseProxyLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ip_api_proxy_reverse_proxy_lookups_total",
+		Help: "Total number of reverse proxy client lookups by result",
+	},
+	[]string{"result"},
+	)
 )
 
 func IncrementRequestsProcessed() {
@@ -178,6 +202,30 @@ func IncrementFailedSingleQueries() {
 	failedSingleQueries.Inc()
 }
 
+func IncrementStreamRequestsProcessed() {
+	streamRequestsProcessed.Inc()
+}
+
+func IncrementStreamQueriesProcessed() {
+	streamQueriesProcessed.Inc()
+}
+
+func IncrementStreamsActive() {
+	streamsActive.Inc()
+}
+
+func DecreaseStreamsActive() {
+	streamsActive.Dec()
+}
+
 func IncrementHandlerRequests(code string)  {
 	handlerRequests.With(prometheus.Labels{"code":code}).Inc()
-}
\ No newline at end of file
+}
+
+func IncrementGRPCRequests(method string, code string)  {
+	grpcRequests.With(prometheus.Labels{"method":method,"code":code}).Inc()
+}
+
+func IncrementReverseProxyLookups(result string)  {
+	reverseProxyLookups.With(prometheus.Labels{"result":result}).Inc()
+}
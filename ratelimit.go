@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+//Limiters of the requests forwarded to IP-API, single and batch requests are limited separately like IP-API does
+var (
+	singleLimiter *rate.Limiter
+	batchLimiter  *rate.Limiter
+)
+
+/*
+initRateLimiters - creates the IP-API request limiters from the loaded config
+requests are spread evenly over the interval, so a burst can't go over IP-API's limit
+ */
+func initRateLimiters() {
+	singleLimiter = newLimiter(*LoadedConfig.RateLimit.SingleRequests, *LoadedConfig.RateLimit.IntervalDuration)
+	batchLimiter = newLimiter(*LoadedConfig.RateLimit.BatchRequests, *LoadedConfig.RateLimit.IntervalDuration)
+}
+
+/*
+newLimiter - creates a limiter of requests per interval
+requests - requests allowed per interval, 0 for unlimited
+interval - interval duration
+ */
+func newLimiter(requests int, interval time.Duration) *rate.Limiter {
+	if requests == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Every(interval/time.Duration(requests)), 1)
+}
+
+/*
+waitLimiter - blocks until a request may be forwarded to IP-API
+limiter - singleLimiter or batchLimiter, nil is unlimited
+ctx - context, waiting stops with its error when it is done
+ */
+func waitLimiter(ctx context.Context, limiter *rate.Limiter) error {
+	if limiter == nil {
+		return nil
+	}
+	return limiter.Wait(ctx)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"github.com/BenB196/ip-api-proxy/utils"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+)
+
+/*
+startReverseProxy - starts the geo-enriching reverse proxy on the reverse proxy port, in front of the configured backend
+requests are forwarded with the client's location in the configured headers
+
+returns
+http server, serving in the background
+error if the port can't be listened on
+ */
+func startReverseProxy() (*http.Server, error) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(LoadedConfig.ReverseProxy.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: rewriteProxyRequest,
+	}
+	server := &http.Server{Handler: proxy}
+
+	log.Println("Starting reverse proxy on port " + strconv.Itoa(LoadedConfig.ReverseProxy.Port) + " for " + LoadedConfig.ReverseProxy.BackendURL.Redacted() + "...")
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Println("error: reverse proxy: " + err.Error())
+		}
+	}()
+	return server, nil
+}
+
+/*
+rewriteProxyRequest - points a request at the backend and adds the client's geo headers
+geo headers sent by the client are always removed, so a client can't pick its own location
+r - proxy request
+ */
+func rewriteProxyRequest(r *httputil.ProxyRequest) {
+	r.SetURL(LoadedConfig.ReverseProxy.BackendURL)
+	//extend the forwarding chain of trusted proxies, and start a new one for anyone else
+	if remoteIP := parseHostIP(r.In.RemoteAddr); remoteIP != nil && trustedProxy(remoteIP) {
+		r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+	}
+	r.SetXForwarded()
+	//keep the host the client asked for, like a reverse proxy in front of a site is expected to
+	r.Out.Host = r.In.Host
+
+	for header := range LoadedConfig.ReverseProxy.Headers {
+		r.Out.Header.Del(header)
+	}
+
+	ip := clientIP(r.In)
+	if ip == "" {
+		return
+	}
+	location := proxyLocation(ip)
+	if location == nil || location.Status != "success" {
+		return
+	}
+
+	for header, field := range LoadedConfig.ReverseProxy.Headers {
+		var value string
+		if field == config.ASNField {
+			if asn, _ := utils.ParseAS(location.AS); asn != 0 {
+				value = strconv.Itoa(asn)
+			}
+		} else {
+			value, _ = cache.LocationFieldValue(location, field)
+		}
+		if value != "" {
+			r.Out.Header.Set(header, value)
+		}
+	}
+}
+
+/*
+proxyLocation - gets the location of a client of the reverse proxy, from cache or within the lookup budget
+a lookup that takes longer than the budget keeps running in the background, so the client's next request finds it in cache
+ip - client ip
+
+returns
+ip_api location, nil if it isn't known within the budget
+ */
+func proxyLocation(ip string) *ip_api.Location {
+	location, result := lookupWithinBudget(ip, cache.AllFields, *LoadedConfig.ReverseProxy.LookupBudgetDuration)
+	promMetrics.IncrementReverseProxyLookups(result)
+	return location
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+setupReverseProxy - points the reverse proxy at a test backend, trusting 10.0.0.0/8 as proxies
+budget - lookup budget
+
+returns
+reverse proxy
+the last request the backend got
+ */
+func setupReverseProxy(t *testing.T, budget time.Duration) (*httputil.ReverseProxy, func() *http.Request) {
+	var backendRequest *http.Request
+	var backendMutex sync.Mutex
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendMutex.Lock()
+		backendRequest = r
+		backendMutex.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	backendURL, _ := url.Parse(backend.URL)
+	_, trustedNetwork, _ := net.ParseCIDR("10.0.0.0/8")
+
+	LoadedConfig.TrustedNetworks = []*net.IPNet{trustedNetwork}
+	LoadedConfig.ReverseProxy = config.ReverseProxy{
+		BackendURL:           backendURL,
+		Headers:              map[string]string{"X-Geo-Country": "countryCode", "X-Geo-City": "city", "X-Geo-ASN": config.ASNField},
+		LookupBudgetDuration: &budget,
+	}
+	t.Cleanup(func() {
+		backend.Close()
+		LoadedConfig.TrustedNetworks = nil
+		LoadedConfig.ReverseProxy = config.ReverseProxy{}
+	})
+
+	//the backend is reached directly, even while IP-API requests go to a fake
+	proxy := &httputil.ReverseProxy{Rewrite: rewriteProxyRequest, Transport: &http.Transport{}}
+	return proxy, func() *http.Request {
+		backendMutex.Lock()
+		defer backendMutex.Unlock()
+		return backendRequest
+	}
+}
+
+//proxyRequest - sends a request for example.com through the reverse proxy from a remote address
+func proxyRequest(t *testing.T, proxy *httputil.ReverseProxy, remoteAddr string, headers map[string]string) {
+	r := httptest.NewRequest("GET", "http://example.com/page", nil)
+	r.RemoteAddr = remoteAddr
+	for header, value := range headers {
+		r.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d %s, expected %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+}
+
+func TestRewriteProxyRequest(t *testing.T) {
+	proxy, backendRequest := setupReverseProxy(t, time.Second)
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", CountryCode: "US", City: "Mountain View", AS: "AS15169 Google LLC", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddLocation("1.1.1.1", ip_api.Location{Status: "fail", Message: "reserved range", Query: "1.1.1.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cache.DeleteLocation("8.8.8.8")
+		cache.DeleteLocation("1.1.1.1")
+	}()
+
+	geo := map[string]string{"X-Geo-Country": "US", "X-Geo-City": "Mountain View", "X-Geo-ASN": "15169"}
+	noGeo := map[string]string{"X-Geo-Country": "", "X-Geo-City": "", "X-Geo-ASN": ""}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		headers      map[string]string
+		geo          map[string]string
+		forwardedFor string
+	}{
+		{"client", "8.8.8.8:1234", nil, geo, "8.8.8.8"},
+		{"client geo headers are replaced", "8.8.8.8:1234", map[string]string{"X-Geo-Country": "FR", "X-Geo-City": "Paris", "X-Geo-ASN": "3215"}, geo, "8.8.8.8"},
+		{"forwarded for of an untrusted client is dropped", "8.8.8.8:1234", map[string]string{"X-Forwarded-For": "1.1.1.1"}, geo, "8.8.8.8"},
+		{"forwarded for of a trusted proxy is kept", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "8.8.8.8"}, geo, "8.8.8.8, 10.0.0.1"},
+		{"trusted proxy geo headers are replaced", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "8.8.8.8", "X-Geo-City": "Paris"}, geo, "8.8.8.8, 10.0.0.1"},
+		{"failed location removes client geo headers", "1.1.1.1:1234", map[string]string{"X-Geo-Country": "FR", "X-Geo-ASN": "3215"}, noGeo, "1.1.1.1"},
+		{"trusted proxy forwarding a failed location", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.1.1.1", "X-Geo-Country": "FR"}, noGeo, "1.1.1.1, 10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxyRequest(t, proxy, test.remoteAddr, test.headers)
+			r := backendRequest()
+			for header, expected := range test.geo {
+				if value := r.Header.Get(header); value != expected {
+					t.Errorf("got %s %q, expected %q", header, value, expected)
+				}
+			}
+			if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != test.forwardedFor {
+				t.Errorf("got X-Forwarded-For %q, expected %q", forwardedFor, test.forwardedFor)
+			}
+			if r.Host != "example.com" {
+				t.Errorf("got host %s, expected example.com", r.Host)
+			}
+		})
+	}
+}
+
+func TestRewriteProxyRequestBudget(t *testing.T) {
+	proxy, backendRequest := setupReverseProxy(t, 20*time.Millisecond)
+	release := make(chan struct{})
+	lookups := fakeIPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = w.Write([]byte(`{"status":"success","countryCode":"CH","city":"Zurich","as":"AS19281 Quad9","query":"9.9.9.9"}`))
+	})
+	defer cache.DeleteLocation("9.9.9.9")
+
+	//the lookup outlasts the budget, so the request is forwarded without geo headers, including the client's own
+	proxyRequest(t, proxy, "9.9.9.9:1234", map[string]string{"X-Geo-Country": "FR", "X-Geo-City": "Paris"})
+	for _, header := range []string{"X-Geo-Country", "X-Geo-City", "X-Geo-ASN"} {
+		if value := backendRequest().Header.Get(header); value != "" {
+			t.Errorf("got %s %q, expected none", header, value)
+		}
+	}
+
+	//the lookup keeps running in the background and caches the location for the next request
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pendingLookupsMutex.Lock()
+		pending := len(pendingLookups)
+		pendingLookupsMutex.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("got the background lookup still running, expected it done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	proxyRequest(t, proxy, "9.9.9.9:1234", nil)
+	expected := map[string]string{"X-Geo-Country": "CH", "X-Geo-City": "Zurich", "X-Geo-ASN": "19281"}
+	for header, value := range expected {
+		if got := backendRequest().Header.Get(header); got != value {
+			t.Errorf("got %s %q, expected %q", header, got, value)
+		}
+	}
+	if len(lookups()) != 1 {
+		t.Errorf("got %d lookups, expected 1", len(lookups()))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"google.golang.org/grpc"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//shutdownPollInterval - how often a shutdown checks if lookups and jobs have finished
+const shutdownPollInterval = 50 * time.Millisecond
+
+//upstreamLookups - lookups forwarded to IP-API which haven't been cached yet, a shutdown waits for them before the final cache write
+var upstreamLookups int64
+
+//trackUpstreamLookup - counts a lookup forwarded to IP-API, until the returned func is called
+func trackUpstreamLookup() func() {
+	atomic.AddInt64(&upstreamLookups, 1)
+	return func() {
+		atomic.AddInt64(&upstreamLookups, -1)
+	}
+}
+
+/*
+shutdownServers - servers stopped by a graceful shutdown
+http - main http server
+grpc - gRPC server, nil if it isn't enabled
+dns - DNS front-end, nil if it isn't enabled
+reverseProxy - reverse proxy server, nil if it isn't enabled
+stopCacheWriter - stops the periodic cache write, nil if cache persist isn't enabled
+ */
+type shutdownServers struct {
+	http            *http.Server
+	grpc            *grpc.Server
+	dns             *dnsServer
+	reverseProxy    *http.Server
+	stopCacheWriter func()
+}
+
+/*
+startCacheWriter - writes the cache every interval until stopped
+interval - time between cache writes
+
+returns
+func which stops the ticker and waits for a write in progress to finish
+ */
+func startCacheWriter(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				if err := cache.WriteCache(&LoadedConfig.Cache.WriteLocation); err != nil {
+					log.Println("error: writing cache: " + err.Error())
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		wg.Wait()
+	}
+}
+
+/*
+waitForShutdown - blocks until SIGINT or SIGTERM, then shuts down gracefully
+a second signal exits right away, without waiting for the shutdown to finish
+servers - servers to stop
+ */
+func waitForShutdown(servers shutdownServers) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	received := <-signals
+	log.Println("Received " + received.String() + ", shutting down...")
+	go func() {
+		received := <-signals
+		log.Println("Received " + received.String() + " again, exiting without finishing the shutdown")
+		os.Exit(1)
+	}()
+
+	shutdown(servers)
+}
+
+/*
+shutdown - stops accepting connections, drains in-flight requests, jobs and upstream lookups, and writes the cache one final time
+draining stops after the shutdown timeout, the cache is written either way
+servers - servers to stop
+ */
+func shutdown(servers shutdownServers) {
+	ctx, cancel := context.WithTimeout(context.Background(), *LoadedConfig.ShutdownTimeoutDuration)
+	defer cancel()
+
+	//stop every server from accepting connections at once, then drain them
+	var wg sync.WaitGroup
+	for name, server := range map[string]*http.Server{"server": servers.http, "reverse proxy": servers.reverseProxy} {
+		if server == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Println("error: draining " + name + ", closing remaining connections: " + err.Error())
+				_ = server.Close()
+			}
+		}(name, server)
+	}
+	if servers.grpc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				servers.grpc.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				log.Println("error: draining gRPC server, closing remaining connections: " + ctx.Err().Error())
+				servers.grpc.Stop()
+			}
+		}()
+	}
+	if servers.dns != nil {
+		if err := servers.dns.Close(); err != nil {
+			log.Println("error: closing DNS server: " + err.Error())
+		}
+	}
+	wg.Wait()
+
+	//jobs are resumed from their saved progress on the next start
+	if LoadedConfig.Jobs.Enabled {
+		stopJobs(ctx)
+	}
+
+	//lookups of background work (ex: reverse proxy and DNS lookups) are cached once IP-API answers
+	if !waitUntil(ctx, func() bool { return atomic.LoadInt64(&upstreamLookups) == 0 }) {
+		log.Println("error: shutdown timed out with " + strconv.FormatInt(atomic.LoadInt64(&upstreamLookups), 10) + " upstream lookups pending")
+	}
+
+	//the final write is the last one, periodic writes are stopped first
+	if servers.stopCacheWriter != nil {
+		servers.stopCacheWriter()
+	}
+	if LoadedConfig.Cache.Persist {
+		if err := cache.WriteCache(&LoadedConfig.Cache.WriteLocation); err != nil {
+			log.Println("error: writing cache: " + err.Error())
+		}
+	}
+	log.Println("Shutdown complete")
+}
+
+/*
+waitUntil - waits until a condition is met or ctx is done
+done - condition, checked every shutdownPollInterval
+
+returns
+true if the condition was met
+ */
+func waitUntil(ctx context.Context, done func() bool) bool {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !done() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return done()
+		}
+	}
+	return true
+}
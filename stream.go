@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"github.com/BenB196/ip-api-proxy/promMetrics"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//streamPath - path of the streaming batch endpoint
+const streamPath = "/batch/stream"
+
+//streamBufferSize - results held before the stream stops reading queries, so a slow client slows down the whole stream
+const streamBufferSize = 100
+
+//streamFlushDelay - longest a cache miss waits for more misses before it is sent upstream in a smaller batch
+const streamFlushDelay = 250 * time.Millisecond
+
+//maxStreamLineLength - longest query line accepted
+const maxStreamLineLength = 64 * 1024
+
+/*
+streamQuery - a single query of a stream
+query - query with its own lang, Fields holds the line's raw fields value
+fields - parsed fields to output
+lang - lang the query is cached under
+ */
+type streamQuery struct {
+	query  ip_api.QueryIP
+	fields cache.Fields
+	lang   string
+}
+
+/*
+StreamProgress - counts of a stream, sent as progress lines and as trailers
+Read - queries read from the request
+Cached - queries answered from cache
+Fetched - queries looked up with IP-API
+Failed - queries which failed (invalid lines, failed lookups, IP-API errors)
+Written - results written to the response
+ */
+type StreamProgress struct {
+	Read    int64 `json:"read"`
+	Cached  int64 `json:"cached"`
+	Fetched int64 `json:"fetched"`
+	Failed  int64 `json:"failed"`
+	Written int64 `json:"written"`
+}
+
+/*
+ipAPIStream - handles streaming batch requests, POST /batch/stream
+the request body holds one query per line, either a plain query (8.8.8.8) or a batch query object ({"query": "8.8.8.8", "fields": "country", "lang": "de"})
+every result is written as an NDJSON line as soon as it resolves, so results are not in request order
+cache hits are written straight away, misses are sent to IP-API in batches under the batch rate limit
+ */
+func ipAPIStream(w http.ResponseWriter, r *http.Request) {
+	//increment requests processed
+	promMetrics.IncrementRequestsProcessed()
+	promMetrics.IncrementStreamRequestsProcessed()
+
+	options := outputOptions{format: formatNDJSON}
+	setContentType(w, options)
+
+	location := ip_api.Location{Status: "fail"}
+
+	if r.Method != "POST" {
+		location.Message = streamPath + " endpoint only supports POST requests."
+		log.Println("Failed stream request: " + location.Message)
+		promMetrics.IncrementHandlerRequests("405")
+		writeLocation(w, http.StatusMethodNotAllowed, &location, options)
+		return
+	}
+
+	fields, lang, key, progressInterval, err := parseStreamRequest(r, &options)
+	if err != nil {
+		location.Message = err.Error()
+		log.Println("Failed stream request: " + err.Error())
+		promMetrics.IncrementHandlerRequests("400")
+		promMetrics.IncrementFailedRequests()
+		writeLocation(w, http.StatusBadRequest, &location, options)
+		return
+	}
+
+	//keep reading queries after the first results are written
+	responseController := http.NewResponseController(w)
+	err = responseController.EnableFullDuplex()
+	if err != nil && LoadedConfig.Debugging {
+		log.Println("Stream request without full duplex: " + err.Error())
+	}
+
+	promMetrics.IncrementStreamsActive()
+	defer promMetrics.DecreaseStreamsActive()
+
+	//stop reading and looking up as soon as the client goes away
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var progress StreamProgress
+	results := make(chan ip_api.Location, streamBufferSize)
+	batches := make(chan []streamQuery, 1)
+
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		defer close(batches)
+		readStream(ctx, r, fields, lang, results, batches, &progress)
+	}()
+	go func() {
+		defer workers.Done()
+		fetchStream(ctx, batches, lang, key, results, &progress)
+	}()
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	w.Header().Set("Trailer", "X-Stream-Read, X-Stream-Cached, X-Stream-Fetched, X-Stream-Failed, X-Stream-Written")
+	promMetrics.IncrementHandlerRequests("200")
+	w.WriteHeader(http.StatusOK)
+
+	var progressTicks <-chan time.Time
+	if progressInterval > 0 {
+		progressTicker := time.NewTicker(progressInterval)
+		defer progressTicker.Stop()
+		progressTicks = progressTicker.C
+	}
+
+	for {
+		var line []byte
+		select {
+		case result, ok := <-results:
+			if !ok {
+				writeStreamTrailers(w, &progress)
+				return
+			}
+			line, _ = json.Marshal(toOutput(&result, options))
+			atomic.AddInt64(&progress.Written, 1)
+		case <-progressTicks:
+			line, _ = json.Marshal(struct {
+				Progress StreamProgress `json:"progress"`
+			}{loadStreamProgress(&progress)})
+		}
+
+		//a failed write means the client is gone, keep draining results until the workers stop
+		if ctx.Err() == nil {
+			_, err = w.Write(append(line, '\n'))
+			if err == nil {
+				err = responseController.Flush()
+			}
+			if err != nil {
+				log.Println("Stream request cancelled: " + err.Error())
+				cancel()
+			}
+		}
+	}
+}
+
+/*
+parseStreamRequest - reads the query parameters of a stream request
+options - outputOptions to set the ecs, ecsPrefix and profile of
+
+returns
+fields of queries without their own
+validated lang
+IP-API key
+interval between progress lines, 0 for none
+error
+ */
+func parseStreamRequest(r *http.Request, options *outputOptions) (cache.Fields, string, string, time.Duration, error) {
+	query := r.URL.Query()
+
+	err := parseOutputParams(query, options)
+	if err != nil {
+		return 0, "", "", 0, err
+	}
+
+	fields, err := cache.ParseFields(query.Get("fields"))
+	if err != nil {
+		return 0, "", "", 0, err
+	}
+	if fields == 0 && options.profile != nil {
+		fields = options.profile.RequiredFields()
+	}
+
+	var lang string
+	if query.Get("lang") != "" {
+		lang, err = ip_api.ValidateLang(query.Get("lang"))
+		if err != nil {
+			return 0, "", "", 0, err
+		}
+	}
+
+	key := LoadedConfig.APIKey
+	if query.Get("key") != "" {
+		key = query.Get("key")
+	}
+
+	var progressInterval time.Duration
+	if query.Get("progress") != "" {
+		progressInterval, err = time.ParseDuration(query.Get("progress"))
+		if err != nil || progressInterval <= 0 {
+			return 0, "", "", 0, errors.New("invalid progress provided, expected a duration (ex: 5s)")
+		}
+	}
+
+	return fields, lang, key, progressInterval, nil
+}
+
+/*
+parseOutputParams - reads the ecs, ecsPrefix and profile parameters of a request
+query - request query parameters
+options - outputOptions to set the ecs, ecsPrefix and profile of
+ */
+func parseOutputParams(query url.Values, options *outputOptions) error {
+	options.ecs = parseEcs(query.Get("ecs"))
+	if ecsPrefix := query.Get("ecsPrefix"); ecsPrefix != "" {
+		if !validEcsPrefix(ecsPrefix) {
+			return errors.New("invalid ecsPrefix provided, expected one of: " + strings.Join(ecsPrefixes, ","))
+		}
+		options.ecsPrefix = ecsPrefix
+	}
+
+	if profile := query.Get("profile"); profile != "" {
+		var err error
+		options.profile, err = getProfile(profile)
+		if err != nil {
+			return err
+		}
+		if options.ecs != "" {
+			options.profile = nil
+			return errors.New("profile and ecs cannot be combined")
+		}
+	}
+
+	return nil
+}
+
+/*
+readStream - reads the queries of a stream, writes cache hits and invalid lines to results and groups misses into batches
+misses are sent upstream once a batch is full, or once no more misses arrived within streamFlushDelay
+ */
+func readStream(ctx context.Context, r *http.Request, fields cache.Fields, lang string, results chan<- ip_api.Location, batches chan<- []streamQuery, progress *StreamProgress) {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 4096), maxStreamLineLength)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			log.Println("Failed stream request: reading queries: " + err.Error())
+		}
+	}()
+
+	var pending []streamQuery
+	flushTimer := time.NewTimer(streamFlushDelay)
+	flushTimer.Stop()
+	defer flushTimer.Stop()
+
+	flush := func() bool {
+		flushTimer.Stop()
+		if len(pending) == 0 {
+			return true
+		}
+		select {
+		case batches <- pending:
+			pending = nil
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flushTimer.C:
+			if !flush() {
+				return
+			}
+		case line, ok := <-lines:
+			if !ok {
+				flush()
+				return
+			}
+
+			query, err := parseStreamLine(line, fields, lang)
+			if query == nil && err == nil {
+				//blank line
+				continue
+			}
+
+			atomic.AddInt64(&progress.Read, 1)
+			promMetrics.IncrementStreamQueriesProcessed()
+			promMetrics.IncrementQueriesProcessed()
+
+			var result *ip_api.Location
+			if err != nil {
+				result = &ip_api.Location{Status: "fail", Message: err.Error()}
+				if query != nil {
+					result.Query = query.query.Query
+				}
+				atomic.AddInt64(&progress.Failed, 1)
+				promMetrics.IncrementFailedQueries()
+			} else {
+				var found bool
+				result, found, err = cache.GetLocation(query.query.Query+query.lang, query.fields)
+				if err != nil {
+					log.Println(err)
+				}
+				if found {
+					promMetrics.IncrementCacheHits()
+					atomic.AddInt64(&progress.Cached, 1)
+					if result.Status != "fail" {
+						promMetrics.IncrementSuccessfulQueries()
+					} else {
+						atomic.AddInt64(&progress.Failed, 1)
+						promMetrics.IncrementFailedQueries()
+					}
+				} else {
+					result = nil
+					pending = append(pending, *query)
+					if len(pending) >= maxBatchQueries {
+						if !flush() {
+							return
+						}
+					} else if len(pending) == 1 {
+						flushTimer.Reset(streamFlushDelay)
+					}
+				}
+			}
+
+			if result != nil {
+				select {
+				case results <- *result:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+/*
+parseStreamLine - parses a single query line of a stream
+line - plain query, or a json batch query object
+fields - fields of queries without their own
+lang - lang of queries without their own
+
+returns
+streamQuery, nil for a blank line
+error for an invalid line
+ */
+func parseStreamLine(line string, fields cache.Fields, lang string) (*streamQuery, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil, nil
+	}
+
+	var queryIP ip_api.QueryIP
+	if strings.HasPrefix(line, "{") {
+		err := json.Unmarshal([]byte(line), &queryIP)
+		if err != nil {
+			return nil, errors.New("invalid query line: " + err.Error())
+		}
+	} else {
+		queryIP.Query = line
+	}
+
+	return newStreamQuery(queryIP, fields, lang)
+}
+
+/*
+newStreamQuery - validates a batch query, and the fields and lang it overrides
+queryIP - batch query
+fields - fields of queries without their own
+lang - lang of queries without their own
+
+returns
+streamQuery
+error for an invalid query
+ */
+func newStreamQuery(queryIP ip_api.QueryIP, fields cache.Fields, lang string) (*streamQuery, error) {
+	query := &streamQuery{query: queryIP, fields: fields, lang: lang}
+	if query.query.Query == "" {
+		return query, errors.New("request is blank")
+	}
+
+	if query.query.Fields != "" {
+		queryFields, err := cache.ParseFields(query.query.Fields)
+		if err != nil {
+			return query, err
+		}
+		query.fields = queryFields
+	}
+
+	if query.query.Lang != "" {
+		queryLang, err := ip_api.ValidateLang(query.query.Lang)
+		if err != nil {
+			return query, err
+		}
+		query.lang = queryLang
+	}
+	query.query.Lang = query.lang
+
+	return query, nil
+}
+
+/*
+fetchStream - looks up batches of cache misses with IP-API and writes their results
+an IP-API error fails every query of its batch, the stream carries on with the next batch
+ */
+func fetchStream(ctx context.Context, batches <-chan []streamQuery, lang string, key string, results chan<- ip_api.Location, progress *StreamProgress) {
+	for batch := range batches {
+		queries := make([]ip_api.QueryIP, len(batch))
+		for i, query := range batch {
+			queries[i] = query.query
+		}
+
+		locations, err := fetchLocations(ctx, queries, lang, key)
+		if ctx.Err() != nil {
+			return
+		}
+
+		for i, query := range batch {
+			var result ip_api.Location
+			if err != nil {
+				result = ip_api.Location{Status: "fail", Message: err.Error(), Query: query.query.Query}
+			} else if projected, found, _ := cache.GetLocation(query.query.Query+query.lang, query.fields); found {
+				result = *projected
+			} else {
+				result = locations[i]
+			}
+
+			if result.Status != "fail" {
+				atomic.AddInt64(&progress.Fetched, 1)
+				promMetrics.IncrementSuccessfulQueries()
+			} else {
+				if err == nil {
+					atomic.AddInt64(&progress.Fetched, 1)
+				}
+				atomic.AddInt64(&progress.Failed, 1)
+				promMetrics.IncrementFailedQueries()
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if err != nil {
+			log.Println("Failed stream batch: " + err.Error())
+		}
+	}
+}
+
+//loadStreamProgress - reads a consistent enough copy of the progress counters while the stream runs
+func loadStreamProgress(progress *StreamProgress) StreamProgress {
+	return StreamProgress{
+		Read:    atomic.LoadInt64(&progress.Read),
+		Cached:  atomic.LoadInt64(&progress.Cached),
+		Fetched: atomic.LoadInt64(&progress.Fetched),
+		Failed:  atomic.LoadInt64(&progress.Failed),
+		Written: atomic.LoadInt64(&progress.Written),
+	}
+}
+
+//writeStreamTrailers - sets the final progress counters as response trailers
+func writeStreamTrailers(w http.ResponseWriter, progress *StreamProgress) {
+	finalProgress := loadStreamProgress(progress)
+	w.Header().Set("X-Stream-Read", strconv.FormatInt(finalProgress.Read, 10))
+	w.Header().Set("X-Stream-Cached", strconv.FormatInt(finalProgress.Cached, 10))
+	w.Header().Set("X-Stream-Fetched", strconv.FormatInt(finalProgress.Fetched, 10))
+	w.Header().Set("X-Stream-Failed", strconv.FormatInt(finalProgress.Failed, 10))
+	w.Header().Set("X-Stream-Written", strconv.FormatInt(finalProgress.Written, 10))
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/BenB196/ip-api-go-pkg"
+	"github.com/BenB196/ip-api-proxy/cache"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+streamTest - a stream request to a test server, with its queries written as the test goes
+queries - writes query lines to the request body, closing it ends the stream
+response - response, read while the queries are still being written
+lines - reads the response lines
+handled - closed once ipAPIStream has returned
+ */
+type streamTest struct {
+	queries  *io.PipeWriter
+	response *http.Response
+	lines    *bufio.Scanner
+	handled  chan struct{}
+}
+
+/*
+startStream - starts a stream request and writes its first query lines
+ctx - context of the request, cancelling it disconnects the client
+params - query parameters of the request
+firstLines - query lines written before the response is awaited
+ */
+func startStream(t *testing.T, ctx context.Context, params string, firstLines string) *streamTest {
+	test := &streamTest{handled: make(chan struct{})}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(test.handled)
+		ipAPIStream(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	body, queries := io.Pipe()
+	test.queries = queries
+	t.Cleanup(func() {
+		_ = queries.Close()
+	})
+	go func() {
+		_, _ = queries.Write([]byte(firstLines))
+	}()
+
+	r, err := http.NewRequestWithContext(ctx, "POST", server.URL+streamPath+params, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the stream server is reached directly, even while IP-API requests go to a fake
+	client := &http.Client{Transport: &http.Transport{}}
+	test.response, err = client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = test.response.Body.Close()
+	})
+	if test.response.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, expected %d", test.response.StatusCode, http.StatusOK)
+	}
+	test.lines = bufio.NewScanner(test.response.Body)
+	return test
+}
+
+//nextLocation - reads the next result line of a stream, skipping progress lines
+func (s *streamTest) nextLocation(t *testing.T) ip_api.Location {
+	for s.lines.Scan() {
+		if strings.HasPrefix(s.lines.Text(), `{"progress"`) {
+			continue
+		}
+		var location ip_api.Location
+		if err := json.Unmarshal(s.lines.Bytes(), &location); err != nil {
+			t.Fatalf("got line %q: %v", s.lines.Text(), err)
+		}
+		return location
+	}
+	t.Fatalf("got the stream ended (%v), expected another result", s.lines.Err())
+	return ip_api.Location{}
+}
+
+//fakeBatchIPAPI - fakes IP-API's batch endpoint, failing every query so no reverse lookups are made, and records the batch sizes
+func fakeBatchIPAPI(t *testing.T) func() []int {
+	var batchSizes []int
+	var batchesMutex sync.Mutex
+	fakeIPAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var queries []ip_api.QueryIP
+		if err := json.NewDecoder(r.Body).Decode(&queries); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		batchesMutex.Lock()
+		batchSizes = append(batchSizes, len(queries))
+		batchesMutex.Unlock()
+
+		locations := make([]ip_api.Location, len(queries))
+		for i, query := range queries {
+			locations[i] = ip_api.Location{Status: "fail", Message: "reserved range", Query: query.Query}
+		}
+		body, _ := json.Marshal(locations)
+		_, _ = w.Write(body)
+	})
+	return func() []int {
+		batchesMutex.Lock()
+		defer batchesMutex.Unlock()
+		return append([]int{}, batchSizes...)
+	}
+}
+
+func TestStreamCacheHits(t *testing.T) {
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Country: "United States", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddLocation("1.1.1.1", ip_api.Location{Status: "success", Country: "Australia", Query: "1.1.1.1"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cache.DeleteLocation("8.8.8.8")
+		cache.DeleteLocation("1.1.1.1")
+	}()
+
+	//every query is cached or invalid, so IP-API is never called
+	stream := startStream(t, context.Background(), "?fields=status,country,query", "8.8.8.8\n")
+
+	//cache hits are written while the client is still sending queries
+	if location := stream.nextLocation(t); location.Query != "8.8.8.8" || location.Country != "United States" {
+		t.Errorf("got %+v, expected 8.8.8.8 in the United States", location)
+	}
+	_, _ = stream.queries.Write([]byte("\n" + `{"query":"1.1.1.1","fields":"query"}` + "\n"))
+	if location := stream.nextLocation(t); location.Query != "1.1.1.1" || location.Country != "" {
+		t.Errorf("got %+v, expected 1.1.1.1 with its own fields", location)
+	}
+	_, _ = stream.queries.Write([]byte(`{"query":""}` + "\n"))
+	if location := stream.nextLocation(t); location.Status != "fail" || location.Message != "request is blank" {
+		t.Errorf("got %+v, expected a blank request failure", location)
+	}
+
+	_ = stream.queries.Close()
+	if stream.lines.Scan() {
+		t.Errorf("got line %q, expected the stream to end", stream.lines.Text())
+	}
+	expected := map[string]string{"X-Stream-Read": "3", "X-Stream-Cached": "2", "X-Stream-Fetched": "0", "X-Stream-Failed": "1", "X-Stream-Written": "3"}
+	for trailer, value := range expected {
+		if got := stream.response.Trailer.Get(trailer); got != value {
+			t.Errorf("got %s %q, expected %q", trailer, got, value)
+		}
+	}
+}
+
+func TestStreamMissBatches(t *testing.T) {
+	batchSizes := fakeBatchIPAPI(t)
+	batchLimiter = newLimiter(1, 100*time.Millisecond)
+	defer func() {
+		batchLimiter = nil
+	}()
+
+	var queries strings.Builder
+	var ips []string
+	for i := 0; i < 250; i++ {
+		ip := "100.64." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)
+		ips = append(ips, ip)
+		queries.WriteString(ip + "\n")
+	}
+	defer func() {
+		for _, ip := range ips {
+			cache.DeleteLocation(ip)
+		}
+	}()
+
+	started := time.Now()
+	stream := startStream(t, context.Background(), "", queries.String())
+	_ = stream.queries.Close()
+
+	results := map[string]bool{}
+	for stream.lines.Scan() {
+		var location ip_api.Location
+		if err := json.Unmarshal(stream.lines.Bytes(), &location); err != nil {
+			t.Fatalf("got line %q: %v", stream.lines.Text(), err)
+		}
+		results[location.Query] = true
+	}
+	if len(results) != len(ips) {
+		t.Errorf("got %d results, expected %d", len(results), len(ips))
+	}
+
+	//misses go upstream in batches of up to maxBatchQueries, one per rate limit interval
+	sizes := batchSizes()
+	if len(sizes) != 3 || sizes[0] != maxBatchQueries || sizes[1] != maxBatchQueries || sizes[2] != 50 {
+		t.Errorf("got batches of %v, expected [100 100 50]", sizes)
+	}
+	if elapsed := time.Since(started); elapsed < 200*time.Millisecond {
+		t.Errorf("got 3 batches in %s, expected them spread over the 100ms rate limit interval", elapsed)
+	}
+	expected := map[string]string{"X-Stream-Read": "250", "X-Stream-Cached": "0", "X-Stream-Fetched": "250", "X-Stream-Failed": "250", "X-Stream-Written": "250"}
+	for trailer, value := range expected {
+		if got := stream.response.Trailer.Get(trailer); got != value {
+			t.Errorf("got %s %q, expected %q", trailer, got, value)
+		}
+	}
+}
+
+func TestStreamClientDisconnect(t *testing.T) {
+	batchSizes := fakeBatchIPAPI(t)
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("8.8.8.8")
+	//the only batch the limiter allows is used up, so the miss waits on the limiter until the client goes away
+	batchLimiter = newLimiter(1, time.Hour)
+	batchLimiter.Allow()
+	defer func() {
+		batchLimiter = nil
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := startStream(t, ctx, "", "100.64.1.1\n8.8.8.8\n")
+	if location := stream.nextLocation(t); location.Query != "8.8.8.8" {
+		t.Fatalf("got %+v, expected the cached 8.8.8.8", location)
+	}
+
+	cancel()
+	select {
+	case <-stream.handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got the stream still running after the client disconnected, expected it cancelled")
+	}
+	if sizes := batchSizes(); len(sizes) != 0 {
+		t.Errorf("got batches of %v sent upstream, expected none", sizes)
+	}
+	if _, found, _ := cache.GetLocation("100.64.1.1", cache.AllFields); found {
+		t.Errorf("got 100.64.1.1 cached, expected it never looked up")
+	}
+}
+
+func TestStreamProgress(t *testing.T) {
+	if _, err := cache.AddLocation("8.8.8.8", ip_api.Location{Status: "success", Query: "8.8.8.8"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer cache.DeleteLocation("8.8.8.8")
+
+	stream := startStream(t, context.Background(), "?progress=10ms", "8.8.8.8\n")
+	stream.nextLocation(t)
+
+	//progress lines keep coming while the client hasn't sent its next query
+	var progress struct {
+		Progress *StreamProgress `json:"progress"`
+	}
+	if !stream.lines.Scan() {
+		t.Fatalf("got the stream ended (%v), expected a progress line", stream.lines.Err())
+	}
+	if err := json.Unmarshal(stream.lines.Bytes(), &progress); err != nil || progress.Progress == nil {
+		t.Fatalf("got line %q, expected a progress line", stream.lines.Text())
+	}
+	if *progress.Progress != (StreamProgress{Read: 1, Cached: 1, Written: 1}) {
+		t.Errorf("got progress %+v, expected 1 read, cached and written", *progress.Progress)
+	}
+
+	_ = stream.queries.Close()
+	_, _ = io.Copy(ioutil.Discard, stream.response.Body)
+	expected := map[string]string{"X-Stream-Read": "1", "X-Stream-Cached": "1", "X-Stream-Fetched": "0", "X-Stream-Failed": "0", "X-Stream-Written": "1"}
+	for trailer, value := range expected {
+		if got := stream.response.Trailer.Get(trailer); got != value {
+			t.Errorf("got %s %q, expected %q", trailer, got, value)
+		}
+	}
+}
+
+func TestParseStreamRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		lang     string
+		progress time.Duration
+		err      string
+	}{
+		{"defaults", "", "", 0, ""},
+		{"lang", "?lang=de", "de", 0, ""},
+		{"progress", "?progress=5s", "", 5 * time.Second, ""},
+		{"invalid lang", "?lang=xx", "", 0, "error: illegal lang value provided: xx"},
+		{"invalid progress", "?progress=soon", "", 0, "invalid progress provided, expected a duration (ex: 5s)"},
+		{"negative progress", "?progress=-1s", "", 0, "invalid progress provided, expected a duration (ex: 5s)"},
+		{"invalid fields", "?fields=unknown", "", 0, "error: illegal field provided: unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := outputOptions{format: formatNDJSON}
+			_, lang, _, progress, err := parseStreamRequest(httptest.NewRequest("POST", streamPath+test.query, nil), &options)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("got error %v, expected %s", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lang != test.lang || progress != test.progress {
+				t.Errorf("got lang %q and progress %s, expected %q and %s", lang, progress, test.lang, test.progress)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+ParseAS - splits an IP-API as value into its number and organization
+as - IP-API as value (ex: AS15169 Google LLC)
+
+returns
+AS number, 0 if as has no number
+organization name, all of as if it has no number
+ */
+func ParseAS(as string) (int, string) {
+	as = strings.TrimSpace(as)
+	if as == "" {
+		return 0, ""
+	}
+
+	asNumber := as
+	organization := ""
+	if i := strings.Index(as, " "); i >= 0 {
+		asNumber = as[:i]
+		organization = strings.TrimSpace(as[i+1:])
+	}
+
+	//AS numbers are 32 bit
+	if len(asNumber) > 2 && strings.EqualFold(asNumber[:2], "AS") {
+		if number, err := strconv.ParseUint(asNumber[2:], 10, 32); err == nil {
+			return int(number), organization
+		}
+	}
+	return 0, as
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseAS(t *testing.T) {
+	tests := []struct {
+		name         string
+		as           string
+		number       int
+		organization string
+	}{
+		{"number and name", "AS15169 Google LLC", 15169, "Google LLC"},
+		{"name with spaces", "AS13335 Cloudflare, Inc.", 13335, "Cloudflare, Inc."},
+		{"lower case prefix", "as3356 Level 3 Parent, LLC", 3356, "Level 3 Parent, LLC"},
+		{"surrounding spaces", "  AS15169   Google LLC  ", 15169, "Google LLC"},
+		{"number without name", "AS15169", 15169, ""},
+		{"empty", "", 0, ""},
+		{"spaces only", "   ", 0, ""},
+		{"AS without number", "AS", 0, "AS"},
+		{"AS without number with name", "AS Google LLC", 0, "AS Google LLC"},
+		{"non numeric number", "ASxyz Google LLC", 0, "ASxyz Google LLC"},
+		{"number over 32 bits", "AS4294967296 Google LLC", 0, "AS4294967296 Google LLC"},
+		{"name without AS", "Google LLC", 0, "Google LLC"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			number, organization := ParseAS(test.as)
+			if number != test.number || organization != test.organization {
+				t.Errorf("got %d %q, expected %d %q", number, organization, test.number, test.organization)
+			}
+		})
+	}
+}